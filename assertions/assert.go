@@ -64,3 +64,15 @@ func (assert Assert) String(param string, expected string, actual string) {
 		assert.t.Errorf("param: %s, expected: %s, actual: %s", param, expected, actual)
 	}
 }
+
+func (assert Assert) Uint64(param string, expected uint64, actual uint64) {
+	if expected != actual {
+		assert.t.Errorf("param: %s, expected: %d, actual: %d", param, expected, actual)
+	}
+}
+
+func (assert Assert) Int(param string, expected int, actual int) {
+	if expected != actual {
+		assert.t.Errorf("param: %s, expected: %d, actual: %d", param, expected, actual)
+	}
+}
@@ -0,0 +1,187 @@
+// Package client talks to a kvsapp server over tcp, one command at a time or pipelined.
+//
+// The server answers the messages on a connection strictly in the order they arrive, writing the
+// replies to everything in one read together, so a client may send any number of messages before
+// reading a reply. A Pipeline does that: it queues commands, sends them in one write and then
+// reads one reply for each, matching them up by position.
+//
+// A connection that is listening for changes or subscribed to channels is also sent frames the
+// client didn't ask for, between its replies; use a separate connection for those.
+package client
+
+import (
+	"errors"
+	"io"
+	"kvsapp/parsing"
+	"net"
+	"sync"
+)
+
+// Reply is the server's answer to one command.
+type Reply struct {
+	Kind    string   // the three-letter reply, such as "val", "ack", "nil" or "kvs"
+	Values  []string // its arguments, if any
+	Replies []Reply  // for "exc", the reply to each command the transaction ran
+}
+
+var ErrClientClosed = errors.New("client closed")
+
+type Client struct {
+	connection io.ReadWriteCloser
+	decoder    *parsing.Decoder
+	lock       sync.Mutex // one exchange at a time, so replies can't be taken by the wrong caller
+	queued     int        // commands queued in the open transaction, each answered in its 'exc'
+	closed     bool
+}
+
+// Dial connects to the server at address, such as "localhost:8000".
+func Dial(address string) (*Client, error) {
+	connection, err := net.Dial("tcp4", address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(connection)
+}
+
+// NewClient runs a client over a connection that is already open.
+func NewClient(connection io.ReadWriteCloser) (*Client, error) {
+	if connection == nil {
+		return nil, errors.New("parameter 'connection' must not be nil")
+	}
+	decoder, err := parsing.NewDecoder(connection, parsing.ReplyGrammar())
+	if err != nil {
+		return nil, err
+	}
+	return &Client{connection: connection, decoder: decoder}, nil
+}
+
+// Close says goodbye to the server and closes the connection.
+func (client *Client) Close() error {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	if client.closed {
+		return nil
+	}
+	client.closed = true
+	_, _ = client.connection.Write([]byte("bye"))
+	return client.connection.Close()
+}
+
+// Do sends one command and waits for its reply.
+func (client *Client) Do(command string, args ...string) (Reply, error) {
+	pipeline := client.Pipeline()
+	pipeline.Send(command, args...)
+	replies, err := pipeline.Exec()
+	if err != nil {
+		return Reply{}, err
+	}
+	return replies[0], nil
+}
+
+// Pipeline starts a batch of commands to send together.
+func (client *Client) Pipeline() *Pipeline {
+	return &Pipeline{client: client}
+}
+
+// Pipeline is a batch of commands, sent in one write by Exec.
+type Pipeline struct {
+	client   *Client
+	data     []byte
+	commands []string
+	err      error
+}
+
+// Send queues a command whose arguments are sent as they are, such as "put" with a key and value.
+func (pipeline *Pipeline) Send(command string, args ...string) {
+	data, err := parsing.CreateData(command, args...)
+	pipeline.add(command, data, err)
+}
+
+// SendMulti queues a command taking any number of arguments, such as "mgt" with its keys.
+func (pipeline *Pipeline) SendMulti(command string, args []string) {
+	data, err := parsing.CreateMultiData(command, args)
+	pipeline.add(command, data, err)
+}
+
+func (pipeline *Pipeline) add(command string, data []byte, err error) {
+	if pipeline.err != nil {
+		return
+	}
+	if err != nil {
+		pipeline.err = err
+		return
+	}
+	pipeline.data = append(pipeline.data, data...)
+	pipeline.commands = append(pipeline.commands, command)
+}
+
+// Len is the number of commands queued.
+func (pipeline *Pipeline) Len() int {
+	return len(pipeline.commands)
+}
+
+// Exec sends every queued command and returns their replies, in the same order, leaving the
+// pipeline empty. If a command couldn't be encoded nothing is sent.
+func (pipeline *Pipeline) Exec() ([]Reply, error) {
+	data, commands, err := pipeline.data, pipeline.commands, pipeline.err
+	pipeline.data, pipeline.commands, pipeline.err = nil, nil, nil
+	if err != nil {
+		return nil, err
+	}
+	if len(commands) == 0 {
+		return []Reply{}, nil
+	}
+	return pipeline.client.exchange(data, commands)
+}
+
+func (client *Client) exchange(data []byte, commands []string) ([]Reply, error) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	if client.closed {
+		return nil, ErrClientClosed
+	}
+
+	// the server may start answering before it has read everything, and will wait for those
+	// replies to be read before reading more, so the write can't hold up reading them...
+	written := make(chan error, 1)
+	go func() {
+		_, err := client.connection.Write(data)
+		written <- err
+	}()
+
+	replies := make([]Reply, len(commands))
+	for i, command := range commands {
+		reply, err := client.readReply(command)
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, <-written
+}
+
+func (client *Client) readReply(command string) (Reply, error) {
+	kind, values, err := client.decoder.Decode()
+	if err != nil {
+		return Reply{}, err
+	}
+	reply := Reply{Kind: kind, Values: values}
+
+	// keep count of what a transaction has queued, so the replies in its 'exc' can be read...
+	switch {
+	case (command == "mlt" || command == "dsc") && kind == "ack", kind == "abt":
+		client.queued = 0
+	case kind == "qud":
+		client.queued++
+	case kind == "exc":
+		queued := client.queued
+		client.queued = 0
+		reply.Replies = make([]Reply, queued)
+		for i := range reply.Replies {
+			if reply.Replies[i], err = client.readReply(""); err != nil {
+				return Reply{}, err
+			}
+		}
+	}
+	return reply, nil
+}
@@ -0,0 +1,127 @@
+package client_test
+
+import (
+	"io"
+	"kvsapp/assertions"
+	"kvsapp/client"
+	"net"
+	"testing"
+)
+
+// a server that expects exactly request, then answers with replies in one write...
+func createTestObject(t *testing.T, request string, replies string) *client.Client {
+	clientSide, serverSide := net.Pipe()
+	go func() {
+		received := make([]byte, len(request))
+		if _, err := io.ReadFull(serverSide, received); err != nil || string(received) != request {
+			t.Errorf("param: request, expected: %q, actual: %q (%v)", request, received, err)
+			serverSide.Close()
+			return
+		}
+		_, _ = serverSide.Write([]byte(replies))
+	}()
+	result, _ := client.NewClient(clientSide)
+	return result
+}
+
+func TestPipelineMatchesRepliesInOrder(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject(t,
+		"put11a11x"+"get11a"+"get11b"+"mgt1211a11b"+"grg11a115111",
+		"vsn111"+"val11x"+"nil"+"kvs1211a11x"+"val10")
+
+	pipeline := testObject.Pipeline()
+	pipeline.Send("put", "a", "x")
+	pipeline.Send("get", "a")
+	pipeline.Send("get", "b")
+	pipeline.SendMulti("mgt", []string{"a", "b"})
+	pipeline.Send("grg", "a", "5", "1")
+	assert.Int("len", 5, pipeline.Len())
+	replies, err := pipeline.Exec()
+	assert.Error(nil, err)
+	assert.Int("replies", 5, len(replies))
+	assert.Int("emptied", 0, pipeline.Len())
+
+	for i, expected := range []client.Reply{
+		{Kind: "vsn", Values: []string{"1"}},
+		{Kind: "val", Values: []string{"x"}},
+		{Kind: "nil"},
+		{Kind: "kvs", Values: []string{"a", "x"}},
+		{Kind: "val", Values: []string{""}},
+	} {
+		assert.String("kind", expected.Kind, replies[i].Kind)
+		assert.Int("values", len(expected.Values), len(replies[i].Values))
+		for j := 0; j < len(expected.Values) && j < len(replies[i].Values); j++ {
+			assert.String("value", expected.Values[j], replies[i].Values[j])
+		}
+	}
+}
+
+func TestTransactionRepliesAreNested(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject(t,
+		"mlt"+"put11a11x"+"get11a"+"exc"+"get11a",
+		"ack"+"qud"+"qud"+"exc"+"vsn111"+"val11x"+"val11x")
+
+	pipeline := testObject.Pipeline()
+	pipeline.Send("mlt")
+	pipeline.Send("put", "a", "x")
+	pipeline.Send("get", "a")
+	pipeline.Send("exc")
+	pipeline.Send("get", "a")
+	replies, err := pipeline.Exec()
+	assert.Error(nil, err)
+	assert.Int("replies", 5, len(replies))
+	assert.String("exc", "exc", replies[3].Kind)
+	assert.Int("nested", 2, len(replies[3].Replies))
+	assert.String("nested put", "vsn", replies[3].Replies[0].Kind)
+	assert.String("nested get", "val", replies[3].Replies[1].Kind)
+	assert.String("after", "val", replies[4].Kind)
+}
+
+func TestDo(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject(t, "get11a", "val13abc")
+	reply, err := testObject.Do("get", "a")
+	assert.Error(nil, err)
+	assert.String("kind", "val", reply.Kind)
+	assert.String("value", "abc", reply.Values[0])
+}
+
+func TestBadCommandSendsNothing(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	clientSide, _ := net.Pipe()
+	testObject, _ := client.NewClient(clientSide)
+
+	pipeline := testObject.Pipeline()
+	pipeline.Send("get", "a")
+	pipeline.Send("toolong")
+	replies, err := pipeline.Exec()
+	if err == nil || replies != nil {
+		t.Errorf("expected: error, actual: %v (%v)", replies, err)
+	}
+	assert.Int("emptied", 0, pipeline.Len())
+	replies, err = pipeline.Exec()
+	assert.Error(nil, err)
+	assert.Int("replies", 0, len(replies))
+}
+
+func TestClosedClient(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	clientSide, serverSide := net.Pipe()
+	go func() { _, _ = io.Copy(io.Discard, serverSide) }()
+	testObject, _ := client.NewClient(clientSide)
+	assert.Error(nil, testObject.Close())
+	_, err := testObject.Do("get", "a")
+	assert.Error(client.ErrClientClosed, err)
+
+	_, err = client.NewClient(nil)
+	if err == nil {
+		t.Error("expected: error, actual: nil")
+	}
+}
@@ -0,0 +1,104 @@
+package kvserver
+
+// matchGlob reports whether key matches pattern, where '*' matches any run of bytes, '?' matches
+// any single byte, '[...]' matches one byte from a set (ranges and a leading '^' allowed) and '\'
+// escapes the byte after it. A malformed pattern matches nothing.
+func matchGlob(pattern string, key string) bool {
+	// where to carry on from if the pattern stops matching: just after the last '*' seen, with
+	// that star taking one more byte of the key than it did last time. a later star replaces an
+	// earlier one, so each star is only ever retried once per byte of the key...
+	starPattern, starKey := -1, 0
+	p, k := 0, 0
+	for p < len(pattern) || k < len(key) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				starPattern, starKey = p+1, k
+				p++
+				continue
+			case '?':
+				if k < len(key) {
+					p, k = p+1, k+1
+					continue
+				}
+			case '[':
+				if k < len(key) {
+					matched, width, ok := matchGlobClass(pattern[p:], key[k])
+					if !ok {
+						return false
+					}
+					if matched {
+						p, k = p+width, k+1
+						continue
+					}
+				}
+			case '\\':
+				if p+1 >= len(pattern) {
+					return false
+				}
+				if k < len(key) && pattern[p+1] == key[k] {
+					p, k = p+2, k+1
+					continue
+				}
+			default:
+				if k < len(key) && pattern[p] == key[k] {
+					p, k = p+1, k+1
+					continue
+				}
+			}
+		}
+		if starPattern < 0 || starKey >= len(key) {
+			return false
+		}
+		starKey++
+		p, k = starPattern, starKey
+	}
+	return true
+}
+
+// matches value against the '[...]' class at the start of pattern, returning the width of the class...
+func matchGlobClass(pattern string, value byte) (matched bool, width int, ok bool) {
+	i := 1
+	negated := i < len(pattern) && pattern[i] == '^'
+	if negated {
+		i++
+	}
+	for first := true; i < len(pattern); first = false {
+		if pattern[i] == ']' && !first {
+			return matched != negated, i + 1, true
+		}
+		low := pattern[i]
+		if low == '\\' && i+1 < len(pattern) {
+			i++
+			low = pattern[i]
+		}
+		high := low
+		if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+			high = pattern[i+2]
+			i += 2
+		}
+		if low <= value && value <= high {
+			matched = true
+		}
+		i++
+	}
+	return false, 0, false
+}
+
+// the bytes every key matching pattern must start with...
+func globLiteralPrefix(pattern string) string {
+	prefix := make([]byte, 0, len(pattern))
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?', '[':
+			return string(prefix)
+		case '\\':
+			if i+1 >= len(pattern) {
+				return string(prefix)
+			}
+			i++
+		}
+		prefix = append(prefix, pattern[i])
+	}
+	return string(prefix)
+}
@@ -0,0 +1,58 @@
+package kvserver
+
+import (
+	"kvsapp/assertions"
+	"strings"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testCases := []struct {
+		pattern  string
+		key      string
+		expected bool
+	}{
+		{"*", "anything", true},
+		{"user:*", "user:42", true},
+		{"user:*", "users", false},
+		{"*:42", "user:42", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"key[0-9]", "key7", true},
+		{"key[0-9]", "keyx", false},
+		{"a\\*", "a*", true},
+		{"a\\*", "ab", false},
+		{"a/*", "a/b/c", true},
+		{"[abc", "a", false},
+		{"a\\", "a", false},
+		{"*a*", "", false},
+		{"**", "", true},
+		{"*b*c", "abxbc", true},
+		{"*?", "", false},
+		{"a*[0-9]", "abc9", true},
+		{"a*[0-9]", "abc9x", false},
+		// would take exponential time to fail if each star tried every split of the key...
+		{strings.Repeat("a*", 32) + "b", strings.Repeat("a", 200), false},
+		{strings.Repeat("*a", 32) + "*", strings.Repeat("a", 200), true},
+	}
+	for _, testCase := range testCases {
+		assert.TestBoolean(testCase.pattern+" ~ "+testCase.key, "matched", testCase.expected, matchGlob(testCase.pattern, testCase.key))
+	}
+}
+
+func TestGlobLiteralPrefix(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	assert.String("prefix", "user:", globLiteralPrefix("user:*"))
+	assert.String("prefix", "", globLiteralPrefix("*"))
+	assert.String("prefix", "a*b", globLiteralPrefix("a\\*b?"))
+	assert.String("prefix", "exact", globLiteralPrefix("exact"))
+}
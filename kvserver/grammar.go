@@ -11,10 +11,60 @@ func getStandardGrammar() map[string]parsing.ParserGrammar {
 		"put": {ExpectedArguments: 2},
 		"hed": {ExpectedArguments: 2, Arg2LengthIsValue: true},
 		"hst": {ExpectedArguments: 2},
-		"sdl": {ExpectedArguments: 1},
-		"spt": {ExpectedArguments: 2},
+		"sdl": {ExpectedArguments: 2},
+		"spt": {ExpectedArguments: 3},
 		"sgt": {ExpectedArguments: 1},
 		"chk": {ExpectedArguments: 1},
 		"nop": {ExpectedArguments: 0},
+		"snp": {ExpectedArguments: 0},
+		"exp": {ExpectedArguments: 2, Arg2LengthIsValue: true},
+		"ttl": {ExpectedArguments: 1},
+		"prs": {ExpectedArguments: 1},
+		"sxp": {ExpectedArguments: 2, Arg2LengthIsValue: true},
+		"sps": {ExpectedArguments: 1},
+		"cas": {ExpectedArguments: 3},
+		"pia": {ExpectedArguments: 2},
+		"deq": {ExpectedArguments: 2},
+		"gtv": {ExpectedArguments: 1},
+		"mlt": {ExpectedArguments: 0},
+		"exc": {ExpectedArguments: 0},
+		"dsc": {ExpectedArguments: 0},
+		"wch": {ExpectedArguments: 1},
+		"rng": {ExpectedArguments: 3},
+		"pfx": {ExpectedArguments: 3},
+		"scn": {ExpectedArguments: 3},
+		"mem": {ExpectedArguments: 0},
+		"lsn": {ExpectedArguments: 1},
+		"uln": {ExpectedArguments: 1},
+		"pub": {ExpectedArguments: 2},
+		"spb": {ExpectedArguments: 2},
+		"sub": {ExpectedArguments: 1},
+		"uns": {ExpectedArguments: 1},
+		"psb": {ExpectedArguments: 1},
+		"pun": {ExpectedArguments: 1},
+		"inc": {ExpectedArguments: 1},
+		"dec": {ExpectedArguments: 1},
+		"icb": {ExpectedArguments: 2},
+		"mgt": {Variadic: true},
+		"mpt": {Variadic: true},
+		"smp": {Variadic: true},
+		"apd": {ExpectedArguments: 2},
+		"grg": {ExpectedArguments: 3},
+		"srg": {ExpectedArguments: 3},
+		"sln": {ExpectedArguments: 1},
+		"hse": {ExpectedArguments: 3},
+		"hge": {ExpectedArguments: 2},
+		"hde": {ExpectedArguments: 2},
+		"hga": {ExpectedArguments: 1},
+		"lpu": {ExpectedArguments: 2},
+		"rpu": {ExpectedArguments: 2},
+		"lpo": {ExpectedArguments: 1},
+		"rpo": {ExpectedArguments: 1},
+		"lrg": {ExpectedArguments: 3},
+		"sad": {ExpectedArguments: 2},
+		"srm": {ExpectedArguments: 2},
+		"smb": {ExpectedArguments: 1},
+		"sim": {ExpectedArguments: 2},
+		"sty": {ExpectedArguments: 3},
 	}
 }
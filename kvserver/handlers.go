@@ -1,14 +1,20 @@
 package kvserver
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"kvsapp/kvstore"
 	"kvsapp/parsing"
 	"strconv"
+	"time"
 )
 
-func getHandlers() map[string]func(kvs *KvServer, key string, value string) string {
-	return map[string]func(kvs *KvServer, key string, value string) string{
+type commandHandler func(kvs *KvServer, message *commandMessage) string
+
+func getHandlers() map[string]commandHandler {
+	return map[string]commandHandler{
 		"nop": handleNop,
 		"chk": handleChk,
 		"put": handlePut,
@@ -18,6 +24,46 @@ func getHandlers() map[string]func(kvs *KvServer, key string, value string) stri
 		"spt": handleSpt,
 		"sdl": handleSdl,
 		"hst": handleHst,
+		"snp": handleSnp,
+		"exp": handleExp,
+		"ttl": handleTtl,
+		"prs": handlePrs,
+		"sxp": handleSxp,
+		"sps": handleSps,
+		"cas": handleCas,
+		"pia": handlePia,
+		"deq": handleDeq,
+		"gtv": handleGtv,
+		"rng": handleRng,
+		"pfx": handlePfx,
+		"scn": handleScn,
+		"mem": handleMem,
+		"pub": handlePub,
+		"spb": handleSpb,
+		"inc": handleInc,
+		"dec": handleDec,
+		"icb": handleIcb,
+		"mgt": handleMgt,
+		"mpt": handleMpt,
+		"smp": handleSmp,
+		"apd": handleApd,
+		"grg": handleGrg,
+		"srg": handleSrg,
+		"sln": handleSln,
+		"hse": handleHse,
+		"hge": handleHge,
+		"hde": handleHde,
+		"hga": handleHga,
+		"lpu": handleLpu,
+		"rpu": handleRpu,
+		"lpo": handleLpo,
+		"rpo": handleRpo,
+		"lrg": handleLrg,
+		"sad": handleSad,
+		"srm": handleSrm,
+		"smb": handleSmb,
+		"sim": handleSim,
+		"sty": handleSty,
 	}
 }
 
@@ -36,19 +82,19 @@ func (kvs *KvServer) handleMessage(connection io.Writer, message *commandMessage
 		return false
 
 	case "die":
-		_ = handleDie(kvs, message.Key, message.Value)
+		_ = handleDie(kvs, message)
 		return false
 
 	default:
 		if handler, exists := kvs.handlers[message.Command]; exists {
-			responseToWrite = handler(kvs, message.Key, message.Value)
+			responseToWrite = handler(kvs, message)
 		} else {
 			fmt.Println("server: unknown command")
 		}
 	}
 	if connection != nil && len(responseToWrite) > 0 {
 		if responseToWrite == "err" {
-			fmt.Printf("server: returning 'err' from: {%s}{%s}{%s}\n", message.Command, message.Key, message.Value)
+			fmt.Printf("server: returning 'err' from: {%s}{%s}{%s}{%s}\n", message.Command, message.Key, message.Value, message.Extra)
 		}
 		_, err := connection.Write([]byte(responseToWrite))
 		if err != nil {
@@ -58,79 +104,485 @@ func (kvs *KvServer) handleMessage(connection io.Writer, message *commandMessage
 	return true
 }
 
-func handleNop(kvs *KvServer, key string, value string) string {
+// runs a message through the handlers, as from another protocol, returning the kind of reply and any arguments...
+func (kvs *KvServer) handleForReply(message *commandMessage) (kind string, args []string) {
+	var reply bytes.Buffer
+	_ = kvs.handleMessage(&reply, message)
+	decoder, _ := parsing.NewDecoder(&reply, kvs.replies)
+	if kind, args, err := decoder.Decode(); err == nil {
+		return kind, args
+	}
+	return "err", nil
+}
+
+var errEmptyKey = errors.New("empty keys are not supported")
+var errEmptyValue = errors.New("empty values are not supported")
+
+// checks a write from another protocol before it is run as a 'put': neither it nor 'spt', which
+// copies it to the rest of the cluster, takes an empty argument...
+func checkPutArguments(key string, value string) error {
+	if key == "" {
+		return errEmptyKey
+	}
+	if value == "" {
+		return errEmptyValue
+	}
+	return nil
+}
+
+func handleNop(kvs *KvServer, message *commandMessage) string {
 	return "ack"
 }
 
-func handleDie(kvs *KvServer, key string, value string) string {
+func handleDie(kvs *KvServer, message *commandMessage) string {
 	kvs.Shutdown()
 	return "ack"
 }
 
-func handleChk(kvs *KvServer, key string, value string) string {
+func handleChk(kvs *KvServer, message *commandMessage) string {
 	kvs.sendToAllOthers("nop", "", "")
 	return "ack"
 }
 
-func handleHst(kvs *KvServer, key string, value string) string {
+func handleSnp(kvs *KvServer, message *commandMessage) string {
+	// admin command: snapshot the store and compact its log...
+	snapshotting, ok := kvs.store.(kvstore.SnapshottingStore)
+	if !ok {
+		return "err"
+	}
+	if err := snapshotting.Snapshot(); err != nil {
+		fmt.Printf("server: snapshot failed '%s'\n", err.Error())
+		return "err"
+	}
+	return "ack"
+}
+
+// admin command: the store's size and eviction counters as name/value pairs...
+func handleMem(kvs *KvServer, message *commandMessage) string {
+	limited, ok := kvs.store.(kvstore.MemoryLimitedStore)
+	if !ok {
+		return "err"
+	}
+	stats := limited.MemoryStats()
+	values := []string{
+		"keys", strconv.Itoa(stats.Keys),
+		"memory", strconv.FormatInt(stats.Memory, 10),
+		"evictions", strconv.FormatUint(stats.Evictions, 10),
+		"rejections", strconv.FormatUint(stats.Rejections, 10),
+	}
+	if bytesToWrite, err := parsing.CreateMultiData("mem", values); err == nil {
+		return string(bytesToWrite)
+	}
+	return "err"
+}
+
+// pub <channel> <message>: answers with the number of subscribers on this server it reached...
+func handlePub(kvs *KvServer, message *commandMessage) string {
+	delivered := kvs.broker.publish(message.Key, message.Value)
+	kvs.sendToAllOthers("spb", message.Key, message.Value)
+	if bytesToWrite, err := parsing.CreateData("val", strconv.Itoa(delivered)); err == nil {
+		return string(bytesToWrite)
+	}
+	return "err"
+}
+
+func handleSpb(kvs *KvServer, message *commandMessage) string {
+	kvs.broker.publish(message.Key, message.Value)
+	return "ack"
+}
+
+func handleHst(kvs *KvServer, message *commandMessage) string {
 	// udp broadcast message...
-	kvs.servers.Upsert(key, value)
+	kvs.servers.Upsert(message.Key, message.Value)
 	for _, serverKey := range kvs.servers.ListKeys() {
 		fmt.Printf("cluster: server '%s' is currently known\n", serverKey)
 	}
 	return ""
 }
 
-func handlePut(kvs *KvServer, key string, value string) string {
-	if _, err := kvs.store.Upsert(key, value); err == nil {
-		kvs.sendToAllOthers("spt", key, value)
-		return "ack"
+func handlePut(kvs *KvServer, message *commandMessage) string {
+	version, err := kvs.store.Upsert(message.Key, message.Value)
+	if err == nil {
+		kvs.replicatePut(message.Key, message.Value, version)
+		return versionResponse(version)
+	}
+	if errors.Is(err, kvstore.ErrOutOfMemory) {
+		return "oom"
 	}
 	return "err"
 }
 
-func handleSpt(kvs *KvServer, key string, value string) string {
-	kvs.store.Upsert(key, value)
+// sends a write to the rest of the cluster, along with the version it was given here...
+func (kvs *KvServer) replicatePut(key string, value string, version uint64) {
+	kvs.sendToAllOthers("spt", key, value, strconv.FormatUint(version, 10))
+}
+
+// applies a write replicated from another server, answering 'stl' if the key already holds a newer one...
+func handleSpt(kvs *KvServer, message *commandMessage) string {
+	versioned, ok := kvs.store.(kvstore.VersionedStore)
+	version, err := strconv.ParseUint(message.Extra, 10, 64)
+	if err != nil || !ok {
+		_, err = kvs.store.Upsert(message.Key, message.Value)
+	} else {
+		_, err = versioned.UpsertVersion(message.Key, message.Value, version)
+	}
+	if errors.Is(err, kvstore.ErrStaleVersion) {
+		fmt.Printf("server: ignoring stale write of key '%s'\n", message.Key)
+		return "stl"
+	}
+	if err != nil {
+		return "err"
+	}
 	return "ack"
 }
 
-func handleSdl(kvs *KvServer, key string, value string) string {
-	kvs.store.Delete(key)
+// sends a delete to the rest of the cluster, along with the version it was given here...
+func (kvs *KvServer) replicateDelete(key string, version uint64) {
+	kvs.sendToAllOthers("sdl", key, strconv.FormatUint(version, 10))
+}
+
+// applies a delete replicated from another server, answering 'stl' if the key already holds a
+// newer write; a delete without a version, of a key that didn't exist there, is applied as it is...
+func handleSdl(kvs *KvServer, message *commandMessage) string {
+	versioned, ok := kvs.store.(kvstore.VersionedStore)
+	version, err := strconv.ParseUint(message.Value, 10, 64)
+	if err != nil || version == 0 || !ok {
+		_, err = kvs.store.Delete(message.Key)
+	} else {
+		_, err = versioned.DeleteVersion(message.Key, version)
+	}
+	if errors.Is(err, kvstore.ErrStaleVersion) {
+		fmt.Printf("server: ignoring stale delete of key '%s'\n", message.Key)
+		return "stl"
+	}
+	if err != nil {
+		return "err"
+	}
 	return "ack"
 }
 
-func handleDel(kvs *KvServer, key string, value string) string {
-	if _, err := kvs.store.Delete(key); err == nil {
-		kvs.sendToAllOthers("sdl", key, "")
-		return "ack"
+func handleDel(kvs *KvServer, message *commandMessage) string {
+	if version, err := kvs.store.Delete(message.Key); err == nil {
+		kvs.replicateDelete(message.Key, version)
+		return versionResponse(version)
+	}
+	return "err"
+}
+
+// answers a write with the version it was given, or zero for a delete of a key that didn't exist...
+func versionResponse(version uint64) string {
+	if bytesToWrite, err := parsing.CreateData("vsn", strconv.FormatUint(version, 10)); err == nil {
+		return string(bytesToWrite)
 	}
 	return "err"
 }
 
-func handleGet(kvs *KvServer, key string, value string) string {
-	if result, err := kvs.store.Get(key); err != nil {
+func handleGet(kvs *KvServer, message *commandMessage) string {
+	if result, err := kvs.store.Get(message.Key); errors.Is(err, kvstore.ErrWrongType) {
+		return "wty"
+	} else if err != nil {
 		return "nil"
 	} else {
-		if bytesToWrite, err := parsing.CreateData("val", result, ""); err == nil {
+		return valueResponse(result)
+	}
+}
+
+func handleGtv(kvs *KvServer, message *commandMessage) string {
+	versioned, ok := kvs.store.(kvstore.VersionedStore)
+	if !ok {
+		return "err"
+	}
+	if result, version, err := versioned.GetWithVersion(message.Key); errors.Is(err, kvstore.ErrWrongType) {
+		return "wty"
+	} else if err != nil {
+		return "nil"
+	} else {
+		if bytesToWrite, err := parsing.CreateData("ver", result, strconv.FormatUint(version, 10)); err == nil {
 			return string(bytesToWrite)
 		}
 	}
 	return "err"
 }
 
-func handleHed(kvs *KvServer, key string, value string) string {
-	if result, err := kvs.store.Get(key); err != nil {
+// hed <key> <length>: the first length bytes of the value, or all of it if the value is shorter
+// or length is zero...
+func handleHed(kvs *KvServer, message *commandMessage) string {
+	if result, err := kvs.store.Get(message.Key); errors.Is(err, kvstore.ErrWrongType) {
+		return "wty"
+	} else if err != nil {
 		return "nil"
 	} else {
-		desiredLength, err := strconv.Atoi(value)
+		desiredLength, err := strconv.Atoi(message.Value)
 		if err == nil && desiredLength >= 0 {
-			if desiredLength > 0 {
+			if desiredLength > 0 && desiredLength < len(result) {
 				result = result[0:desiredLength]
 			}
-			if bytesToWrite, err := parsing.CreateData("val", result, ""); err == nil {
-				return string(bytesToWrite)
-			}
+			return valueResponse(result)
+		}
+	}
+	return "err"
+}
+
+// apd <key> <value>: appends value, answering with the new length...
+func handleApd(kvs *KvServer, message *commandMessage) string {
+	ranged, ok := kvs.store.(kvstore.RangedStore)
+	if !ok {
+		return "err"
+	}
+	length, version, err := ranged.Append(message.Key, message.Value)
+	return rangedWriteResponse(kvs, message.Key, length, version, err)
+}
+
+// srg <key> <offset> <value>: overwrites from offset, padding with zero bytes, answering with the new length...
+func handleSrg(kvs *KvServer, message *commandMessage) string {
+	ranged, ok := kvs.store.(kvstore.RangedStore)
+	if !ok {
+		return "err"
+	}
+	offset, err := strconv.Atoi(message.Value)
+	if err != nil {
+		return "err"
+	}
+	length, version, err := ranged.SetRange(message.Key, offset, message.Extra)
+	return rangedWriteResponse(kvs, message.Key, length, version, err)
+}
+
+// peers are sent the whole new value, as for a counter. it is read back with its version, which
+// is newer than the write's own if another write has landed since, so peers get a matching pair...
+func rangedWriteResponse(kvs *KvServer, key string, length int, version uint64, err error) string {
+	switch {
+	case errors.Is(err, kvstore.ErrWrongType):
+		return "wty"
+	case errors.Is(err, kvstore.ErrOutOfMemory):
+		return "oom"
+	case err != nil:
+		return "err"
+	}
+	if versioned, ok := kvs.store.(kvstore.VersionedStore); ok && version > 0 {
+		if value, latest, err := versioned.GetWithVersion(key); err == nil {
+			kvs.replicatePut(key, value, latest)
+		}
+	}
+	if bytesToWrite, err := parsing.CreateData("val", strconv.Itoa(length)); err == nil {
+		return string(bytesToWrite)
+	}
+	return "err"
+}
+
+// grg <key> <offset> <length>: up to length bytes from offset, clamped to the value, where a
+// negative offset counts back from the end...
+func handleGrg(kvs *KvServer, message *commandMessage) string {
+	ranged, ok := kvs.store.(kvstore.RangedStore)
+	if !ok {
+		return "err"
+	}
+	offset, err := strconv.Atoi(message.Value)
+	if err != nil {
+		return "err"
+	}
+	length, err := strconv.Atoi(message.Extra)
+	if err != nil {
+		return "err"
+	}
+	result, err := ranged.GetRange(message.Key, offset, length)
+	switch {
+	case errors.Is(err, kvstore.ErrWrongType):
+		return "wty"
+	case errors.Is(err, kvstore.ErrKeyNotFound):
+		return "nil"
+	case err != nil:
+		return "err"
+	}
+	return valueResponse(result)
+}
+
+// sln <key>: the length of the value, zero if it is missing...
+func handleSln(kvs *KvServer, message *commandMessage) string {
+	ranged, ok := kvs.store.(kvstore.RangedStore)
+	if !ok {
+		return "err"
+	}
+	length, err := ranged.Strlen(message.Key)
+	if errors.Is(err, kvstore.ErrWrongType) {
+		return "wty"
+	} else if err != nil {
+		return "err"
+	}
+	if bytesToWrite, err := parsing.CreateData("val", strconv.Itoa(length)); err == nil {
+		return string(bytesToWrite)
+	}
+	return "err"
+}
+
+func handleInc(kvs *KvServer, message *commandMessage) string {
+	return incrementBy(kvs, message.Key, 1)
+}
+
+func handleDec(kvs *KvServer, message *commandMessage) string {
+	return incrementBy(kvs, message.Key, -1)
+}
+
+// icb <key> <delta>: adds a signed delta...
+func handleIcb(kvs *KvServer, message *commandMessage) string {
+	delta, err := strconv.ParseInt(message.Value, 10, 64)
+	if err != nil {
+		return "err"
+	}
+	return incrementBy(kvs, message.Key, delta)
+}
+
+// answers with the new value, or 'nan' if the key holds something other than an integer. peers
+// are sent the new value rather than the delta, so that they end up with the same one...
+func incrementBy(kvs *KvServer, key string, delta int64) string {
+	counting, ok := kvs.store.(kvstore.CountingStore)
+	if !ok {
+		return "err"
+	}
+	value, version, err := counting.IncrementBy(key, delta)
+	switch {
+	case errors.Is(err, kvstore.ErrNotInteger):
+		return "nan"
+	case errors.Is(err, kvstore.ErrWrongType):
+		return "wty"
+	case errors.Is(err, kvstore.ErrOutOfMemory):
+		return "oom"
+	case err != nil:
+		return "err"
+	}
+	result := strconv.FormatInt(value, 10)
+	kvs.replicatePut(key, result, version)
+	if bytesToWrite, err := parsing.CreateData("val", result); err == nil {
+		return string(bytesToWrite)
+	}
+	return "err"
+}
+
+func parseTtl(value string) (time.Duration, bool) {
+	milliseconds, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(milliseconds) * time.Millisecond, true
+}
+
+func handleExp(kvs *KvServer, message *commandMessage) string {
+	expiring, ok := kvs.store.(kvstore.ExpiringStore)
+	if !ok {
+		return "err"
+	}
+	ttl, ok := parseTtl(message.Value)
+	if !ok {
+		return "err"
+	}
+	if err := expiring.Expire(message.Key, ttl); err != nil {
+		if errors.Is(err, kvstore.ErrKeyNotFound) {
+			return "nil"
+		}
+		return "err"
+	}
+	kvs.sendToAllOthers("sxp", message.Key, message.Value)
+	return "ack"
+}
+
+func handleSxp(kvs *KvServer, message *commandMessage) string {
+	if expiring, ok := kvs.store.(kvstore.ExpiringStore); ok {
+		if ttl, ok := parseTtl(message.Value); ok {
+			expiring.Expire(message.Key, ttl)
 		}
 	}
+	return "ack"
+}
+
+func handleTtl(kvs *KvServer, message *commandMessage) string {
+	expiring, ok := kvs.store.(kvstore.ExpiringStore)
+	if !ok {
+		return "err"
+	}
+	remaining, err := expiring.TTL(message.Key)
+	milliseconds := "-1"
+	if err == nil {
+		milliseconds = strconv.FormatInt(remaining.Milliseconds(), 10)
+	} else if !errors.Is(err, kvstore.ErrKeyHasNoExpiry) {
+		return "nil"
+	}
+	if bytesToWrite, err := parsing.CreateData("val", milliseconds, ""); err == nil {
+		return string(bytesToWrite)
+	}
 	return "err"
 }
+
+func handlePrs(kvs *KvServer, message *commandMessage) string {
+	expiring, ok := kvs.store.(kvstore.ExpiringStore)
+	if !ok {
+		return "err"
+	}
+	if err := expiring.Persist(message.Key); err != nil {
+		if errors.Is(err, kvstore.ErrKeyNotFound) {
+			return "nil"
+		}
+		return "err"
+	}
+	kvs.sendToAllOthers("sps", message.Key, "")
+	return "ack"
+}
+
+func handleSps(kvs *KvServer, message *commandMessage) string {
+	if expiring, ok := kvs.store.(kvstore.ExpiringStore); ok {
+		expiring.Persist(message.Key)
+	}
+	return "ack"
+}
+
+// maps the outcome of a conditional write onto 'vsn' with the new version (applied), 'mis' (condition
+// not met), 'nil' (missing), 'oom' (no room for the value) or 'wty' (not a string)...
+func conditionalResponse(version uint64, err error) string {
+	switch {
+	case err == nil:
+		return versionResponse(version)
+	case errors.Is(err, kvstore.ErrKeyNotFound):
+		return "nil"
+	case errors.Is(err, kvstore.ErrValueMismatch), errors.Is(err, kvstore.ErrKeyExists):
+		return "mis"
+	case errors.Is(err, kvstore.ErrOutOfMemory):
+		return "oom"
+	case errors.Is(err, kvstore.ErrWrongType):
+		return "wty"
+	}
+	return "err"
+}
+
+func handleCas(kvs *KvServer, message *commandMessage) string {
+	conditional, ok := kvs.store.(kvstore.ConditionalStore)
+	if !ok {
+		return "err"
+	}
+	version, err := conditional.CompareAndSwap(message.Key, message.Value, message.Extra)
+	if err == nil {
+		kvs.replicatePut(message.Key, message.Extra, version)
+	}
+	return conditionalResponse(version, err)
+}
+
+func handlePia(kvs *KvServer, message *commandMessage) string {
+	conditional, ok := kvs.store.(kvstore.ConditionalStore)
+	if !ok {
+		return "err"
+	}
+	version, err := conditional.PutIfAbsent(message.Key, message.Value)
+	if err == nil {
+		kvs.replicatePut(message.Key, message.Value, version)
+	}
+	return conditionalResponse(version, err)
+}
+
+func handleDeq(kvs *KvServer, message *commandMessage) string {
+	conditional, ok := kvs.store.(kvstore.ConditionalStore)
+	if !ok {
+		return "err"
+	}
+	version, err := conditional.DeleteIfEquals(message.Key, message.Value)
+	if err == nil {
+		kvs.replicateDelete(message.Key, version)
+	}
+	return conditionalResponse(version, err)
+}
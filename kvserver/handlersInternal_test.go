@@ -0,0 +1,277 @@
+package kvserver
+
+import (
+	"bytes"
+	"fmt"
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"kvsapp/parsing"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type handleMessageTestStep struct {
+	command       string
+	key           string
+	value         string
+	extra         string
+	args          []string // for variadic commands, in place of key, value and extra
+	expectedWrite string
+}
+
+func runHandleMessageSteps(t *testing.T, testObject *KvServer, steps []handleMessageTestStep) {
+	assert := assertions.NewAssert(t)
+	for i, step := range steps {
+		testName := fmt.Sprintf("step %d (%s)", i, step.command)
+		buffer := &bytes.Buffer{}
+		message := &commandMessage{Command: step.command, Key: step.key, Value: step.value, Extra: step.extra}
+		if step.args != nil {
+			message = newCommandMessage(step.command, step.args)
+		}
+		carryOn := testObject.handleMessage(buffer, message)
+		assert.TestBoolean(testName, "carryOn", true, carryOn)
+		// a trailing '*' matches whatever follows, such as a version...
+		if expectedPrefix := strings.TrimSuffix(step.expectedWrite, "*"); expectedPrefix != step.expectedWrite {
+			assert.TestBoolean(testName, "written "+buffer.String(), true, strings.HasPrefix(buffer.String(), expectedPrefix))
+		} else {
+			assert.TestString(testName, "written", step.expectedWrite, buffer.String())
+		}
+	}
+}
+
+func TestHandleExpiryCommands(t *testing.T) {
+	t.Parallel()
+	runHandleMessageSteps(t, createTestObject(), []handleMessageTestStep{
+		{command: "exp", key: "key", value: "1000", expectedWrite: "nil"},
+		{command: "ttl", key: "key", expectedWrite: "nil"},
+		{command: "put", key: "key", value: "value", expectedWrite: "vsn*"},
+		{command: "ttl", key: "key", expectedWrite: "val12-1"},
+		{command: "exp", key: "key", value: "x", expectedWrite: "err"},
+		{command: "exp", key: "key", value: "60000", expectedWrite: "ack"},
+		{command: "ttl", key: "key", expectedWrite: "*"},
+		{command: "prs", key: "key", expectedWrite: "ack"},
+		{command: "ttl", key: "key", expectedWrite: "val12-1"},
+		{command: "exp", key: "key", value: "0", expectedWrite: "ack"},
+		{command: "get", key: "key", expectedWrite: "nil"},
+		{command: "prs", key: "key", expectedWrite: "nil"},
+	})
+}
+
+func TestHandleConditionalCommands(t *testing.T) {
+	t.Parallel()
+	runHandleMessageSteps(t, createTestObject(), []handleMessageTestStep{
+		{command: "cas", key: "key", value: "old", extra: "new", expectedWrite: "nil"},
+		{command: "deq", key: "key", value: "old", expectedWrite: "nil"},
+		{command: "pia", key: "key", value: "old", expectedWrite: "vsn*"},
+		{command: "pia", key: "key", value: "other", expectedWrite: "mis"},
+		{command: "cas", key: "key", value: "other", extra: "new", expectedWrite: "mis"},
+		{command: "cas", key: "key", value: "old", extra: "new", expectedWrite: "vsn*"},
+		{command: "get", key: "key", expectedWrite: "val13new"},
+		{command: "deq", key: "key", value: "old", expectedWrite: "mis"},
+		{command: "deq", key: "key", value: "new", expectedWrite: "vsn*"},
+		{command: "get", key: "key", expectedWrite: "nil"},
+	})
+}
+
+func TestHandleVersionCommands(t *testing.T) {
+	t.Parallel()
+	runHandleMessageSteps(t, createTestObject(), []handleMessageTestStep{
+		{command: "gtv", key: "key", expectedWrite: "nil"},
+		{command: "put", key: "key", value: "value", expectedWrite: "vsn*"},
+		{command: "gtv", key: "key", expectedWrite: "*"},
+		{command: "spt", key: "key", value: "remote", extra: "4611686018427387904", expectedWrite: "ack"},
+		{command: "gtv", key: "key", expectedWrite: "ver16remote2194611686018427387904"},
+		{command: "spt", key: "key", value: "stale", extra: "4611686018427387903", expectedWrite: "stl"},
+		{command: "spt", key: "key", value: "other", extra: "4611686018427387904", expectedWrite: "stl"},
+		{command: "gtv", key: "key", expectedWrite: "ver16remote2194611686018427387904"},
+		{command: "spt", key: "key", value: "tied", extra: "4611686018427387904", expectedWrite: "ack"},
+		{command: "gtv", key: "key", expectedWrite: "ver14tied2194611686018427387904"},
+		{command: "put", key: "key", value: "local", expectedWrite: "vsn2194611686018427387905"},
+		{command: "gtv", key: "key", expectedWrite: "ver15local2194611686018427387905"},
+		{command: "del", key: "key", expectedWrite: "vsn2194611686018427387906"},
+		{command: "del", key: "key", expectedWrite: "vsn110"},
+	})
+}
+
+func TestHandleReplicatedDeletes(t *testing.T) {
+	t.Parallel()
+	runHandleMessageSteps(t, createTestObject(), []handleMessageTestStep{
+		{command: "spt", key: "key", value: "remote", extra: "4611686018427387904", expectedWrite: "ack"},
+		{command: "sdl", key: "key", value: "4611686018427387903", expectedWrite: "stl"},
+		{command: "sdl", key: "key", value: "4611686018427387904", expectedWrite: "stl"},
+		{command: "get", key: "key", expectedWrite: "val16remote"},
+		{command: "sdl", key: "key", value: "4611686018427387906", expectedWrite: "ack"},
+		{command: "get", key: "key", expectedWrite: "nil"},
+		// a write from before the delete, that arrives after it, doesn't bring the key back...
+		{command: "spt", key: "key", value: "late", extra: "4611686018427387905", expectedWrite: "stl"},
+		{command: "get", key: "key", expectedWrite: "nil"},
+		{command: "spt", key: "key", value: "later", extra: "4611686018427387907", expectedWrite: "ack"},
+		// a delete of a key the sender didn't have comes without a version, and is applied as it is...
+		{command: "sdl", key: "key", value: "0", expectedWrite: "ack"},
+		{command: "get", key: "key", expectedWrite: "nil"},
+	})
+}
+
+func TestHandleCounterCommands(t *testing.T) {
+	t.Parallel()
+	runHandleMessageSteps(t, createTestObject(), []handleMessageTestStep{
+		{command: "inc", key: "counter", expectedWrite: "val111"},
+		{command: "icb", key: "counter", value: "41", expectedWrite: "val1242"},
+		{command: "dec", key: "counter", expectedWrite: "val1241"},
+		{command: "icb", key: "counter", value: "-50", expectedWrite: "val12-9"},
+		{command: "icb", key: "counter", value: "x", expectedWrite: "err"},
+		{command: "icb", key: "counter", value: "-9223372036854775807", expectedWrite: "err"},
+		{command: "get", key: "counter", expectedWrite: "val12-9"},
+		{command: "put", key: "text", value: "abc", expectedWrite: "vsn*"},
+		{command: "inc", key: "text", expectedWrite: "nan"},
+		{command: "dec", key: "text", expectedWrite: "nan"},
+	})
+}
+
+func TestHandleScanCommands(t *testing.T) {
+	t.Parallel()
+	runHandleMessageSteps(t, createTestObject(), []handleMessageTestStep{
+		{command: "put", key: "a:1", value: "x", expectedWrite: "vsn*"},
+		{command: "put", key: "a:2", value: "y", expectedWrite: "vsn*"},
+		{command: "put", key: "a:3", value: "z", expectedWrite: "vsn*"},
+		{command: "put", key: "b:1", value: "w", expectedWrite: "vsn*"},
+		{command: "rng", key: "a:1", value: "b", extra: "2", expectedWrite: "kvs1513a:313a:111x13a:211y"},
+		{command: "rng", key: "a:3", value: "b", extra: "2", expectedWrite: "kvs131013a:311z"},
+		{command: "pfx", key: "a:", value: "a:", extra: "1", expectedWrite: "kvs1313a:213a:111x"},
+		{command: "pfx", key: "b:", value: "a", extra: "5", expectedWrite: "kvs131013b:111w"},
+		{command: "pfx", key: "c:", value: "c:", extra: "5", expectedWrite: "kvs1110"},
+		{command: "rng", key: "a", value: "b", extra: "0", expectedWrite: "err"},
+	})
+}
+
+func TestHandleScanCommandsWithOpenBounds(t *testing.T) {
+	t.Parallel()
+	runHandleMessageSteps(t, createTestObject(), []handleMessageTestStep{
+		{command: "put", key: "*", value: "s", expectedWrite: "vsn*"},
+		{command: "put", key: "\\x", value: "t", expectedWrite: "vsn*"},
+		{command: "put", key: "a", value: "u", expectedWrite: "vsn*"},
+		{command: "rng", key: "*", value: "*", extra: "1", expectedWrite: "kvs1313\\\\x11*11s"},
+		{command: "rng", key: "\\\\x", value: "*", extra: "5", expectedWrite: "kvs151012\\x11t11a11u"},
+		{command: "rng", key: "\\*", value: "a", extra: "5", expectedWrite: "kvs151011*11s12\\x11t"},
+		{command: "rng", key: "a", value: "*", extra: "5", expectedWrite: "kvs131011a11u"},
+		{command: "pfx", key: "a", value: "*", extra: "5", expectedWrite: "kvs131011a11u"},
+	})
+}
+
+func TestHandleKeyScanCommand(t *testing.T) {
+	t.Parallel()
+	runHandleMessageSteps(t, createTestObject(), []handleMessageTestStep{
+		{command: "scn", key: "0", value: "*", extra: "5", expectedWrite: "kys11110"},
+		{command: "put", key: "a:1", value: "v", expectedWrite: "vsn*"},
+		{command: "put", key: "a:2", value: "v", expectedWrite: "vsn*"},
+		{command: "put", key: "a:3", value: "v", expectedWrite: "vsn*"},
+		{command: "put", key: "b:1", value: "v", expectedWrite: "vsn*"},
+		{command: "put", key: "b:2", value: "v", expectedWrite: "vsn*"},
+		{command: "scn", key: "0", value: "*", extra: "2", expectedWrite: "kys1314YToz13a:113a:2"},
+		{command: "scn", key: "YToz", value: "*", extra: "2", expectedWrite: "kys1314Yjoy13a:313b:1"},
+		{command: "scn", key: "Yjoy", value: "*", extra: "2", expectedWrite: "kys1211013b:2"},
+		{command: "scn", key: "0", value: "b*", extra: "0", expectedWrite: "kys1311013b:113b:2"},
+		{command: "scn", key: "0", value: "*:2", extra: "3", expectedWrite: "kys1214Yjox13a:2"},
+		{command: "scn", key: "!!", value: "*", extra: "1", expectedWrite: "err"},
+		{command: "scn", key: "0", value: "*", extra: "x", expectedWrite: "err"},
+	})
+}
+
+func TestHandleCommandsWithDiskStore(t *testing.T) {
+	t.Parallel()
+	store := kvstore.NewDiskStore(kvstore.DiskStoreOptions{Path: filepath.Join(t.TempDir(), "store.dat")})
+	store.Open()
+	defer store.Close()
+	kvs, _ := NewKvServer(0, 0, store)
+	kvs.servers.Open()
+	runHandleMessageSteps(t, kvs, []handleMessageTestStep{
+		{command: "put", key: "key", value: "value", expectedWrite: "vsn*"},
+		{command: "get", key: "key", expectedWrite: "val15value"},
+		{command: "spt", key: "key", value: "value", extra: "4611686018427387904", expectedWrite: "ack"},
+		{command: "gtv", key: "key", expectedWrite: "ver15value2194611686018427387904"},
+		{command: "rng", key: "a", value: "z", extra: "5", expectedWrite: "kvs131013key15value"},
+		{command: "snp", expectedWrite: "ack"},
+		{command: "get", key: "key", expectedWrite: "val15value"},
+		{command: "exp", key: "key", value: "1000", expectedWrite: "err"},
+		{command: "cas", key: "key", value: "value", extra: "new", expectedWrite: "err"},
+		{command: "del", key: "key", expectedWrite: "vsn*"},
+		{command: "get", key: "key", expectedWrite: "nil"},
+	})
+}
+
+func TestHandleMemoryLimitCommands(t *testing.T) {
+	t.Parallel()
+	store := kvstore.NewKvStoreWithOptions(kvstore.KvStoreOptions{MaxKeys: 1})
+	store.Open()
+	defer store.Close()
+	kvs, _ := NewKvServer(0, 0, store)
+	kvs.servers.Open()
+	runHandleMessageSteps(t, kvs, []handleMessageTestStep{
+		{command: "put", key: "a", value: "value", expectedWrite: "vsn*"},
+		{command: "put", key: "b", value: "value", expectedWrite: "oom"},
+		{command: "pia", key: "b", value: "value", expectedWrite: "oom"},
+		{command: "mem", expectedWrite: "mem1814keys11116memory11619evictions110210rejections112"},
+	})
+}
+
+func TestHandleRangedStringCommands(t *testing.T) {
+	t.Parallel()
+	runHandleMessageSteps(t, createTestObject(), []handleMessageTestStep{
+		{command: "hed", key: "key", value: "3", expectedWrite: "nil"},
+		{command: "apd", key: "key", value: "hello", expectedWrite: "val115"},
+		{command: "apd", key: "key", value: " world", expectedWrite: "val1211"},
+		{command: "hed", key: "key", value: "5", expectedWrite: "val15hello"},
+		{command: "hed", key: "key", value: "100", expectedWrite: "val211hello world"},
+		{command: "hed", key: "key", value: "0", expectedWrite: "val211hello world"},
+		{command: "grg", key: "key", value: "6", extra: "100", expectedWrite: "val15world"},
+		{command: "grg", key: "key", value: "-5", extra: "2", expectedWrite: "val12wo"},
+		{command: "grg", key: "key", value: "50", extra: "2", expectedWrite: "val10"},
+		{command: "grg", key: "key", value: "0", extra: "-1", expectedWrite: "err"},
+		{command: "grg", key: "missing", value: "0", extra: "1", expectedWrite: "nil"},
+		{command: "srg", key: "key", value: "6", extra: "there", expectedWrite: "val1211"},
+		{command: "get", key: "key", expectedWrite: "val211hello there"},
+		{command: "srg", key: "key", value: "x", extra: "there", expectedWrite: "err"},
+		{command: "sln", key: "key", expectedWrite: "val1211"},
+		{command: "sln", key: "missing", expectedWrite: "val110"},
+		{command: "sad", key: "tags", value: "red", expectedWrite: "val111"},
+		{command: "apd", key: "tags", value: "x", expectedWrite: "wty"},
+		{command: "hed", key: "tags", value: "1", expectedWrite: "wty"},
+		{command: "sln", key: "tags", expectedWrite: "wty"},
+	})
+}
+
+func FuzzPutGetAndReplicateBinaryValues(f *testing.F) {
+	f.Add([]byte("key"), []byte("value"))
+	f.Add([]byte{0x00}, []byte{0x00, 0x7f, 0x80, 0xff})
+	f.Add([]byte("\xc3\x28"), []byte("\xe2\x82\x28\n\r"))
+	testObject, replica := createTestObject(), createTestObject()
+
+	f.Fuzz(func(t *testing.T, key []byte, value []byte) {
+		if len(key) == 0 || len(value) == 0 {
+			t.Skip("the protocol has no way to send an empty argument")
+		}
+		session, buffer := createTestSession()
+		put, _ := parsing.CreateData("put", string(key), string(value))
+		if response := sendToSession(t, testObject, session, buffer, string(put)); !strings.HasPrefix(response, "vsn") {
+			t.Fatalf("param: put, expected: vsn*, actual: %q", response)
+		}
+		get, _ := parsing.CreateData("get", string(key))
+		expected, _ := parsing.CreateData("val", string(value))
+		if response := sendToSession(t, testObject, session, buffer, string(get)); response != string(expected) {
+			t.Fatalf("param: get, expected: %q, actual: %q", expected, response)
+		}
+
+		// the write as replicatePut sends it to the other servers...
+		_, version, _ := testObject.store.(kvstore.VersionedStore).GetWithVersion(string(key))
+		spt, _ := parsing.CreateData("spt", string(key), string(value), strconv.FormatUint(version, 10))
+		replicaSession, replicaBuffer := createTestSession()
+		if response := sendToSession(t, replica, replicaSession, replicaBuffer, string(spt)); response != "ack" && response != "stl" {
+			t.Fatalf("param: spt, expected: ack, actual: %q", response)
+		}
+		if response := sendToSession(t, replica, replicaSession, replicaBuffer, string(get)); response != string(expected) {
+			t.Fatalf("param: replicated get, expected: %q, actual: %q", expected, response)
+		}
+	})
+}
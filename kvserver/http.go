@@ -0,0 +1,228 @@
+package kvserver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// an http listener serves the store as json, for services that can't speak anything else:
+//
+//	GET    /keys/{key}             the value of a key
+//	PUT    /keys/{key}             sets a key to the request body
+//	DELETE /keys/{key}             removes a key
+//	GET    /keys?prefix=&cursor=   a page of keys and values, in order, with the prefix
+//	GET    /cluster                this server, and the others it knows of
+//
+// each request is run through the same handlers as the binary protocol, so writes are replicated
+// to the rest of the cluster just as they are from our own clients.
+
+// the longest an http client may take to send the headers of a request...
+const KvServerHttpHeaderTimeout time.Duration = 10 * time.Second
+
+type httpEntry struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Encoding string `json:"encoding,omitempty"` // "base64" for a value that isn't valid utf-8
+}
+
+type httpWrite struct {
+	Key     string `json:"key"`
+	Version uint64 `json:"version,string"` // a string, as versions overflow the integers json parsers keep exactly
+}
+
+type httpPage struct {
+	Entries []httpEntry `json:"entries"`
+	Cursor  string      `json:"cursor,omitempty"` // where the next page starts, if there is one
+}
+
+type httpPeer struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+type httpCluster struct {
+	Server string            `json:"server"`
+	Peers  []httpPeer        `json:"peers"`
+	Memory map[string]string `json:"memory,omitempty"`
+}
+
+type httpError struct {
+	Error string `json:"error"`
+}
+
+func (kvs *KvServer) handleHttpAcceptance(listener net.Listener) {
+	server := &http.Server{Handler: kvs.newHttpHandler(), ReadHeaderTimeout: KvServerHttpHeaderTimeout}
+	if err := server.Serve(listener); err != nil {
+		fmt.Printf("server-http: stopped serving: %s\n", err.Error())
+	}
+}
+
+func (kvs *KvServer) newHttpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/keys/", kvs.handleHttpKey)
+	mux.HandleFunc("/keys", kvs.handleHttpKeys)
+	mux.HandleFunc("/cluster", kvs.handleHttpCluster)
+	return mux
+}
+
+func (kvs *KvServer) handleHttpKey(response http.ResponseWriter, request *http.Request) {
+	key := strings.TrimPrefix(request.URL.Path, "/keys/")
+	switch {
+	case key == "":
+		writeHttpError(response, http.StatusBadRequest, errEmptyKey.Error())
+	case len(key) > kvs.limits.MaxKeyLength:
+		writeHttpError(response, http.StatusRequestURITooLong, "key too large")
+	case request.Method == http.MethodGet || request.Method == http.MethodHead:
+		kvs.handleHttpGet(response, key)
+	case request.Method == http.MethodPut:
+		kvs.handleHttpPut(response, request, key)
+	case request.Method == http.MethodDelete:
+		kvs.handleHttpDelete(response, key)
+	default:
+		response.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		writeHttpError(response, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (kvs *KvServer) handleHttpGet(response http.ResponseWriter, key string) {
+	kind, args := kvs.handleForReply(&commandMessage{Command: "get", Key: key})
+	switch kind {
+	case "val":
+		writeHttpJson(response, http.StatusOK, newHttpEntry(key, args[0]))
+	case "nil":
+		writeHttpError(response, http.StatusNotFound, "not found")
+	case "wty":
+		writeHttpError(response, http.StatusConflict, "key holds the wrong kind of value")
+	default:
+		writeHttpError(response, http.StatusInternalServerError, "get failed")
+	}
+}
+
+func (kvs *KvServer) handleHttpPut(response http.ResponseWriter, request *http.Request, key string) {
+	// read one byte more than a value may hold, to tell one that is too large...
+	value, err := io.ReadAll(io.LimitReader(request.Body, int64(kvs.limits.MaxValueLength)+1))
+	if err != nil {
+		writeHttpError(response, http.StatusBadRequest, "couldn't read the value")
+		return
+	}
+	if len(value) > kvs.limits.MaxValueLength {
+		writeHttpError(response, http.StatusRequestEntityTooLarge, "value too large")
+		return
+	}
+	if err := checkPutArguments(key, string(value)); err != nil {
+		writeHttpError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	kind, args := kvs.handleForReply(&commandMessage{Command: "put", Key: key, Value: string(value)})
+	switch kind {
+	case "vsn":
+		version, _ := strconv.ParseUint(args[0], 10, 64)
+		writeHttpJson(response, http.StatusOK, httpWrite{Key: key, Version: version})
+	case "oom":
+		writeHttpError(response, http.StatusInsufficientStorage, "out of memory")
+	default:
+		writeHttpError(response, http.StatusInternalServerError, "put failed")
+	}
+}
+
+func (kvs *KvServer) handleHttpDelete(response http.ResponseWriter, key string) {
+	kind, args := kvs.handleForReply(&commandMessage{Command: "del", Key: key})
+	switch {
+	case kind != "vsn":
+		writeHttpError(response, http.StatusInternalServerError, "delete failed")
+	case args[0] == "0":
+		// a delete of a key that didn't exist is given version zero...
+		writeHttpError(response, http.StatusNotFound, "not found")
+	default:
+		version, _ := strconv.ParseUint(args[0], 10, 64)
+		writeHttpJson(response, http.StatusOK, httpWrite{Key: key, Version: version})
+	}
+}
+
+// a page of up to KvServerMaxScanPage entries, or 'limit' if it is given; 'cursor' is the one a
+// previous page returned...
+func (kvs *KvServer) handleHttpKeys(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		response.Header().Set("Allow", "GET, HEAD")
+		writeHttpError(response, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	query := request.URL.Query()
+	prefix, cursor, limit := query.Get("prefix"), query.Get("cursor"), query.Get("limit")
+	if cursor == "" {
+		cursor = scanOpenBound
+	}
+	if limit == "" {
+		limit = strconv.Itoa(KvServerMaxScanPage)
+	}
+
+	// a prefix can't be sent empty, so every key is a range with neither end bounded...
+	message := &commandMessage{Command: "pfx", Key: prefix, Value: cursor, Extra: limit}
+	if prefix == "" {
+		message = &commandMessage{Command: "rng", Key: cursor, Value: scanOpenBound, Extra: limit}
+	}
+	kind, args := kvs.handleForReply(message)
+	if kind != "kvs" || len(args)%2 != 1 {
+		writeHttpError(response, http.StatusBadRequest, "bad prefix, cursor or limit")
+		return
+	}
+	page := httpPage{Entries: make([]httpEntry, 0, len(args)/2), Cursor: args[0]}
+	for i := 1; i < len(args); i += 2 {
+		page.Entries = append(page.Entries, newHttpEntry(args[i], args[i+1]))
+	}
+	writeHttpJson(response, http.StatusOK, page)
+}
+
+func (kvs *KvServer) handleHttpCluster(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		response.Header().Set("Allow", "GET, HEAD")
+		writeHttpError(response, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	cluster := httpCluster{Server: getServerHostKey(), Peers: []httpPeer{}}
+	names := kvs.servers.ListKeys()
+	sort.Strings(names)
+	for _, name := range names {
+		if address, err := kvs.servers.Get(name); err == nil {
+			cluster.Peers = append(cluster.Peers, httpPeer{Name: name, Address: address})
+		}
+	}
+	if kind, args := kvs.handleForReply(&commandMessage{Command: "mem"}); kind == "mem" {
+		cluster.Memory = map[string]string{}
+		for i := 0; i+1 < len(args); i += 2 {
+			cluster.Memory[args[i]] = args[i+1]
+		}
+	}
+	writeHttpJson(response, http.StatusOK, cluster)
+}
+
+// json strings can only hold utf-8, so any other value is sent in base64...
+func newHttpEntry(key string, value string) httpEntry {
+	if utf8.ValidString(value) {
+		return httpEntry{Key: key, Value: value}
+	}
+	return httpEntry{Key: key, Value: base64.StdEncoding.EncodeToString([]byte(value)), Encoding: "base64"}
+}
+
+func writeHttpJson(response http.ResponseWriter, status int, body interface{}) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		status, data = http.StatusInternalServerError, []byte(`{"error":"encoding failed"}`)
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	_, _ = response.Write(append(data, '\n'))
+}
+
+func writeHttpError(response http.ResponseWriter, status int, message string) {
+	writeHttpJson(response, status, httpError{Error: message})
+}
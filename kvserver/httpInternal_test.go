@@ -0,0 +1,203 @@
+package kvserver
+
+import (
+	"encoding/json"
+	"io"
+	"kvsapp/assertions"
+	"kvsapp/parsing"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func sendHttp(t *testing.T, testObject *KvServer, method string, target string, body string) (int, map[string]interface{}) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	testObject.newHttpHandler().ServeHTTP(recorder, httptest.NewRequest(method, target, strings.NewReader(body)))
+	assertions.NewAssert(t).TestString(method+" "+target, "content type", "application/json", recorder.Header().Get("Content-Type"))
+	result := map[string]interface{}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatalf("param: %s %s, body: %q (%v)", method, target, recorder.Body.String(), err)
+	}
+	return recorder.Code, result
+}
+
+func TestHttpKeys(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+
+	for _, step := range []struct {
+		method   string
+		target   string
+		body     string
+		expected int
+		field    string
+		value    string
+	}{
+		{http.MethodGet, "/keys/a", "", http.StatusNotFound, "error", "not found"},
+		{http.MethodPut, "/keys/a", "hello world", http.StatusOK, "key", "a"},
+		{http.MethodGet, "/keys/a", "", http.StatusOK, "value", "hello world"},
+		{http.MethodPut, "/keys/b%2Fc", "\x00\xff", http.StatusOK, "key", "b/c"},
+		{http.MethodGet, "/keys/b/c", "", http.StatusOK, "value", "AP8="},
+		{http.MethodGet, "/keys/b/c", "", http.StatusOK, "encoding", "base64"},
+		{http.MethodPut, "/keys/a", "", http.StatusBadRequest, "error", "empty values are not supported"},
+		{http.MethodGet, "/keys/", "", http.StatusBadRequest, "error", "empty keys are not supported"},
+		{http.MethodPost, "/keys/a", "x", http.StatusMethodNotAllowed, "error", "method not allowed"},
+		{http.MethodDelete, "/keys/a", "", http.StatusOK, "key", "a"},
+		{http.MethodDelete, "/keys/a", "", http.StatusNotFound, "error", "not found"},
+		{http.MethodGet, "/keys/a", "", http.StatusNotFound, "error", "not found"},
+	} {
+		name := step.method + " " + step.target
+		status, body := sendHttp(t, testObject, step.method, step.target, step.body)
+		assert.TestString(name, "status", http.StatusText(step.expected), http.StatusText(status))
+		actual, _ := body[step.field].(string)
+		assert.TestString(name, step.field, step.value, actual)
+	}
+
+	// the same store is reachable from our own protocol...
+	value, _ := testObject.store.Get("b/c")
+	assert.String("value", "\x00\xff", value)
+}
+
+func TestHttpPutAnswersWithTheVersion(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+
+	_, first := sendHttp(t, testObject, http.MethodPut, "/keys/a", "1")
+	_, second := sendHttp(t, testObject, http.MethodPut, "/keys/a", "2")
+	firstVersion, _ := strconv.ParseUint(first["version"].(string), 10, 64)
+	secondVersion, _ := strconv.ParseUint(second["version"].(string), 10, 64)
+	assert.True("version", firstVersion > 0 && secondVersion > firstVersion)
+}
+
+func TestHttpRejectsKeysAndValuesOverTheLimits(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+	testObject.limits.MaxKeyLength = 2
+	testObject.limits.MaxValueLength = 4
+
+	status, _ := sendHttp(t, testObject, http.MethodPut, "/keys/abc", "1")
+	assert.Int("key", http.StatusRequestURITooLong, status)
+	status, _ = sendHttp(t, testObject, http.MethodPut, "/keys/ab", "12345")
+	assert.Int("value", http.StatusRequestEntityTooLarge, status)
+	status, _ = sendHttp(t, testObject, http.MethodPut, "/keys/ab", "1234")
+	assert.Int("within", http.StatusOK, status)
+}
+
+func TestHttpListsKeysByPrefix(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+	for _, key := range []string{"a:1", "a:2", "a:3", "b:1", "*"} {
+		_, _ = testObject.store.Upsert(key, "v"+key)
+	}
+
+	listKeys := func(target string) (keys string, cursor string) {
+		status, body := sendHttp(t, testObject, http.MethodGet, target, "")
+		assert.TestString(target, "status", http.StatusText(http.StatusOK), http.StatusText(status))
+		entries, _ := body["entries"].([]interface{})
+		names := []string{}
+		for _, entry := range entries {
+			fields, _ := entry.(map[string]interface{})
+			names = append(names, fields["key"].(string)+"="+fields["value"].(string))
+		}
+		cursor, _ = body["cursor"].(string)
+		return strings.Join(names, ","), cursor
+	}
+
+	keys, cursor := listKeys("/keys?prefix=a:")
+	assert.String("keys", "a:1=va:1,a:2=va:2,a:3=va:3", keys)
+	assert.String("cursor", "", cursor)
+
+	keys, cursor = listKeys("/keys?prefix=a:&limit=2")
+	assert.String("keys", "a:1=va:1,a:2=va:2", keys)
+	assert.String("cursor", "a:3", cursor)
+	keys, cursor = listKeys("/keys?prefix=a:&limit=2&cursor=" + cursor)
+	assert.String("keys", "a:3=va:3", keys)
+	assert.String("cursor", "", cursor)
+
+	keys, _ = listKeys("/keys")
+	assert.String("keys", "*=v*,a:1=va:1,a:2=va:2,a:3=va:3,b:1=vb:1", keys)
+	keys, _ = listKeys("/keys?prefix=c:")
+	assert.String("keys", "", keys)
+
+	status, _ := sendHttp(t, testObject, http.MethodGet, "/keys?limit=none", "")
+	assert.Int("limit", http.StatusBadRequest, status)
+}
+
+func TestHttpClusterStatus(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+	testObject.servers.Upsert("[peer]", "127.0.0.1:1")
+
+	status, body := sendHttp(t, testObject, http.MethodGet, "/cluster", "")
+	assert.Int("status", http.StatusOK, status)
+	assert.String("server", getServerHostKey(), body["server"].(string))
+	peers, _ := body["peers"].([]interface{})
+	assert.Int("len(peers)", 1, len(peers))
+	peer, _ := peers[0].(map[string]interface{})
+	assert.String("name", "[peer]", peer["name"].(string))
+	assert.String("address", "127.0.0.1:1", peer["address"].(string))
+}
+
+func TestHttpWritesAreReplicated(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+
+	// a peer that answers each message it is sent, as another server would...
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err.Error())
+	}
+	defer listener.Close()
+	received := make(chan string, 2)
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			data := make([]byte, 256)
+			read, _ := connection.Read(data)
+			_, _ = connection.Write([]byte("ack"))
+			_ = connection.Close()
+			received <- string(data[:read])
+		}
+	}()
+	testObject.servers.Upsert("[peer]", listener.Addr().String())
+
+	_, body := sendHttp(t, testObject, http.MethodPut, "/keys/a", "1")
+	expected, _ := parsing.CreateData("spt", "a", "1", body["version"].(string))
+	assert.String("put", string(expected), <-received)
+	_, body = sendHttp(t, testObject, http.MethodDelete, "/keys/a", "")
+	expected, _ = parsing.CreateData("sdl", "a", body["version"].(string))
+	assert.String("del", string(expected), <-received)
+}
+
+func TestHttpServesOverTheNetwork(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+	server := httptest.NewServer(testObject.newHttpHandler())
+	defer server.Close()
+
+	request, _ := http.NewRequest(http.MethodPut, server.URL+"/keys/a", strings.NewReader("1"))
+	response, err := server.Client().Do(request)
+	assert.Error(nil, err)
+	_ = response.Body.Close()
+	assert.Int("put", http.StatusOK, response.StatusCode)
+
+	response, err = server.Client().Get(server.URL + "/keys/a")
+	assert.Error(nil, err)
+	data, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	assert.String("get", "{\"key\":\"a\",\"value\":\"1\"}\n", string(data))
+}
@@ -0,0 +1,113 @@
+package kvserver
+
+import (
+	"fmt"
+	"kvsapp/kvstore"
+	"kvsapp/parsing"
+	"strconv"
+)
+
+// a client sends 'lsn' with a glob pattern to have every change to a matching key pushed to it
+// as a 'chg' frame (op, key, old value, new value, version) until it sends 'uln' with the same
+// pattern or disconnects. ops are 'put', 'del', 'exp' (expired) and 'evc' (evicted). if the
+// client falls too far behind the store stops the subscription, and an 'lsx' frame holding the
+// pattern says so...
+var changeOpNames = map[kvstore.ChangeOp]string{
+	kvstore.ChangeUpsert: "put",
+	kvstore.ChangeDelete: "del",
+	kvstore.ChangeExpire: "exp",
+	kvstore.ChangeEvict:  "evc",
+}
+
+// one subscription; a forwarder holds on to its own, so it can never remove a newer
+// subscription to the same pattern...
+type kvListener struct {
+	stop func()
+}
+
+func isListenCommand(command string) bool {
+	return command == "lsn" || command == "uln"
+}
+
+func (kvs *KvServer) handleListenMessage(session *kvSession, message *commandMessage) (carryOn bool) {
+	fmt.Printf("server: handling '%s' command\n", message.Command)
+
+	response := "err"
+	switch message.Command {
+	case "lsn":
+		response = kvs.startListening(session, message.Key)
+	case "uln":
+		response = "nil"
+		if session.stopListening(message.Key, nil) {
+			response = "ack"
+		}
+	}
+	_, err := session.Write([]byte(response))
+	return err == nil
+}
+
+func (kvs *KvServer) startListening(session *kvSession, pattern string) string {
+	watchable, ok := kvs.store.(kvstore.WatchableStore)
+	if !ok {
+		return "err"
+	}
+	session.listenLock.Lock()
+	defer session.listenLock.Unlock()
+	if _, exists := session.listeners[pattern]; exists {
+		return "ack"
+	}
+	events, stop := watchable.Watch(globLiteralPrefix(pattern))
+	if session.listeners == nil {
+		session.listeners = make(map[string]*kvListener)
+	}
+	listener := &kvListener{stop: stop}
+	session.listeners[pattern] = listener
+	go kvs.forwardChanges(session, pattern, listener, events)
+	return "ack"
+}
+
+func (kvs *KvServer) forwardChanges(session *kvSession, pattern string, listener *kvListener, events <-chan kvstore.ChangeEvent) {
+	for event := range events {
+		if !matchGlob(pattern, event.Key) {
+			continue
+		}
+		values := []string{changeOpNames[event.Op], event.Key, event.OldValue, event.NewValue, strconv.FormatUint(event.Version, 10)}
+		if bytesToWrite, err := parsing.CreateMultiData("chg", values); err == nil {
+			_, _ = session.Write(bytesToWrite)
+		}
+	}
+	// the channel closes either because the client stopped listening or because the store
+	// dropped it; only the latter needs telling...
+	if session.stopListening(pattern, listener) {
+		if bytesToWrite, err := parsing.CreateData("lsx", pattern); err == nil {
+			_, _ = session.Write(bytesToWrite)
+		}
+	}
+}
+
+// stops the subscription to pattern, but only if it is still listener (or any subscription, if listener is nil)...
+func (session *kvSession) stopListening(pattern string, listener *kvListener) bool {
+	session.listenLock.Lock()
+	current, exists := session.listeners[pattern]
+	exists = exists && (listener == nil || current == listener)
+	if exists {
+		delete(session.listeners, pattern)
+	}
+	session.listenLock.Unlock()
+	if exists {
+		current.stop()
+	}
+	return exists
+}
+
+func (session *kvSession) stopListeningToAll() {
+	session.listenLock.Lock()
+	patterns := make([]string, 0, len(session.listeners))
+	for pattern := range session.listeners {
+		patterns = append(patterns, pattern)
+	}
+	session.listenLock.Unlock()
+	for _, pattern := range patterns {
+		session.stopListening(pattern, nil)
+	}
+}
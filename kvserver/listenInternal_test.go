@@ -0,0 +1,80 @@
+package kvserver
+
+import (
+	"bytes"
+	"kvsapp/assertions"
+	"kvsapp/parsing"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// waits for pushed output, reading it under the session's write lock...
+func waitForSessionOutput(session *kvSession, buffer *bytes.Buffer, expected string) string {
+	deadline := time.Now().Add(time.Second)
+	for {
+		session.writeLock.Lock()
+		actual := buffer.String()
+		session.writeLock.Unlock()
+		if actual == expected || time.Now().After(deadline) {
+			return actual
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func createChangeFrame(op string, key string, oldValue string, newValue string, version uint64) string {
+	frame, _ := parsing.CreateMultiData("chg", []string{op, key, oldValue, newValue, strconv.FormatUint(version, 10)})
+	return string(frame)
+}
+
+func TestListenPushesMatchingChanges(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+	listener, listenerBuffer := createTestSession()
+	writer, writerBuffer := createTestSession()
+	defer listener.stopListeningToAll()
+
+	assert.String("lsn", "ack", sendToSession(t, testObject, listener, listenerBuffer, "lsn16user:*"))
+	listenerBuffer.Reset()
+	version1, _ := testObject.store.Upsert("user:1", "a")
+	testObject.store.Upsert("group:1", "b")
+	version2, _ := testObject.store.Upsert("user:1", "c")
+	deleted, _ := testObject.store.Delete("user:1")
+
+	expected := createChangeFrame("put", "user:1", "", "a", version1) +
+		createChangeFrame("put", "user:1", "a", "c", version2) +
+		createChangeFrame("del", "user:1", "c", "", deleted)
+	assert.String("pushed", expected, waitForSessionOutput(listener, listenerBuffer, expected))
+
+	listener.writeLock.Lock()
+	listenerBuffer.Reset()
+	listener.writeLock.Unlock()
+	assert.String("uln", "ack", sendToSession(t, testObject, listener, listenerBuffer, "uln16user:*"))
+	assert.String("uln again", "nil", sendToSession(t, testObject, listener, listenerBuffer, "uln16user:*"))
+	sendToSession(t, testObject, writer, writerBuffer, "put16user:211d")
+	time.Sleep(10 * time.Millisecond)
+	assert.String("after uln", "nil", waitForSessionOutput(listener, listenerBuffer, "nil"))
+}
+
+func TestListenAgainAfterStoppingKeepsNewSubscription(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+	listener, listenerBuffer := createTestSession()
+	defer listener.stopListeningToAll()
+
+	assert.String("lsn", "ack", sendToSession(t, testObject, listener, listenerBuffer, "lsn11k"))
+	assert.String("uln", "ack", sendToSession(t, testObject, listener, listenerBuffer, "uln11k"))
+	assert.String("lsn again", "ack", sendToSession(t, testObject, listener, listenerBuffer, "lsn11k"))
+	// give the first forwarder time to finish with its closed channel...
+	time.Sleep(10 * time.Millisecond)
+
+	listener.writeLock.Lock()
+	listenerBuffer.Reset()
+	listener.writeLock.Unlock()
+	version, _ := testObject.store.Upsert("k", "v")
+	expected := createChangeFrame("put", "k", "", "v", version)
+	assert.String("pushed", expected, waitForSessionOutput(listener, listenerBuffer, expected))
+}
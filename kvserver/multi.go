@@ -0,0 +1,95 @@
+package kvserver
+
+import (
+	"errors"
+	"fmt"
+	"kvsapp/kvstore"
+	"kvsapp/parsing"
+	"strconv"
+)
+
+// mgt and mpt read and write many keys in one round trip. they are variadic: the keys, or key and
+// value pairs, follow a count of them, as CreateMultiData writes them. mgt answers 'kvs' with the
+// key and value of each key found, in the order asked for; mpt writes every pair or none, answers
+// 'vsm' with the version of each pair, and is replicated to each peer as one 'smp' holding key,
+// value and version triples...
+
+// mgt <count> <key>...
+func handleMgt(kvs *KvServer, message *commandMessage) string {
+	multi, ok := kvs.store.(kvstore.MultiKeyStore)
+	if !ok {
+		return "err"
+	}
+	keys := message.Args
+	results, err := multi.GetMany(keys)
+	if err != nil {
+		return "err"
+	}
+	values := make([]string, 0, 2*len(results))
+	for i, result := range results {
+		if result.Error == nil {
+			values = append(values, keys[i], result.Value)
+		}
+	}
+	return multiResponse("kvs", values)
+}
+
+// mpt <count> <key> <value>...
+func handleMpt(kvs *KvServer, message *commandMessage) string {
+	multi, ok := kvs.store.(kvstore.MultiKeyStore)
+	if !ok {
+		return "err"
+	}
+	pairs := message.Args
+	if len(pairs)%2 != 0 {
+		return "err"
+	}
+	entries := make([]kvstore.KeyValue, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		entries = append(entries, kvstore.KeyValue{Key: pairs[i], Value: pairs[i+1]})
+	}
+	versions, err := multi.UpsertMany(entries)
+	if errors.Is(err, kvstore.ErrOutOfMemory) {
+		return "oom"
+	} else if err != nil {
+		return "err"
+	}
+	values := make([]string, len(versions))
+	triples := make([]string, 0, 3*len(entries))
+	for i, version := range versions {
+		values[i] = strconv.FormatUint(version, 10)
+		triples = append(triples, entries[i].Key, entries[i].Value, values[i])
+	}
+	if len(triples) > 0 {
+		if data, err := parsing.CreateMultiData("smp", triples); err == nil {
+			kvs.sendDataToAllOthers("smp", data)
+		}
+	}
+	return multiResponse("vsm", values)
+}
+
+// applies a batch of writes replicated from another server; any the keys already have newer
+// versions of are ignored...
+func handleSmp(kvs *KvServer, message *commandMessage) string {
+	versioned, ok := kvs.store.(kvstore.VersionedStore)
+	if !ok {
+		return "err"
+	}
+	triples := message.Args
+	if len(triples)%3 != 0 {
+		return "err"
+	}
+	for i := 0; i < len(triples); i += 3 {
+		version, err := strconv.ParseUint(triples[i+2], 10, 64)
+		if err != nil {
+			return "err"
+		}
+		_, err = versioned.UpsertVersion(triples[i], triples[i+1], version)
+		if errors.Is(err, kvstore.ErrStaleVersion) {
+			fmt.Printf("server: ignoring stale write of key '%s'\n", triples[i])
+		} else if err != nil {
+			return "err"
+		}
+	}
+	return "ack"
+}
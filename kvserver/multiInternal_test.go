@@ -0,0 +1,46 @@
+package kvserver
+
+import (
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"testing"
+)
+
+func TestHandleMultiKeyCommands(t *testing.T) {
+	t.Parallel()
+	runHandleMessageSteps(t, createTestObject(), []handleMessageTestStep{
+		{command: "mpt", args: []string{"a", "1", "b", "2", "a", "3"}, expectedWrite: "vsm13*"},
+		{command: "mgt", args: []string{"a", "missing", "b"}, expectedWrite: "kvs14" + "11a113" + "11b112"},
+		{command: "mgt", args: []string{}, expectedWrite: "kvs10"},
+		{command: "mpt", args: []string{"a", "1", "b"}, expectedWrite: "err"},
+	})
+}
+
+func TestHandleReplicatedMultiPut(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+	testObject.store.Upsert("b", "newer")
+	_, newer, _ := testObject.store.(kvstore.VersionedStore).GetWithVersion("b")
+	runHandleMessageSteps(t, testObject, []handleMessageTestStep{
+		{command: "smp", args: []string{"a", "x", "100", "b", "y", "100"}, expectedWrite: "ack"},
+		{command: "smp", args: []string{"a", "x"}, expectedWrite: "err"},
+		{command: "smp", args: []string{"a", "x", "v"}, expectedWrite: "err"},
+	})
+	value, _ := testObject.store.Get("a")
+	assert.String("a", "x", value)
+	value, version, _ := testObject.store.(kvstore.VersionedStore).GetWithVersion("b")
+	assert.String("b keeps newer value", "newer", value)
+	assert.Uint64("b keeps newer version", newer, version)
+}
+
+func TestMultiKeyCommandsOverConnection(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+	session, buffer := createTestSession()
+
+	response := sendToSession(t, testObject, session, buffer, "mpt1411a11111b112")
+	assert.String("mpt", "vsm12", response[:5])
+	assert.String("mgt", "kvs12"+"11a111", sendToSession(t, testObject, session, buffer, "mgt1211a12xx"))
+}
@@ -0,0 +1,78 @@
+package kvserver
+
+import (
+	"bytes"
+	"kvsapp/assertions"
+	"kvsapp/client"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// counts the writes that reach the connection...
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (writer *countingWriter) Write(data []byte) (int, error) {
+	writer.writes++
+	return writer.Buffer.Write(data)
+}
+
+func TestRepliesToOneReadAreWrittenTogether(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+	connection := &countingWriter{}
+	session, _ := createTestSession()
+	session = newKvSession(connection, session.parser)
+
+	carryOn, err := testObject.handleReceivedBytes(session, []byte("put11a11x"+"get11a"+"get11b"+"xyz"+"del11a"))
+	assert.True("carryOn", carryOn)
+	assert.Error(nil, err)
+	assert.Int("writes", 1, connection.writes)
+	replies := connection.String()
+	assert.True("in order", strings.HasPrefix(replies, "vsn") && strings.Contains(replies, "val11xnilerrvsn"))
+
+	// anything written outside a read, such as a change being pushed, goes straight out...
+	_, _ = session.Write([]byte("chg10"))
+	assert.Int("writes", 2, connection.writes)
+}
+
+func TestPipelinedCommandsOverConnection(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+	clientSide, serverSide := net.Pipe()
+	go testObject.handleTcpConnection(serverSide)
+	testClient, _ := client.NewClient(clientSide)
+	defer testClient.Close()
+
+	// enough to take several reads, and more replies than fit in one flush...
+	const count = 500
+	pipeline := testClient.Pipeline()
+	for i := 0; i < count; i++ {
+		pipeline.Send("put", "key"+strconv.Itoa(i), strings.Repeat("v", i+1))
+		pipeline.Send("get", "key"+strconv.Itoa(i))
+	}
+	pipeline.SendMulti("mgt", []string{"key1", "missing", "key2"})
+	pipeline.Send("grg", "key3", "10", "1")
+	replies, err := pipeline.Exec()
+	assert.Error(nil, err)
+	assert.Int("replies", 2*count+2, len(replies))
+	for i := 0; i < count && len(replies) == 2*count+2; i++ {
+		assert.String("put", "vsn", replies[2*i].Kind)
+		assert.String("get", strings.Repeat("v", i+1), strings.Join(replies[2*i+1].Values, ""))
+	}
+	if len(replies) == 2*count+2 {
+		assert.String("mgt", "key1,vv,key2,vvv", strings.Join(replies[2*count].Values, ","))
+		assert.String("empty grg", "val", replies[2*count+1].Kind)
+		assert.Int("empty grg", 1, len(replies[2*count+1].Values))
+	}
+
+	reply, err := testClient.Do("get", "key3")
+	assert.Error(nil, err)
+	assert.String("do", "vvvv", reply.Values[0])
+}
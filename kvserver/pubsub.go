@@ -0,0 +1,183 @@
+package kvserver
+
+import (
+	"fmt"
+	"kvsapp/parsing"
+	"sync"
+)
+
+// a client sends 'sub' with a channel name, or 'psb' with a glob pattern of channel names, to
+// have every message published to a matching channel pushed to it: as a 'msg' frame (channel,
+// message) for a channel subscription, or a 'pms' frame (pattern, channel, message) for a
+// pattern one. 'uns' and 'pun' undo them. 'pub' publishes a message, answering with the number
+// of subscribers on this server it reached, and passes it on to the rest of the cluster with
+// 'spb' so that subscribers to every server receive it. delivery is at most once: a subscriber
+// that falls too far behind misses messages rather than holding up the publisher...
+
+// how many messages a subscriber may fall behind by before it starts missing them...
+const KvServerSubscriberBufferSize int = 256
+
+type kvBroker struct {
+	lock     sync.RWMutex
+	channels map[string]map[*kvSubscriber]struct{}
+	patterns map[string]map[*kvSubscriber]struct{}
+}
+
+// a session's subscriptions, and the queue of messages on their way to it. the subscription
+// maps are guarded by the broker's lock...
+type kvSubscriber struct {
+	messages chan []byte
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+func newKvBroker() *kvBroker {
+	return &kvBroker{
+		channels: make(map[string]map[*kvSubscriber]struct{}),
+		patterns: make(map[string]map[*kvSubscriber]struct{}),
+	}
+}
+
+func isSubscribeCommand(command string) bool {
+	return command == "sub" || command == "uns" || command == "psb" || command == "pun"
+}
+
+func (kvs *KvServer) handleSubscribeMessage(session *kvSession, message *commandMessage) (carryOn bool) {
+	fmt.Printf("server: handling '%s' command\n", message.Command)
+
+	response := "err"
+	switch message.Command {
+	case "sub":
+		response = kvs.broker.subscribe(session, message.Key, false)
+	case "psb":
+		response = kvs.broker.subscribe(session, message.Key, true)
+	case "uns":
+		response = kvs.broker.unsubscribe(session, message.Key, false)
+	case "pun":
+		response = kvs.broker.unsubscribe(session, message.Key, true)
+	}
+	_, err := session.Write([]byte(response))
+	return err == nil
+}
+
+func (broker *kvBroker) subscribe(session *kvSession, name string, isPattern bool) string {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	subscriber := session.subscriber
+	if subscriber == nil {
+		subscriber = &kvSubscriber{
+			messages: make(chan []byte, KvServerSubscriberBufferSize),
+			channels: make(map[string]struct{}),
+			patterns: make(map[string]struct{}),
+		}
+		session.subscriber = subscriber
+		go forwardMessages(session, subscriber.messages)
+	}
+	subscriptions, names := broker.channels, subscriber.channels
+	if isPattern {
+		subscriptions, names = broker.patterns, subscriber.patterns
+	}
+	if subscriptions[name] == nil {
+		subscriptions[name] = make(map[*kvSubscriber]struct{})
+	}
+	subscriptions[name][subscriber] = struct{}{}
+	names[name] = struct{}{}
+	return "ack"
+}
+
+func (broker *kvBroker) unsubscribe(session *kvSession, name string, isPattern bool) string {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	subscriber := session.subscriber
+	if subscriber == nil {
+		return "nil"
+	}
+	subscriptions, names := broker.channels, subscriber.channels
+	if isPattern {
+		subscriptions, names = broker.patterns, subscriber.patterns
+	}
+	if _, exists := names[name]; !exists {
+		return "nil"
+	}
+	broker.remove(subscriptions, name, subscriber)
+	delete(names, name)
+	if len(subscriber.channels) == 0 && len(subscriber.patterns) == 0 {
+		session.subscriber = nil
+		close(subscriber.messages)
+	}
+	return "ack"
+}
+
+// drops every subscription the session holds, as it disconnects...
+func (broker *kvBroker) unsubscribeAll(session *kvSession) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	subscriber := session.subscriber
+	if subscriber == nil {
+		return
+	}
+	for name := range subscriber.channels {
+		broker.remove(broker.channels, name, subscriber)
+	}
+	for name := range subscriber.patterns {
+		broker.remove(broker.patterns, name, subscriber)
+	}
+	session.subscriber = nil
+	close(subscriber.messages)
+}
+
+func (broker *kvBroker) remove(subscriptions map[string]map[*kvSubscriber]struct{}, name string, subscriber *kvSubscriber) {
+	delete(subscriptions[name], subscriber)
+	if len(subscriptions[name]) == 0 {
+		delete(subscriptions, name)
+	}
+}
+
+// queues message for every subscriber to channel on this server, returning how many it reached...
+func (broker *kvBroker) publish(channel string, message string) int {
+	broker.lock.RLock()
+	defer broker.lock.RUnlock()
+	delivered := 0
+	if subscribers := broker.channels[channel]; len(subscribers) > 0 {
+		frame, err := parsing.CreateMultiData("msg", []string{channel, message})
+		if err != nil {
+			return 0
+		}
+		for subscriber := range subscribers {
+			if subscriber.deliver(frame) {
+				delivered++
+			}
+		}
+	}
+	for pattern, subscribers := range broker.patterns {
+		if !matchGlob(pattern, channel) {
+			continue
+		}
+		frame, err := parsing.CreateMultiData("pms", []string{pattern, channel, message})
+		if err != nil {
+			continue
+		}
+		for subscriber := range subscribers {
+			if subscriber.deliver(frame) {
+				delivered++
+			}
+		}
+	}
+	return delivered
+}
+
+func (subscriber *kvSubscriber) deliver(frame []byte) bool {
+	select {
+	case subscriber.messages <- frame:
+		return true
+	default:
+		fmt.Printf("server: subscriber is too far behind, dropping message\n")
+		return false
+	}
+}
+
+func forwardMessages(session *kvSession, messages <-chan []byte) {
+	for frame := range messages {
+		_, _ = session.Write(frame)
+	}
+}
@@ -0,0 +1,52 @@
+package kvserver
+
+import (
+	"kvsapp/assertions"
+	"testing"
+)
+
+func TestPublishReachesChannelAndPatternSubscribers(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+	subscriber, subscriberBuffer := createTestSession()
+	patternSubscriber, patternBuffer := createTestSession()
+	publisher, publisherBuffer := createTestSession()
+	defer testObject.broker.unsubscribeAll(subscriber)
+	defer testObject.broker.unsubscribeAll(patternSubscriber)
+
+	assert.String("sub", "ack", sendToSession(t, testObject, subscriber, subscriberBuffer, "sub14news"))
+	assert.String("psb", "ack", sendToSession(t, testObject, patternSubscriber, patternBuffer, "psb13n*s"))
+	subscriberBuffer.Reset()
+	patternBuffer.Reset()
+	assert.String("pub", "val112", sendToSession(t, testObject, publisher, publisherBuffer, "pub14news15hello"))
+	assert.String("pub elsewhere", "val110", sendToSession(t, testObject, publisher, publisherBuffer, "pub15other11x"))
+
+	expected := "msg1214news15hello"
+	assert.String("pushed", expected, waitForSessionOutput(subscriber, subscriberBuffer, expected))
+	expected = "pms1313n*s14news15hello"
+	assert.String("pushed to pattern", expected, waitForSessionOutput(patternSubscriber, patternBuffer, expected))
+
+	assert.String("uns", "ack", sendToSession(t, testObject, subscriber, subscriberBuffer, "uns14news"))
+	assert.String("uns again", "nil", sendToSession(t, testObject, subscriber, subscriberBuffer, "uns14news"))
+	assert.String("pun unknown", "nil", sendToSession(t, testObject, patternSubscriber, patternBuffer, "pun14news"))
+	assert.String("pub after uns", "val111", sendToSession(t, testObject, publisher, publisherBuffer, "pub14news15again"))
+}
+
+func TestUnsubscribeAllForgetsSession(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+	subscriber, subscriberBuffer := createTestSession()
+	publisher, publisherBuffer := createTestSession()
+
+	assert.String("sub", "ack", sendToSession(t, testObject, subscriber, subscriberBuffer, "sub14news"))
+	assert.String("psb", "ack", sendToSession(t, testObject, subscriber, subscriberBuffer, "psb11*"))
+	testObject.broker.unsubscribeAll(subscriber)
+
+	assert.String("pub", "val110", sendToSession(t, testObject, publisher, publisherBuffer, "pub14news15hello"))
+	assert.Int("channels", 0, len(testObject.broker.channels))
+	assert.Int("patterns", 0, len(testObject.broker.patterns))
+	assert.String("sub again", "ack", sendToSession(t, testObject, subscriber, subscriberBuffer, "sub14news"))
+	testObject.broker.unsubscribeAll(subscriber)
+}
@@ -0,0 +1,274 @@
+package kvserver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"kvsapp/kvstore"
+	"kvsapp/parsing"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// a second listener speaks RESP2, the redis protocol, so that redis-cli and the redis client
+// libraries can reach the store. each command is mapped onto the handler for its counterpart
+// here, so writes are replicated to the rest of the cluster just as they are from our own
+// clients. only a handful of commands are understood: GET, SET, DEL, EXISTS, KEYS, PING and QUIT.
+
+var errRespProtocol = errors.New("protocol error")
+
+func (kvs *KvServer) handleRespAcceptance(listener net.Listener) {
+	for {
+		connection, err := listener.Accept()
+		if err != nil {
+			fmt.Printf("server-resp: error accepting connection: %s\n", err.Error())
+			continue
+		}
+		go kvs.handleRespConnection(connection)
+	}
+}
+
+func (kvs *KvServer) handleRespConnection(connection io.ReadWriteCloser) {
+	defer func() { _ = connection.Close() }()
+
+	reader := bufio.NewReader(connection)
+	writer := bufio.NewWriter(connection)
+	for {
+		args, err := readRespCommand(reader, kvs.limits)
+		if err != nil {
+			if err != io.EOF {
+				fmt.Printf("server-resp: closing connection after '%s'\n", err.Error())
+				_, _ = writer.WriteString(respError("ERR " + err.Error()))
+				_ = writer.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		reply, carryOn := kvs.handleRespCommand(args)
+		if _, err := writer.WriteString(reply); err != nil {
+			return
+		}
+		// pipelined commands are answered together, once there are none left to read...
+		if !carryOn || reader.Buffered() == 0 {
+			if err := writer.Flush(); err != nil || !carryOn {
+				return
+			}
+		}
+	}
+}
+
+// reads a command sent as an array of bulk strings or, as from telnet, a line of words...
+func readRespCommand(reader *bufio.Reader, limits parsing.ParserOptions) ([]string, error) {
+	line, err := readRespLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	count, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, errRespProtocol
+	}
+	// every argument takes at least six bytes ("$0\r\n\r\n"), so a count can't claim more than fit...
+	if limits.MaxFrameLength > 0 && count > limits.MaxFrameLength/6 {
+		return nil, parsing.ErrParserTooLarge
+	}
+	args := make([]string, 0, 4)
+	frameLength := len(line)
+	for i := 0; i < count; i++ {
+		line, err := readRespLine(reader)
+		if err != nil {
+			return nil, unexpectedRespEOF(err)
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, errRespProtocol
+		}
+		length, err := strconv.Atoi(line[1:])
+		if err != nil || length < 0 {
+			return nil, errRespProtocol
+		}
+		// the command name is the first element, held only to the frame's limit, then come its
+		// keys and values...
+		limit := 0
+		switch {
+		case i > 0 && isRespKeyArgument(args[0], i):
+			limit = limits.MaxKeyLength
+		case i > 0:
+			limit = limits.MaxValueLength
+		}
+		frameLength += len(line) + length
+		if (limit > 0 && length > limit) || (limits.MaxFrameLength > 0 && frameLength > limits.MaxFrameLength) {
+			return nil, parsing.ErrParserTooLarge
+		}
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, unexpectedRespEOF(err)
+		}
+		if !bytes.HasSuffix(data, []byte("\r\n")) {
+			return nil, errRespProtocol
+		}
+		args = append(args, string(data[:length]))
+	}
+	return args, nil
+}
+
+// whether the element at position (the command name being at zero) is a key, or a pattern of them
+// as for KEYS, rather than a value: only SET's value and PING's message are...
+func isRespKeyArgument(command string, position int) bool {
+	switch strings.ToLower(command) {
+	case "set":
+		return position == 1
+	case "ping":
+		return false
+	}
+	return true
+}
+
+// reads a line ended by CRLF (or LF alone, from telnet), no longer than the reader's buffer...
+func readRespLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", parsing.ErrParserTooLarge
+	}
+	if err != nil {
+		if len(line) > 0 {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+func unexpectedRespEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// answers a command, and whether to carry on reading from the connection...
+func (kvs *KvServer) handleRespCommand(args []string) (reply string, carryOn bool) {
+	name := strings.ToLower(args[0])
+	args = args[1:]
+	switch name {
+	case "ping":
+		switch len(args) {
+		case 0:
+			return respSimple("PONG"), true
+		case 1:
+			return respBulk(args[0]), true
+		}
+	case "quit":
+		return respSimple("OK"), false
+	case "get":
+		if len(args) == 1 {
+			return kvs.handleRespGet(args[0]), true
+		}
+	case "set":
+		if len(args) == 2 {
+			return kvs.handleRespSet(args[0], args[1]), true
+		}
+	case "del":
+		if len(args) > 0 {
+			return kvs.handleRespDel(args), true
+		}
+	case "exists":
+		if len(args) > 0 {
+			return kvs.handleRespExists(args), true
+		}
+	case "keys":
+		if len(args) == 1 {
+			return kvs.handleRespKeys(args[0]), true
+		}
+	default:
+		return respError(fmt.Sprintf("ERR unknown command '%s'", name)), true
+	}
+	return respError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name)), true
+}
+
+func (kvs *KvServer) handleRespGet(key string) string {
+	kind, args := kvs.handleForReply(&commandMessage{Command: "get", Key: key})
+	switch kind {
+	case "val":
+		return respBulk(args[0])
+	case "nil":
+		return "$-1\r\n"
+	case "wty":
+		return respError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return respError("ERR get failed")
+}
+
+func (kvs *KvServer) handleRespSet(key string, value string) string {
+	if err := checkPutArguments(key, value); err != nil {
+		return respError("ERR " + err.Error())
+	}
+	kind, _ := kvs.handleForReply(&commandMessage{Command: "put", Key: key, Value: value})
+	switch kind {
+	case "vsn":
+		return respSimple("OK")
+	case "oom":
+		return respError("OOM command not allowed when used memory > 'maxmemory'")
+	}
+	return respError("ERR set failed")
+}
+
+func (kvs *KvServer) handleRespDel(keys []string) string {
+	deleted := 0
+	for _, key := range keys {
+		// a delete of a key that didn't exist is given version zero...
+		if kind, args := kvs.handleForReply(&commandMessage{Command: "del", Key: key}); kind == "vsn" && args[0] != "0" {
+			deleted++
+		}
+	}
+	return respInteger(deleted)
+}
+
+func (kvs *KvServer) handleRespExists(keys []string) string {
+	found := 0
+	for _, key := range keys {
+		if _, err := kvs.store.Get(key); err == nil || errors.Is(err, kvstore.ErrWrongType) {
+			found++
+		}
+	}
+	return respInteger(found)
+}
+
+func (kvs *KvServer) handleRespKeys(pattern string) string {
+	keys := []string{}
+	for _, key := range kvs.store.ListKeys() {
+		if matchGlob(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var reply strings.Builder
+	reply.WriteString("*" + strconv.Itoa(len(keys)) + "\r\n")
+	for _, key := range keys {
+		reply.WriteString(respBulk(key))
+	}
+	return reply.String()
+}
+
+func respSimple(value string) string {
+	return "+" + value + "\r\n"
+}
+
+func respError(message string) string {
+	return "-" + message + "\r\n"
+}
+
+func respInteger(value int) string {
+	return ":" + strconv.Itoa(value) + "\r\n"
+}
+
+func respBulk(value string) string {
+	return "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+}
@@ -0,0 +1,122 @@
+package kvserver
+
+import (
+	"io"
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"net"
+	"testing"
+)
+
+// a redis client's end of a connection to the resp listener...
+func createRespConnection(testObject *KvServer) net.Conn {
+	clientSide, serverSide := net.Pipe()
+	go testObject.handleRespConnection(serverSide)
+	return clientSide
+}
+
+func sendResp(t *testing.T, connection net.Conn, request string, expected string) {
+	t.Helper()
+	go func() { _, _ = connection.Write([]byte(request)) }()
+	actual := make([]byte, len(expected))
+	if _, err := io.ReadFull(connection, actual); err != nil {
+		t.Fatalf("param: %q, expected: %q, actual: %q (%v)", request, expected, actual, err)
+	}
+	assertions.NewAssert(t).TestString(request, "reply", expected, string(actual))
+}
+
+func TestRespCommands(t *testing.T) {
+	t.Parallel()
+	testObject := createTestObject()
+	connection := createRespConnection(testObject)
+	defer connection.Close()
+
+	for _, step := range []struct {
+		request  string
+		expected string
+	}{
+		{"*1\r\n$4\r\nPING\r\n", "+PONG\r\n"},
+		{"*2\r\n$4\r\nping\r\n$2\r\nhi\r\n", "$2\r\nhi\r\n"},
+		{"*2\r\n$3\r\nGET\r\n$1\r\na\r\n", "$-1\r\n"},
+		{"*3\r\n$3\r\nSET\r\n$1\r\na\r\n$4\r\n\x00\xff\r\n\r\n", "+OK\r\n"},
+		{"*2\r\n$3\r\nGET\r\n$1\r\na\r\n", "$4\r\n\x00\xff\r\n\r\n"},
+		{"*3\r\n$3\r\nSET\r\n$2\r\nab\r\n$1\r\nx\r\n", "+OK\r\n"},
+		{"*4\r\n$6\r\nEXISTS\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\na\r\n", ":2\r\n"},
+		{"*2\r\n$4\r\nKEYS\r\n$1\r\n*\r\n", "*2\r\n$1\r\na\r\n$2\r\nab\r\n"},
+		{"*2\r\n$4\r\nKEYS\r\n$2\r\na?\r\n", "*1\r\n$2\r\nab\r\n"},
+		{"*3\r\n$3\r\nDEL\r\n$1\r\na\r\n$1\r\nb\r\n", ":1\r\n"},
+		{"*2\r\n$3\r\nGET\r\n$1\r\na\r\n", "$-1\r\n"},
+		{"*3\r\n$3\r\nSET\r\n$1\r\na\r\n$0\r\n\r\n", "-ERR empty values are not supported\r\n"},
+		{"*3\r\n$3\r\nSET\r\n$0\r\n\r\n$1\r\na\r\n", "-ERR empty keys are not supported\r\n"},
+		{"*1\r\n$3\r\nGET\r\n", "-ERR wrong number of arguments for 'get' command\r\n"},
+		{"*1\r\n$4\r\nINCR\r\n", "-ERR unknown command 'incr'\r\n"},
+		{"GET ab\r\n", "$1\r\nx\r\n"},
+		{"\r\n*0\r\nPING\n", "+PONG\r\n"},
+	} {
+		sendResp(t, connection, step.request, step.expected)
+	}
+
+	// the same store is reachable from our own protocol...
+	value, _ := testObject.store.Get("ab")
+	assertions.NewAssert(t).String("value", "x", value)
+}
+
+func TestRespAnswersPipelinedCommandsInOrder(t *testing.T) {
+	t.Parallel()
+	testObject := createTestObject()
+	testObject.store.(kvstore.StructuredStore).SetAdd("set", "m")
+	connection := createRespConnection(testObject)
+	defer connection.Close()
+
+	sendResp(t, connection,
+		"*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n"+"*2\r\n$3\r\nGET\r\n$1\r\nk\r\n"+"*2\r\n$3\r\nGET\r\n$3\r\nset\r\n"+"PING\r\n",
+		"+OK\r\n"+"$1\r\nv\r\n"+"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"+"+PONG\r\n")
+	sendResp(t, connection, "QUIT\r\n", "+OK\r\n")
+	if _, err := connection.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("param: read after quit, expected: EOF, actual: %v", err)
+	}
+}
+
+func TestRespClosesConnectionOnBadInput(t *testing.T) {
+	t.Parallel()
+	testObject := createTestObject()
+	for _, step := range []struct {
+		request  string
+		expected string
+	}{
+		{"*1\r\n+GET\r\n", "-ERR protocol error\r\n"},
+		{"*1\r\n$3\r\nGETxx", "-ERR protocol error\r\n"},
+		{"*x\r\n", "-ERR protocol error\r\n"},
+		{"*2\r\n$3\r\nGET\r\n$999999999\r\n", "-ERR too large\r\n"},
+	} {
+		connection := createRespConnection(testObject)
+		sendResp(t, connection, step.request, step.expected)
+		if _, err := connection.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("param: %q, expected: EOF, actual: %v", step.request, err)
+		}
+		connection.Close()
+	}
+}
+
+func TestRespHoldsEveryKeyToTheKeyLimit(t *testing.T) {
+	t.Parallel()
+	testObject := createTestObject()
+	testObject.limits.MaxKeyLength = 2
+	testObject.limits.MaxValueLength = 4
+
+	connection := createRespConnection(testObject)
+	sendResp(t, connection, "*3\r\n$3\r\nSET\r\n$2\r\nab\r\n$4\r\nabcd\r\n", "+OK\r\n")
+	sendResp(t, connection, "*2\r\n$4\r\nPING\r\n$4\r\nabcd\r\n", "$4\r\nabcd\r\n")
+	sendResp(t, connection, "*3\r\n$6\r\nEXISTS\r\n$1\r\na\r\n$2\r\nab\r\n", ":1\r\n")
+	connection.Close()
+
+	for _, request := range []string{
+		"*3\r\n$3\r\nDEL\r\n$1\r\na\r\n$3\r\nabc\r\n",
+		"*3\r\n$6\r\nEXISTS\r\n$2\r\nab\r\n$3\r\nabc\r\n",
+		"*2\r\n$4\r\nKEYS\r\n$3\r\nab*\r\n",
+	} {
+		connection := createRespConnection(testObject)
+		sendResp(t, connection, request, "-ERR too large\r\n")
+		connection.Close()
+	}
+}
@@ -0,0 +1,151 @@
+package kvserver
+
+import (
+	"encoding/base64"
+	"kvsapp/kvstore"
+	"kvsapp/parsing"
+	"strconv"
+	"strings"
+)
+
+// the most entries a single page of a scan will return...
+const KvServerMaxScanPage int = 1000
+
+// the number of keys a key scan examines when asked for a page size of zero...
+const KvServerDefaultScanPage int = 10
+
+// the cursor that starts a key scan, and is returned once it has finished...
+const scanCursorStart string = "0"
+
+// the range bound that leaves a range open at that end, since arguments can't be empty; a key
+// that is '*', or that starts with a backslash, is passed as a bound with a backslash in front...
+const scanOpenBound string = "*"
+const scanBoundEscape string = "\\"
+
+// rng <start> <end> <count>: keys from start (inclusive) up to end (exclusive), either of which
+// may be '*' for no bound...
+func handleRng(kvs *KvServer, message *commandMessage) string {
+	return scanPage(kvs, decodeScanBound(message.Key), decodeScanBound(message.Value), message.Extra)
+}
+
+// pfx <prefix> <start> <count>: keys with the prefix, from start (inclusive) or '*' for the first...
+func handlePfx(kvs *KvServer, message *commandMessage) string {
+	start := decodeScanBound(message.Value)
+	if start < message.Key {
+		start = message.Key
+	}
+	return scanPage(kvs, start, kvstore.PrefixEnd(message.Key), message.Extra)
+}
+
+// responds with 'kvs', a count, the cursor, then each key and value; the cursor is the
+// start to pass to get the next page, as a bound, and is empty once there are no more entries...
+func scanPage(kvs *KvServer, start string, end string, count string) string {
+	limit, err := strconv.Atoi(count)
+	if err != nil || limit <= 0 {
+		return "err"
+	}
+	if limit > KvServerMaxScanPage {
+		limit = KvServerMaxScanPage
+	}
+	scanning, ok := kvs.store.(kvstore.ScanningStore)
+	if !ok {
+		return "err"
+	}
+	entries, err := scanning.Scan(start, end, limit+1)
+	if err != nil {
+		return "err"
+	}
+	cursor := ""
+	if len(entries) > limit {
+		cursor = encodeScanBound(entries[limit].Key)
+		entries = entries[:limit]
+	}
+	values := make([]string, 0, 1+2*len(entries))
+	values = append(values, cursor)
+	for _, entry := range entries {
+		values = append(values, entry.Key, entry.Value)
+	}
+	if bytesToWrite, err := parsing.CreateMultiData("kvs", values); err == nil {
+		return string(bytesToWrite)
+	}
+	return "err"
+}
+
+// scn <cursor> <pattern> <count>: examines up to count keys from the cursor onwards, responding
+// with 'kys', a count, the next cursor, then the keys that matched the glob pattern. A page may
+// hold fewer keys than count, even none, while the cursor is not yet back to '0'...
+func handleScn(kvs *KvServer, message *commandMessage) string {
+	start, ok := decodeScanCursor(message.Key)
+	if !ok {
+		return "err"
+	}
+	limit, err := strconv.Atoi(message.Extra)
+	if err != nil || limit < 0 {
+		return "err"
+	}
+	if limit == 0 {
+		limit = KvServerDefaultScanPage
+	}
+	if limit > KvServerMaxScanPage {
+		limit = KvServerMaxScanPage
+	}
+	pattern := message.Value
+	prefix := globLiteralPrefix(pattern)
+	if start < prefix {
+		start = prefix
+	}
+	scanning, ok := kvs.store.(kvstore.ScanningStore)
+	if !ok {
+		return "err"
+	}
+	entries, err := scanning.Scan(start, kvstore.PrefixEnd(prefix), limit+1)
+	if err != nil {
+		return "err"
+	}
+	cursor := scanCursorStart
+	if len(entries) > limit {
+		cursor = encodeScanCursor(entries[limit].Key)
+		entries = entries[:limit]
+	}
+	values := []string{cursor}
+	for _, entry := range entries {
+		if matchGlob(pattern, entry.Key) {
+			values = append(values, entry.Key)
+		}
+	}
+	if bytesToWrite, err := parsing.CreateMultiData("kys", values); err == nil {
+		return string(bytesToWrite)
+	}
+	return "err"
+}
+
+// an empty key, for the store, is no bound...
+func decodeScanBound(bound string) string {
+	if bound == scanOpenBound {
+		return ""
+	}
+	return strings.TrimPrefix(bound, scanBoundEscape)
+}
+
+func encodeScanBound(key string) string {
+	if key == scanOpenBound || strings.HasPrefix(key, scanBoundEscape) {
+		return scanBoundEscape + key
+	}
+	return key
+}
+
+// cursors are the next key to examine, encoded so clients treat them as opaque...
+func encodeScanCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeScanCursor(cursor string) (key string, ok bool) {
+	if cursor == scanCursorStart {
+		return "", true
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(decoded) == 0 {
+		return "", false
+	}
+	return string(decoded), true
+}
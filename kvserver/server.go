@@ -1,6 +1,7 @@
 package kvserver
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"io"
@@ -8,41 +9,154 @@ import (
 	"kvsapp/parsing"
 	"net"
 	"os"
+	"sync"
 	"time"
 )
 
-const KvServerReadBufferSize int = 256
+// the most a connection reads at once; every message in a read is answered with one write...
+const KvServerReadBufferSize int = 4096
+
+// the largest key, value and message a client may send unless told otherwise; anything larger
+// is refused and the connection dropped, rather than held in memory while it arrives...
+const DefaultMaxKeyLength int = 64 * 1024
+const DefaultMaxValueLength int = 64 * 1024 * 1024
+const DefaultMaxFrameLength int = 128 * 1024 * 1024
+
+type KvServerOptions struct {
+	MaxKeyLength   int // bytes in the first argument of a message; zero for DefaultMaxKeyLength
+	MaxValueLength int // bytes in any other argument; zero for DefaultMaxValueLength
+	MaxFrameLength int // bytes in a whole message; zero for DefaultMaxFrameLength
+
+	RespPort int // tcp port for redis clients, speaking RESP2; zero for none
+	TextPort int // tcp port for commands typed a line at a time; zero for none
+	HttpPort int // tcp port for http clients, sending and receiving json; zero for none
+}
 
 type KvServer struct {
 	tcpport             int
 	udpport             int
+	respport            int
+	textport            int
+	httpport            int
+	limits              parsing.ParserOptions
 	udpBroadcastAddress string
 	udpListeningAddress string
-	store               *kvstore.KvStore
+	store               kvstore.Store
 	servers             *kvstore.KvStore
 	grammar             map[string]parsing.ParserGrammar
+	replies             map[string]parsing.ParserGrammar
 	shutdown            chan int
-	handlers            map[string]func(kvs *KvServer, key string, value string) string
+	handlers            map[string]commandHandler
+	broker              *kvBroker
 }
 
 type commandMessage struct {
 	Command string
 	Key     string
 	Value   string
+	Extra   string
+	Args    []string // every argument, for variadic commands; the first three are also above
+}
+
+func newCommandMessage(command string, args []string) *commandMessage {
+	message := &commandMessage{Command: command, Args: args}
+	for i, field := range []*string{&message.Key, &message.Value, &message.Extra} {
+		if i < len(args) {
+			*field = args[i]
+		}
+	}
+	return message
+}
+
+// per-connection state for a tcp client. writes go through Write, since changes the client is
+// listening for are pushed from other goroutines. a client may pipeline, sending several messages
+// without waiting for each reply: the replies to everything in one read are buffered and written
+// together once it has all been handled, in the order the messages arrived...
+type kvSession struct {
+	connection  io.Writer
+	writer      *bufio.Writer // buffers replies; nil to write each straight to the connection
+	batching    bool          // holding replies until the current read has been handled
+	parser      *parsing.Parser
+	transaction *kvTransaction
+	watched     map[string]uint64
+	writeLock   sync.Mutex
+	listenLock  sync.Mutex
+	listeners   map[string]*kvListener
+	subscriber  *kvSubscriber
+}
+
+func newKvSession(connection io.Writer, parser *parsing.Parser) *kvSession {
+	return &kvSession{connection: connection, writer: bufio.NewWriter(connection), parser: parser}
+}
+
+func (session *kvSession) Write(data []byte) (int, error) {
+	session.writeLock.Lock()
+	defer session.writeLock.Unlock()
+	if session.writer == nil {
+		return session.connection.Write(data)
+	}
+	written, err := session.writer.Write(data)
+	if err == nil && !session.batching {
+		err = session.writer.Flush()
+	}
+	return written, err
 }
 
-func NewKvServer(tcpport int, udpport int, store *kvstore.KvStore) (*KvServer, error) {
+func (session *kvSession) beginBatch() {
+	session.writeLock.Lock()
+	defer session.writeLock.Unlock()
+	session.batching = true
+}
+
+// writes out every reply held since beginBatch...
+func (session *kvSession) endBatch() error {
+	session.writeLock.Lock()
+	defer session.writeLock.Unlock()
+	session.batching = false
+	if session.writer == nil {
+		return nil
+	}
+	return session.writer.Flush()
+}
+
+func NewKvServer(tcpport int, udpport int, store kvstore.Store) (*KvServer, error) {
+	return NewKvServerWithOptions(tcpport, udpport, store, KvServerOptions{})
+}
+
+func NewKvServerWithOptions(tcpport int, udpport int, store kvstore.Store, options KvServerOptions) (*KvServer, error) {
 	if store == nil {
 		return nil, errors.New("parameter 'store' must not be nil")
 	}
+	if options.MaxKeyLength < 0 || options.MaxValueLength < 0 || options.MaxFrameLength < 0 || options.RespPort < 0 || options.TextPort < 0 || options.HttpPort < 0 {
+		return nil, errors.New("parameter 'options' must not have negative limits or ports")
+	}
+	if options.MaxKeyLength == 0 {
+		options.MaxKeyLength = DefaultMaxKeyLength
+	}
+	if options.MaxValueLength == 0 {
+		options.MaxValueLength = DefaultMaxValueLength
+	}
+	if options.MaxFrameLength == 0 {
+		options.MaxFrameLength = DefaultMaxFrameLength
+	}
 	return &KvServer{
 		tcpport:  tcpport,
 		udpport:  udpport,
+		respport: options.RespPort,
+		textport: options.TextPort,
+		httpport: options.HttpPort,
+		limits: parsing.ParserOptions{
+			MaxKeyLength:   options.MaxKeyLength,
+			MaxValueLength: options.MaxValueLength,
+			MaxFrameLength: options.MaxFrameLength,
+		},
 		store:    store,
 		servers:  kvstore.NewKvStore(),
 		grammar:  getStandardGrammar(),
+		replies:  parsing.ReplyGrammar(),
 		shutdown: make(chan int),
 		handlers: getHandlers(),
+		broker:   newKvBroker(),
 	}, nil
 }
 
@@ -58,7 +172,37 @@ func (kvs *KvServer) Open() error {
 
 	tcpAddress := listener.Addr().String()
 
+	if kvs.respport > 0 {
+		respListener, err := net.Listen("tcp4", fmt.Sprintf(":%d", kvs.respport))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("server-resp: listening on port %d\n", kvs.respport)
+		go kvs.handleRespAcceptance(respListener)
+	}
+
+	if kvs.textport > 0 {
+		textListener, err := net.Listen("tcp4", fmt.Sprintf(":%d", kvs.textport))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("server-text: listening on port %d\n", kvs.textport)
+		go kvs.handleTextAcceptance(textListener)
+	}
+
+	if kvs.httpport > 0 {
+		httpListener, err := net.Listen("tcp4", fmt.Sprintf(":%d", kvs.httpport))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("server-http: listening on port %d\n", kvs.httpport)
+		go kvs.handleHttpAcceptance(httpListener)
+	}
+
 	go kvs.handleInternalChecking()
+	if expiring, ok := kvs.store.(kvstore.ExpiringStore); ok {
+		go kvs.handleStoreExpirations(expiring)
+	}
 	go kvs.handleUdpListener(getServerHostKey())
 	go kvs.handleUdpBroadcast(getServerHostKey(), tcpAddress)
 
@@ -70,12 +214,17 @@ func getServerHostKey() string {
 	return fmt.Sprintf("[%s:%d:%d]", hostname, os.Getppid(), os.Getpid())
 }
 
-func (kvs *KvServer) sendToAllOthers(command string, arg1 string, arg2 string) {
+func (kvs *KvServer) sendToAllOthers(command string, args ...string) {
+	data, _ := parsing.CreateData(command, args...)
+	kvs.sendDataToAllOthers(command, data)
+}
+
+// like sendToAllOthers, for a message that is already encoded, such as a variadic one...
+func (kvs *KvServer) sendDataToAllOthers(command string, data []byte) {
 	for _, serverKey := range kvs.servers.ListKeys() {
 		fault := true
 		if serverAddress, err := kvs.servers.Get(serverKey); err == nil {
 			if connection, err := net.Dial("tcp4", serverAddress); err == nil {
-				data, _ := parsing.CreateData(command, arg1, arg2)
 				connection.SetDeadline(time.Now().Add(800 * time.Millisecond))
 				if written, err := connection.Write(data); written > 0 && err == nil {
 					readBuffer := make([]byte, 16)
@@ -102,6 +251,23 @@ func (kvs *KvServer) handleInternalChecking() {
 	}
 }
 
+// replicates keys removed by expiry to the rest of the cluster, with the version each was removed
+// at; a key that has been written again since is left to the write's own replication...
+func (kvs *KvServer) handleStoreExpirations(store kvstore.ExpiringStore) {
+	versioned, _ := kvs.store.(kvstore.VersionedStore)
+	for key := range store.Expired() {
+		fmt.Printf("server: key '%s' expired\n", key)
+		version := uint64(0)
+		if versioned != nil {
+			var err error
+			if _, version, err = versioned.GetWithVersion(key); !errors.Is(err, kvstore.ErrKeyNotFound) {
+				continue
+			}
+		}
+		kvs.replicateDelete(key, version)
+	}
+}
+
 func (kvs *KvServer) Close() {
 
 }
@@ -132,7 +298,10 @@ func (kvs *KvServer) handleTcpAcceptance(listener net.Listener) {
 func (kvs *KvServer) handleTcpConnection(connection io.ReadWriteCloser) {
 	defer func() { _ = connection.Close() }()
 
-	parser, _ := parsing.NewParser(kvs.grammar)
+	parser, _ := parsing.NewParserWithOptions(kvs.grammar, kvs.limits)
+	session := newKvSession(connection, parser)
+	defer session.stopListeningToAll()
+	defer kvs.broker.unsubscribeAll(session)
 
 	buffer := make([]byte, KvServerReadBufferSize)
 	for {
@@ -143,16 +312,24 @@ func (kvs *KvServer) handleTcpConnection(connection io.ReadWriteCloser) {
 		if count == 0 {
 			continue
 		}
-		cont, err := kvs.handleReceivedBytes(connection, parser, buffer[:count])
+		cont, err := kvs.handleReceivedBytes(session, buffer[:count])
 		if !cont || err != nil {
 			return
 		}
 	}
 }
 
-func (kvs *KvServer) handleReceivedBytes(connection io.Writer, parser *parsing.Parser, values []byte) (carryOn bool, e error) {
-	for _, value := range values {
-		cont, err := kvs.handleReceivedByte(connection, parser, value)
+func (kvs *KvServer) handleReceivedBytes(session *kvSession, values []byte) (carryOn bool, e error) {
+	session.beginBatch()
+	defer func() {
+		if err := session.endBatch(); err != nil && e == nil {
+			carryOn, e = false, err
+		}
+	}()
+	for len(values) > 0 {
+		consumed, found, err := session.parser.ProcessBytes(values)
+		values = values[consumed:]
+		cont, err := kvs.handleProcessed(session, found, err)
 		if !cont || err != nil {
 			return false, err
 		}
@@ -160,20 +337,31 @@ func (kvs *KvServer) handleReceivedBytes(connection io.Writer, parser *parsing.P
 	return true, nil
 }
 
-func (kvs *KvServer) handleReceivedByte(connection io.Writer, parser *parsing.Parser, value byte) (carryOn bool, e error) {
-	found, err := parser.Process(string(value))
+func (kvs *KvServer) handleReceivedByte(session *kvSession, value byte) (carryOn bool, e error) {
+	_, found, err := session.parser.ProcessBytes([]byte{value})
+	return kvs.handleProcessed(session, found, err)
+}
+
+// answers what the parser made of the bytes it was last given: a message, an error, or neither...
+func (kvs *KvServer) handleProcessed(session *kvSession, found bool, err error) (carryOn bool, e error) {
+	if errors.Is(err, parsing.ErrParserTooLarge) {
+		// the rest of the message is still to come, so there's no finding the next one...
+		fmt.Printf("server-tcp: closing connection after an oversized message\n")
+		_, _ = writeErr(session)
+		return false, nil
+	}
 	if err != nil {
-		_, err := writeErr(connection)
+		_, err := writeErr(session)
 		if err != nil {
 			return false, err
 		}
 	}
 	if found {
-		cmd, arg1, arg2, err := parser.GetMessage()
+		cmd, args, err := session.parser.GetMessage()
 		if err != nil {
 			panic("server-tcp: something really vile has happened")
 		}
-		if !kvs.handleMessage(connection, &commandMessage{Command: cmd, Key: arg1, Value: arg2}) {
+		if !kvs.handleSessionMessage(session, newCommandMessage(cmd, args)) {
 			return false, nil
 		}
 	}
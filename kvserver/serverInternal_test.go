@@ -5,14 +5,17 @@ import (
 	"kvsapp/assertions"
 	"kvsapp/kvstore"
 	"kvsapp/parsing"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 func createTestObject() *KvServer {
 	store := kvstore.NewKvStore()
 	store.Open()
 	result, _ := NewKvServer(0, 0, store)
+	result.servers.Open()
 	return result
 }
 
@@ -25,10 +28,11 @@ type handleReceivedByteTestData struct {
 
 func createHandleReceivedByteTestData() map[string]handleReceivedByteTestData {
 	return map[string]handleReceivedByteTestData{
-		"1 byte":           {bytes: "x", expectedCarryOn: true, expectedError: nil, expectedWrite: ""},
-		"2 byte":           {bytes: "xy", expectedCarryOn: true, expectedError: nil, expectedWrite: ""},
-		"unknown command":  {bytes: "xyz", expectedCarryOn: true, expectedError: nil, expectedWrite: "err"},
-		"zero-arg command": {bytes: "bye", expectedCarryOn: false, expectedError: nil, expectedWrite: ""},
+		"1 byte":            {bytes: "x", expectedCarryOn: true, expectedError: nil, expectedWrite: ""},
+		"2 byte":            {bytes: "xy", expectedCarryOn: true, expectedError: nil, expectedWrite: ""},
+		"unknown command":   {bytes: "xyz", expectedCarryOn: true, expectedError: nil, expectedWrite: "err"},
+		"zero-arg command":  {bytes: "bye", expectedCarryOn: false, expectedError: nil, expectedWrite: ""},
+		"three-arg command": {bytes: "cas13key11a11b", expectedCarryOn: true, expectedError: nil, expectedWrite: "nil"},
 	}
 }
 func TestHandleReceivedByte(t *testing.T) {
@@ -47,13 +51,15 @@ func TestHandleReceivedByte(t *testing.T) {
 				"del": {ExpectedArguments: 1},
 				"put": {ExpectedArguments: 2},
 				"hed": {ExpectedArguments: 2},
+				"cas": {ExpectedArguments: 3},
 			})
 			buffer := &bytes.Buffer{}
+			session := &kvSession{connection: buffer, parser: parser}
 
 			var carryOn bool
 			var err error
 			for _, b := range testData.bytes {
-				carryOn, err = testObject.handleReceivedByte(buffer, parser, byte(b))
+				carryOn, err = testObject.handleReceivedByte(session, byte(b))
 			}
 			assert.TestError(testName, testData.expectedError, err)
 			assert.TestBoolean(testName, "carryOn", testData.expectedCarryOn, carryOn)
@@ -62,3 +68,78 @@ func TestHandleReceivedByte(t *testing.T) {
 	}
 	wait.Wait()
 }
+
+func TestLargeValueIsReadInBulk(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+	value := strings.Repeat("v", 16*1024*1024)
+	data, _ := parsing.CreateData("put", "key", value)
+	started := time.Now()
+
+	parser, _ := parsing.NewParserWithOptions(testObject.grammar, testObject.limits)
+	buffer := &bytes.Buffer{}
+	session := &kvSession{connection: buffer, parser: parser}
+	for offset := 0; offset < len(data); offset += KvServerReadBufferSize {
+		end := offset + KvServerReadBufferSize
+		if end > len(data) {
+			end = len(data)
+		}
+		carryOn, err := testObject.handleReceivedBytes(session, data[offset:end])
+		assert.Error(nil, err)
+		assert.True("carryOn", carryOn)
+	}
+	assert.True("written", strings.HasPrefix(buffer.String(), "vsn"))
+	stored, _ := testObject.store.Get("key")
+	assert.True("value", stored == value)
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Errorf("param: elapsed, expected: under 10s, actual: %s", elapsed)
+	}
+}
+
+func TestOversizedMessageClosesConnection(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := kvstore.NewKvStore()
+	store.Open()
+	testObject, _ := NewKvServerWithOptions(0, 0, store, KvServerOptions{MaxKeyLength: 3, MaxValueLength: 5})
+	testObject.servers.Open()
+
+	for _, testCase := range []struct {
+		bytes           string
+		expectedCarryOn bool
+		expectedWrite   string
+	}{
+		{bytes: "put13key15value", expectedCarryOn: true, expectedWrite: "vsn"},
+		{bytes: "put14keys15value", expectedCarryOn: false, expectedWrite: "err"},
+		{bytes: "put13key16values", expectedCarryOn: false, expectedWrite: "err"},
+		{bytes: "put9999999999", expectedCarryOn: false, expectedWrite: "err"},
+	} {
+		parser, _ := parsing.NewParserWithOptions(testObject.grammar, testObject.limits)
+		buffer := &bytes.Buffer{}
+		session := &kvSession{connection: buffer, parser: parser}
+		carryOn, err := testObject.handleReceivedBytes(session, []byte(testCase.bytes))
+		assert.TestError(testCase.bytes, nil, err)
+		assert.TestBoolean(testCase.bytes, "carryOn", testCase.expectedCarryOn, carryOn)
+		written := buffer.String()
+		if len(written) > 3 {
+			written = written[:3]
+		}
+		assert.TestString(testCase.bytes, "written", testCase.expectedWrite, written)
+	}
+}
+
+func TestNewKvServerWithOptions(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject, err := NewKvServerWithOptions(0, 0, kvstore.NewKvStore(), KvServerOptions{MaxValueLength: 10})
+	assert.Error(nil, err)
+	assert.Int("MaxKeyLength", DefaultMaxKeyLength, testObject.limits.MaxKeyLength)
+	assert.Int("MaxValueLength", 10, testObject.limits.MaxValueLength)
+	assert.Int("MaxFrameLength", DefaultMaxFrameLength, testObject.limits.MaxFrameLength)
+
+	_, err = NewKvServerWithOptions(0, 0, kvstore.NewKvStore(), KvServerOptions{MaxFrameLength: -1})
+	if err == nil {
+		t.Error("expected: error, actual: nil")
+	}
+}
@@ -52,7 +52,7 @@ func (kvs *KvServer) handleUdpListener(hostKey string) {
 		// create a new parser for the message...
 		msg := string(buffer[0:readCount])
 		fmt.Println("cluster: incoming broadcast message")
-		parser, err := parsing.NewParser(getStandardGrammar())
+		parser, err := parsing.NewParserWithOptions(getStandardGrammar(), kvs.limits)
 		if err != nil {
 			continue
 		}
@@ -60,7 +60,7 @@ func (kvs *KvServer) handleUdpListener(hostKey string) {
 		// attempt to parse the message...
 		found := false
 		for i := 0; i < readCount; i++ {
-			found, err = parser.Process(string(msg[i]))
+			found, err = parser.Process(msg[i : i+1])
 			if found && err == nil {
 				break
 			}
@@ -74,20 +74,21 @@ func (kvs *KvServer) handleUdpListener(hostKey string) {
 		}
 
 		// obtain the message object...
-		cmd, arg1, arg2, err := parser.GetMessage()
-		//fmt.Printf("cluster: GetMessage() cmd: %s, arg1: %s, arg2: %s, err: %v\n", cmd, arg1, arg2, err)
+		cmd, args, err := parser.GetMessage()
+		//fmt.Printf("cluster: GetMessage() cmd: %s, args: %v, err: %v\n", cmd, args, err)
 		if err != nil {
 			continue
 		}
+		message := newCommandMessage(cmd, args)
 
 		// remove messages from ourselves...
-		if arg1 == hostKey {
+		if message.Key == hostKey {
 			fmt.Printf("cluster: skipping message because it's from us!\n")
 			continue
 		}
 
 		// process the message via the standard message handler...
-		_ = kvs.handleMessage(nil, &commandMessage{Command: cmd, Key: arg1, Value: arg2})
+		_ = kvs.handleMessage(nil, message)
 
 	}
 }
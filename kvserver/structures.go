@@ -0,0 +1,300 @@
+package kvserver
+
+import (
+	"errors"
+	"fmt"
+	"kvsapp/kvstore"
+	"kvsapp/parsing"
+	"strconv"
+)
+
+// hashes, lists and sets. a command against a key holding another kind of value answers 'wty'.
+// after each change the whole hash, list or set is sent to the rest of the cluster with 'sty'
+// (key, a frame naming the kind with its elements, version), or 'sdl' once it is empty, so that
+// a peer that missed a change still ends up with the same elements...
+
+// the frame each kind is answered and replicated in...
+var structureFrames = map[kvstore.ValueKind]string{
+	kvstore.KindHash: "hsh",
+	kvstore.KindList: "lst",
+	kvstore.KindSet:  "set",
+}
+
+func structuredStore(kvs *KvServer) (kvstore.StructuredStore, bool) {
+	structured, ok := kvs.store.(kvstore.StructuredStore)
+	return structured, ok
+}
+
+// maps a failed structure command onto 'wty' (wrong kind of value), 'nil' (missing), 'oom' or 'err'...
+func structureErrorResponse(err error) string {
+	switch {
+	case errors.Is(err, kvstore.ErrWrongType):
+		return "wty"
+	case errors.Is(err, kvstore.ErrKeyNotFound):
+		return "nil"
+	case errors.Is(err, kvstore.ErrOutOfMemory):
+		return "oom"
+	}
+	return "err"
+}
+
+// answers 'val' with value; an empty one is sent with a zero length, where CreateData would drop
+// it, so that a client reading a stream of replies can still tell where the next one starts...
+func valueResponse(value string) string {
+	if value == "" {
+		return "val10"
+	}
+	if bytesToWrite, err := parsing.CreateData("val", value); err == nil {
+		return string(bytesToWrite)
+	}
+	return "err"
+}
+
+// answers 'val' with 1 if the command changed anything and 0 if not...
+func changedResponse(changed bool) string {
+	if changed {
+		return valueResponse("1")
+	}
+	return valueResponse("0")
+}
+
+func multiResponse(command string, values []string) string {
+	if bytesToWrite, err := parsing.CreateMultiData(command, values); err == nil {
+		return string(bytesToWrite)
+	}
+	return "err"
+}
+
+// sends the whole of key to the rest of the cluster, or deletes it there once it is empty...
+func (kvs *KvServer) replicateStructure(structured kvstore.StructuredStore, key string) {
+	kind, elements, version, err := structured.GetStructure(key)
+	if errors.Is(err, kvstore.ErrKeyNotFound) {
+		kvs.replicateDelete(key, version)
+		return
+	}
+	frameCommand, isStructure := structureFrames[kind]
+	if err != nil || !isStructure {
+		return
+	}
+	if frame, err := parsing.CreateMultiData(frameCommand, elements); err == nil {
+		kvs.sendToAllOthers("sty", key, string(frame), strconv.FormatUint(version, 10))
+	}
+}
+
+// hse <key> <field> <value>: answers 1 if the field is new...
+func handleHse(kvs *KvServer, message *commandMessage) string {
+	structured, ok := structuredStore(kvs)
+	if !ok {
+		return "err"
+	}
+	created, _, err := structured.HashSet(message.Key, message.Value, message.Extra)
+	if err != nil {
+		return structureErrorResponse(err)
+	}
+	kvs.replicateStructure(structured, message.Key)
+	return changedResponse(created)
+}
+
+// hge <key> <field>
+func handleHge(kvs *KvServer, message *commandMessage) string {
+	structured, ok := structuredStore(kvs)
+	if !ok {
+		return "err"
+	}
+	value, err := structured.HashGet(message.Key, message.Value)
+	if err != nil {
+		return structureErrorResponse(err)
+	}
+	return valueResponse(value)
+}
+
+// hde <key> <field>: answers 1 if the field was there...
+func handleHde(kvs *KvServer, message *commandMessage) string {
+	structured, ok := structuredStore(kvs)
+	if !ok {
+		return "err"
+	}
+	deleted, _, err := structured.HashDelete(message.Key, message.Value)
+	if err != nil {
+		return structureErrorResponse(err)
+	}
+	if deleted {
+		kvs.replicateStructure(structured, message.Key)
+	}
+	return changedResponse(deleted)
+}
+
+// hga <key>: answers 'hsh' with each field and value, in order of field...
+func handleHga(kvs *KvServer, message *commandMessage) string {
+	structured, ok := structuredStore(kvs)
+	if !ok {
+		return "err"
+	}
+	entries, err := structured.HashGetAll(message.Key)
+	if err != nil {
+		return structureErrorResponse(err)
+	}
+	values := make([]string, 0, 2*len(entries))
+	for _, entry := range entries {
+		values = append(values, entry.Key, entry.Value)
+	}
+	return multiResponse("hsh", values)
+}
+
+// lpu <key> <value>: pushes onto the front, answering with the new length...
+func handleLpu(kvs *KvServer, message *commandMessage) string {
+	return listPush(kvs, message.Key, message.Value, true)
+}
+
+// rpu <key> <value>: pushes onto the back, answering with the new length...
+func handleRpu(kvs *KvServer, message *commandMessage) string {
+	return listPush(kvs, message.Key, message.Value, false)
+}
+
+func listPush(kvs *KvServer, key string, value string, front bool) string {
+	structured, ok := structuredStore(kvs)
+	if !ok {
+		return "err"
+	}
+	length, _, err := structured.ListPush(key, value, front)
+	if err != nil {
+		return structureErrorResponse(err)
+	}
+	kvs.replicateStructure(structured, key)
+	return valueResponse(strconv.Itoa(length))
+}
+
+// lpo <key>: pops from the front...
+func handleLpo(kvs *KvServer, message *commandMessage) string {
+	return listPop(kvs, message.Key, true)
+}
+
+// rpo <key>: pops from the back...
+func handleRpo(kvs *KvServer, message *commandMessage) string {
+	return listPop(kvs, message.Key, false)
+}
+
+func listPop(kvs *KvServer, key string, front bool) string {
+	structured, ok := structuredStore(kvs)
+	if !ok {
+		return "err"
+	}
+	value, _, err := structured.ListPop(key, front)
+	if err != nil {
+		return structureErrorResponse(err)
+	}
+	kvs.replicateStructure(structured, key)
+	return valueResponse(value)
+}
+
+// lrg <key> <start> <stop>: answers 'lst' with the values from start to stop, both inclusive,
+// where negative positions count back from the end...
+func handleLrg(kvs *KvServer, message *commandMessage) string {
+	structured, ok := structuredStore(kvs)
+	if !ok {
+		return "err"
+	}
+	start, err := strconv.Atoi(message.Value)
+	if err != nil {
+		return "err"
+	}
+	stop, err := strconv.Atoi(message.Extra)
+	if err != nil {
+		return "err"
+	}
+	values, err := structured.ListRange(message.Key, start, stop)
+	if err != nil {
+		return structureErrorResponse(err)
+	}
+	return multiResponse("lst", values)
+}
+
+// sad <key> <member>: answers 1 if the member is new...
+func handleSad(kvs *KvServer, message *commandMessage) string {
+	structured, ok := structuredStore(kvs)
+	if !ok {
+		return "err"
+	}
+	added, _, err := structured.SetAdd(message.Key, message.Value)
+	if err != nil {
+		return structureErrorResponse(err)
+	}
+	if added {
+		kvs.replicateStructure(structured, message.Key)
+	}
+	return changedResponse(added)
+}
+
+// srm <key> <member>: answers 1 if the member was there...
+func handleSrm(kvs *KvServer, message *commandMessage) string {
+	structured, ok := structuredStore(kvs)
+	if !ok {
+		return "err"
+	}
+	removed, _, err := structured.SetRemove(message.Key, message.Value)
+	if err != nil {
+		return structureErrorResponse(err)
+	}
+	if removed {
+		kvs.replicateStructure(structured, message.Key)
+	}
+	return changedResponse(removed)
+}
+
+// smb <key>: answers 'set' with the members, in order...
+func handleSmb(kvs *KvServer, message *commandMessage) string {
+	structured, ok := structuredStore(kvs)
+	if !ok {
+		return "err"
+	}
+	members, err := structured.SetMembers(message.Key)
+	if err != nil {
+		return structureErrorResponse(err)
+	}
+	return multiResponse("set", members)
+}
+
+// sim <key> <member>: answers 1 if member is in the set...
+func handleSim(kvs *KvServer, message *commandMessage) string {
+	structured, ok := structuredStore(kvs)
+	if !ok {
+		return "err"
+	}
+	isMember, err := structured.SetIsMember(message.Key, message.Value)
+	if err != nil {
+		return structureErrorResponse(err)
+	}
+	return changedResponse(isMember)
+}
+
+// applies a hash, list or set replicated from another server, answering 'stl' if the key
+// already holds a newer version...
+func handleSty(kvs *KvServer, message *commandMessage) string {
+	structured, ok := structuredStore(kvs)
+	if !ok {
+		return "err"
+	}
+	frameCommand, elements, err := parsing.ParseMultiData([]byte(message.Value))
+	if err != nil {
+		return "err"
+	}
+	version, err := strconv.ParseUint(message.Extra, 10, 64)
+	if err != nil {
+		return "err"
+	}
+	for kind, command := range structureFrames {
+		if command != frameCommand {
+			continue
+		}
+		_, err = structured.UpsertStructureVersion(message.Key, kind, elements, version)
+		if errors.Is(err, kvstore.ErrStaleVersion) {
+			fmt.Printf("server: ignoring stale write of key '%s'\n", message.Key)
+			return "stl"
+		}
+		if err != nil {
+			return "err"
+		}
+		return "ack"
+	}
+	return "err"
+}
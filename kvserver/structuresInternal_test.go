@@ -0,0 +1,85 @@
+package kvserver
+
+import (
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"strings"
+	"testing"
+)
+
+func TestHandleHashCommands(t *testing.T) {
+	t.Parallel()
+	runHandleMessageSteps(t, createTestObject(), []handleMessageTestStep{
+		{command: "hge", key: "user", value: "name", expectedWrite: "nil"},
+		{command: "hga", key: "user", expectedWrite: "hsh10"},
+		{command: "hse", key: "user", value: "name", extra: "ann", expectedWrite: "val111"},
+		{command: "hse", key: "user", value: "name", extra: "bob", expectedWrite: "val110"},
+		{command: "hse", key: "user", value: "age", extra: "42", expectedWrite: "val111"},
+		{command: "hge", key: "user", value: "name", expectedWrite: "val13bob"},
+		{command: "hge", key: "user", value: "email", expectedWrite: "nil"},
+		{command: "hga", key: "user", expectedWrite: "hsh1413age124214name13bob"},
+		{command: "hde", key: "user", value: "age", expectedWrite: "val111"},
+		{command: "hde", key: "user", value: "age", expectedWrite: "val110"},
+		{command: "get", key: "user", expectedWrite: "wty"},
+		{command: "gtv", key: "user", expectedWrite: "wty"},
+		{command: "inc", key: "user", expectedWrite: "wty"},
+		{command: "cas", key: "user", value: "a", extra: "b", expectedWrite: "wty"},
+	})
+}
+
+func TestHandleListCommands(t *testing.T) {
+	t.Parallel()
+	runHandleMessageSteps(t, createTestObject(), []handleMessageTestStep{
+		{command: "lpo", key: "queue", expectedWrite: "nil"},
+		{command: "rpu", key: "queue", value: "b", expectedWrite: "val111"},
+		{command: "rpu", key: "queue", value: "c", expectedWrite: "val112"},
+		{command: "lpu", key: "queue", value: "a", expectedWrite: "val113"},
+		{command: "lrg", key: "queue", value: "0", extra: "-1", expectedWrite: "lst1311a11b11c"},
+		{command: "lrg", key: "queue", value: "-2", extra: "99", expectedWrite: "lst1211b11c"},
+		{command: "lrg", key: "queue", value: "x", extra: "1", expectedWrite: "err"},
+		{command: "lpo", key: "queue", expectedWrite: "val11a"},
+		{command: "rpo", key: "queue", expectedWrite: "val11c"},
+		{command: "rpo", key: "queue", expectedWrite: "val11b"},
+		{command: "rpo", key: "queue", expectedWrite: "nil"},
+		{command: "put", key: "text", value: "abc", expectedWrite: "vsn*"},
+		{command: "rpu", key: "text", value: "a", expectedWrite: "wty"},
+		{command: "lrg", key: "text", value: "0", extra: "-1", expectedWrite: "wty"},
+	})
+}
+
+func TestHandleSetCommands(t *testing.T) {
+	t.Parallel()
+	runHandleMessageSteps(t, createTestObject(), []handleMessageTestStep{
+		{command: "sad", key: "tags", value: "red", expectedWrite: "val111"},
+		{command: "sad", key: "tags", value: "red", expectedWrite: "val110"},
+		{command: "sad", key: "tags", value: "blue", expectedWrite: "val111"},
+		{command: "smb", key: "tags", expectedWrite: "set1214blue13red"},
+		{command: "sim", key: "tags", value: "red", expectedWrite: "val111"},
+		{command: "sim", key: "tags", value: "green", expectedWrite: "val110"},
+		{command: "srm", key: "tags", value: "red", expectedWrite: "val111"},
+		{command: "srm", key: "tags", value: "red", expectedWrite: "val110"},
+		{command: "hse", key: "tags", value: "f", extra: "v", expectedWrite: "wty"},
+	})
+}
+
+func TestHandleReplicatedStructures(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+	runHandleMessageSteps(t, testObject, []handleMessageTestStep{
+		{command: "sty", key: "user", value: "hsh1214name13ann", extra: "4611686018427387904", expectedWrite: "ack"},
+		{command: "sty", key: "user", value: "hsh1214name13old", extra: "4611686018427387903", expectedWrite: "stl"},
+		{command: "sty", key: "user", value: "hsh1114name", extra: "4611686018427387905", expectedWrite: "err"},
+		{command: "sty", key: "user", value: "xyz10", extra: "4611686018427387905", expectedWrite: "err"},
+		{command: "sty", key: "user", value: "garbage", extra: "4611686018427387905", expectedWrite: "err"},
+		{command: "hge", key: "user", value: "name", expectedWrite: "val13ann"},
+		{command: "sty", key: "queue", value: "lst1211a11b", extra: "4611686018427387904", expectedWrite: "ack"},
+		{command: "lrg", key: "queue", value: "0", extra: "-1", expectedWrite: "lst1211a11b"},
+		{command: "sty", key: "queue", value: "set1211a11b", extra: "4611686018427387905", expectedWrite: "ack"},
+		{command: "sim", key: "queue", value: "b", expectedWrite: "val111"},
+	})
+	structured := testObject.store.(kvstore.StructuredStore)
+	_, elements, version, _ := structured.GetStructure("queue")
+	assert.String("elements", "a,b", strings.Join(elements, ","))
+	assert.Uint64("version", 4611686018427387905, version)
+}
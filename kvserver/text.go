@@ -0,0 +1,181 @@
+package kvserver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"kvsapp/parsing"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// a text listener takes one command per line, such as 'put greeting "hello world"', for typing
+// at with nc or telnet: the command then its arguments, separated by whitespace, any of which can
+// be double-quoted (with Go's escapes, such as \n, \" and \x00) to hold spaces or unprintable
+// bytes. each message goes through the same handlers as the binary protocol, and each reply frame
+// is written back as a line of its kind then its arguments, quoted where they need it.
+
+// the longest line the text listener will read...
+const KvServerTextLineLength int = 64 * 1024
+
+var errTextBadQuotes = errors.New("bad quotes")
+
+func (kvs *KvServer) handleTextAcceptance(listener net.Listener) {
+	for {
+		connection, err := listener.Accept()
+		if err != nil {
+			fmt.Printf("server-text: error accepting connection: %s\n", err.Error())
+			continue
+		}
+		go kvs.handleTextConnection(connection)
+	}
+}
+
+func (kvs *KvServer) handleTextConnection(connection io.ReadWriteCloser) {
+	defer func() { _ = connection.Close() }()
+
+	writer := &kvTextWriter{connection: connection, replies: kvs.replies}
+	session := &kvSession{connection: writer}
+	defer session.stopListeningToAll()
+	defer kvs.broker.unsubscribeAll(session)
+
+	reader := bufio.NewReaderSize(connection, KvServerTextLineLength)
+	for {
+		line, err := reader.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			fmt.Printf("server-text: closing connection after an oversized line\n")
+			writeTextError(session, writer, parsing.ErrParserTooLarge)
+			return
+		}
+		if err != nil {
+			return
+		}
+		args, err := splitTextCommand(string(line))
+		if err != nil {
+			writeTextError(session, writer, err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		message, err := kvs.textMessage(strings.ToLower(args[0]), args[1:])
+		if err != nil {
+			writeTextError(session, writer, err)
+			continue
+		}
+		if !kvs.handleSessionMessage(session, message) {
+			return
+		}
+	}
+}
+
+// explains a line that couldn't be run, which our own protocol would only answer with 'err'...
+func writeTextError(session *kvSession, writer *kvTextWriter, err error) {
+	session.writeLock.Lock()
+	defer session.writeLock.Unlock()
+	_, _ = io.WriteString(writer.connection, "err "+textQuote(err.Error())+"\n")
+}
+
+// checks a command's arguments against the grammar the binary protocol would parse it with...
+func (kvs *KvServer) textMessage(command string, args []string) (*commandMessage, error) {
+	grammar, exists := kvs.grammar[command]
+	if !exists {
+		return nil, parsing.ErrParserUnknownCommand
+	}
+	if !grammar.Variadic && len(args) != int(grammar.ExpectedArguments) {
+		return nil, fmt.Errorf("'%s' takes %d arguments", command, grammar.ExpectedArguments)
+	}
+	for i, arg := range args {
+		if arg == "" && !grammar.Variadic {
+			return nil, parsing.ErrParserBadFormat
+		}
+		limit := kvs.limits.MaxValueLength
+		if i == 0 {
+			limit = kvs.limits.MaxKeyLength
+		}
+		if limit > 0 && len(arg) > limit {
+			return nil, parsing.ErrParserTooLarge
+		}
+	}
+	return newCommandMessage(command, args), nil
+}
+
+// splits a line into whitespace-separated words, any of which may be a double-quoted string...
+func splitTextCommand(line string) ([]string, error) {
+	var args []string
+	rest := strings.TrimLeftFunc(line, unicode.IsSpace)
+	for rest != "" {
+		if rest[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, errTextBadQuotes
+			}
+			arg, _ := strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+			if rest != "" && !unicode.IsSpace(rune(rest[0])) {
+				return nil, errTextBadQuotes
+			}
+			args = append(args, arg)
+		} else {
+			end := strings.IndexFunc(rest, unicode.IsSpace)
+			if end < 0 {
+				end = len(rest)
+			}
+			if strings.ContainsRune(rest[:end], '"') {
+				return nil, errTextBadQuotes
+			}
+			args = append(args, rest[:end])
+			rest = rest[end:]
+		}
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+	}
+	return args, nil
+}
+
+// writes each reply frame it is given as a line of text. every write holds whole frames, as
+// replies and the changes pushed to listeners are each written at once...
+type kvTextWriter struct {
+	connection io.Writer
+	replies    map[string]parsing.ParserGrammar
+}
+
+func (writer *kvTextWriter) Write(data []byte) (int, error) {
+	decoder, _ := parsing.NewDecoder(bytes.NewReader(data), writer.replies)
+	var text strings.Builder
+	for {
+		kind, args, err := decoder.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// not something we know how to read, so show it as it is...
+			text.Reset()
+			text.WriteString(textQuote(string(data)) + "\n")
+			break
+		}
+		text.WriteString(kind)
+		for _, arg := range args {
+			text.WriteString(" " + textQuote(arg))
+		}
+		text.WriteString("\n")
+	}
+	if _, err := io.WriteString(writer.connection, text.String()); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// quotes arg if it would otherwise be misread: if it is empty, or holds spaces, quotes or bytes
+// that aren't printable...
+func textQuote(arg string) string {
+	if arg == "" || strings.IndexFunc(arg, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '"' || !unicode.IsPrint(r)
+	}) >= 0 {
+		return strconv.Quote(arg)
+	}
+	return arg
+}
@@ -0,0 +1,134 @@
+package kvserver
+
+import (
+	"bufio"
+	"io"
+	"kvsapp/assertions"
+	"net"
+	"strings"
+	"testing"
+)
+
+// a person's end of a connection to the text listener...
+func createTextConnection(testObject *KvServer) (net.Conn, *bufio.Reader) {
+	clientSide, serverSide := net.Pipe()
+	go testObject.handleTextConnection(serverSide)
+	return clientSide, bufio.NewReader(clientSide)
+}
+
+func sendText(t *testing.T, connection net.Conn, reader *bufio.Reader, request string, expected string) {
+	t.Helper()
+	go func() { _, _ = connection.Write([]byte(request)) }()
+	actual, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("param: %q, expected: %q, actual: %q (%v)", request, expected, actual, err)
+	}
+	// as with the handler tests, a trailing '*' stands for whatever the rest of the line is...
+	if prefix := strings.TrimSuffix(expected, "*"); prefix != expected && strings.HasPrefix(actual, prefix) {
+		return
+	}
+	assertions.NewAssert(t).TestString(request, "reply", expected, actual)
+}
+
+func TestTextCommands(t *testing.T) {
+	t.Parallel()
+	testObject := createTestObject()
+	connection, reader := createTextConnection(testObject)
+	defer connection.Close()
+
+	for _, step := range []struct {
+		request  string
+		expected string
+	}{
+		{"get a\n", "nil\n"},
+		{"put a 1\n", "vsn *"},
+		{"  PUT   b   \"hello world\"  \r\n", "vsn *"},
+		{"get b\n", "val \"hello world\"\n"},
+		{"put c \"\\x00\\xff\\t\\\"\"\n", "vsn *"},
+		{"get c\n", "val \"\\x00\\xff\\t\\\"\"\n"},
+		{"mgt a x b\n", "kvs a 1 b \"hello world\"\n"},
+		{"get\n", "err \"'get' takes 1 arguments\"\n"},
+		{"put a \"\"\n", "err \"bad format\"\n"},
+		{"put a \"open\n", "err \"bad quotes\"\n"},
+		{"put a x\"y\n", "err \"bad quotes\"\n"},
+		{"put a \"x\"y\n", "err \"bad quotes\"\n"},
+		{"xyz a\n", "err \"unknown command\"\n"},
+		{"del a\n", "vsn *"},
+		{"get a\n", "nil\n"},
+	} {
+		sendText(t, connection, reader, step.request, step.expected)
+	}
+
+	// the same store is reachable from our own protocol...
+	value, _ := testObject.store.Get("b")
+	assertions.NewAssert(t).String("value", "hello world", value)
+}
+
+func TestTextBlankLinesAreIgnored(t *testing.T) {
+	t.Parallel()
+	testObject := createTestObject()
+	connection, reader := createTextConnection(testObject)
+	defer connection.Close()
+
+	sendText(t, connection, reader, "\n   \r\nput a 1\n", "vsn *")
+}
+
+func TestTextRejectsArgumentsOverTheLimits(t *testing.T) {
+	t.Parallel()
+	testObject := createTestObject()
+	testObject.limits.MaxKeyLength = 2
+	testObject.limits.MaxValueLength = 4
+	connection, reader := createTextConnection(testObject)
+	defer connection.Close()
+
+	sendText(t, connection, reader, "put abc 1\n", "err \"too large\"\n")
+	sendText(t, connection, reader, "put ab 12345\n", "err \"too large\"\n")
+	sendText(t, connection, reader, "put ab 1234\n", "vsn *")
+}
+
+func TestTextOversizedLineClosesConnection(t *testing.T) {
+	t.Parallel()
+	testObject := createTestObject()
+	connection, reader := createTextConnection(testObject)
+	defer connection.Close()
+
+	go func() { _, _ = connection.Write([]byte("put a " + strings.Repeat("v", KvServerTextLineLength) + "\n")) }()
+	actual, _ := reader.ReadString('\n')
+	assertions.NewAssert(t).String("reply", "err \"too large\"\n", actual)
+	_, err := reader.ReadByte()
+	assertions.NewAssert(t).Error(io.EOF, err)
+}
+
+func TestTextByeClosesConnection(t *testing.T) {
+	t.Parallel()
+	testObject := createTestObject()
+	connection, reader := createTextConnection(testObject)
+	defer connection.Close()
+
+	go func() { _, _ = connection.Write([]byte("bye\n")) }()
+	_, err := reader.ReadByte()
+	assertions.NewAssert(t).Error(io.EOF, err)
+}
+
+func TestSplitTextCommand(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	for line, expected := range map[string]string{
+		"":                     "",
+		"get a":                "get|a",
+		" get\ta \n":           "get|a",
+		`put "a b" "\n"`:       "put|a b|\n",
+		`put "" "é"`:           "put||é",
+		`put 'a' "\x41\x42"`:   "put|'a'|AB",
+		`put "a\"b" c`:         `put|a"b|c`,
+		"put \"a\"\t\"b\"\r\n": "put|a|b",
+	} {
+		args, err := splitTextCommand(line)
+		assert.TestError(line, nil, err)
+		assert.TestString(line, "args", expected, strings.Join(args, "|"))
+	}
+	for _, line := range []string{`put "a`, `put a"`, `put "a"b`, `put "\q"`} {
+		_, err := splitTextCommand(line)
+		assert.TestError(line, errTextBadQuotes, err)
+	}
+}
@@ -0,0 +1,135 @@
+package kvserver
+
+import (
+	"errors"
+	"fmt"
+	"kvsapp/kvstore"
+)
+
+// a client opens a transaction with 'mlt', after which 'put', 'del' and 'get' are queued
+// (each answered with 'qud') until 'exc' commits them to the store as a single request, or
+// 'dsc' throws them away. keys named by 'wch' beforehand abort the commit ('abt') if they
+// have changed since. a successful commit is answered with 'exc' followed by the response
+// to each queued command, in order...
+type kvTransaction struct {
+	queued []*commandMessage
+}
+
+var transactionOperations = map[string]kvstore.OperationCommand{
+	"get": kvstore.OperationGet,
+	"put": kvstore.OperationUpsert,
+	"del": kvstore.OperationDelete,
+}
+
+func (kvs *KvServer) handleSessionMessage(session *kvSession, message *commandMessage) (carryOn bool) {
+	if isListenCommand(message.Command) {
+		return kvs.handleListenMessage(session, message)
+	}
+	if isSubscribeCommand(message.Command) {
+		return kvs.handleSubscribeMessage(session, message)
+	}
+	if session.transaction == nil && !isTransactionCommand(message.Command) {
+		return kvs.handleMessage(session, message)
+	}
+
+	fmt.Printf("server: handling '%s' command\n", message.Command)
+
+	response := ""
+	switch message.Command {
+	case "wch":
+		response = kvs.handleWatch(session, message.Key)
+	case "mlt":
+		response = "err"
+		if session.transaction == nil {
+			session.transaction = &kvTransaction{}
+			response = "ack"
+		}
+	case "dsc":
+		response = "err"
+		if session.transaction != nil {
+			session.transaction = nil
+			session.watched = nil
+			response = "ack"
+		}
+	case "exc":
+		response = "err"
+		if session.transaction != nil {
+			response = kvs.commitTransaction(session.transaction, session.watched)
+			session.transaction = nil
+			session.watched = nil
+		}
+	case "bye", "die":
+		return kvs.handleMessage(session, message)
+	default:
+		response = "err"
+		if _, queueable := transactionOperations[message.Command]; queueable {
+			session.transaction.queued = append(session.transaction.queued, message)
+			response = "qud"
+		}
+	}
+	_, err := session.Write([]byte(response))
+	return err == nil
+}
+
+func isTransactionCommand(command string) bool {
+	return command == "wch" || command == "mlt" || command == "exc" || command == "dsc"
+}
+
+func (kvs *KvServer) handleWatch(session *kvSession, key string) string {
+	if session.transaction != nil {
+		return "err"
+	}
+	versioned, ok := kvs.store.(kvstore.VersionedStore)
+	if !ok {
+		return "err"
+	}
+	version := uint64(0)
+	if _, current, err := versioned.GetWithVersion(key); err == nil {
+		version = current
+	} else if !errors.Is(err, kvstore.ErrKeyNotFound) {
+		return "err"
+	}
+	if session.watched == nil {
+		session.watched = make(map[string]uint64)
+	}
+	session.watched[key] = version
+	return "ack"
+}
+
+func (kvs *KvServer) commitTransaction(transaction *kvTransaction, watched map[string]uint64) string {
+	operations := make([]kvstore.Operation, len(transaction.queued))
+	for i, message := range transaction.queued {
+		operations[i] = kvstore.Operation{Command: transactionOperations[message.Command], Key: message.Key, Value: message.Value}
+	}
+	transactional, ok := kvs.store.(kvstore.TransactionalStore)
+	if !ok {
+		return "err"
+	}
+	results, err := transactional.Transact(operations, watched)
+	if errors.Is(err, kvstore.ErrTransactionAborted) {
+		return "abt"
+	}
+	if err != nil {
+		return "err"
+	}
+
+	response := "exc"
+	for i, result := range results {
+		operation := operations[i]
+		switch {
+		case operation.Command == kvstore.OperationGet && result.Error != nil:
+			response += "nil"
+		case operation.Command == kvstore.OperationGet:
+			response += valueResponse(result.Value)
+		case result.Error != nil:
+			response += "err"
+		case operation.Command == kvstore.OperationUpsert:
+			kvs.replicatePut(operation.Key, operation.Value, result.Version)
+			response += versionResponse(result.Version)
+		case operation.Command == kvstore.OperationDelete:
+			kvs.replicateDelete(operation.Key, result.Version)
+			response += versionResponse(result.Version)
+		}
+	}
+	return response
+}
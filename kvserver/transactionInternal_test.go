@@ -0,0 +1,83 @@
+package kvserver
+
+import (
+	"bytes"
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"kvsapp/parsing"
+	"testing"
+)
+
+func createTestSession() (*kvSession, *bytes.Buffer) {
+	parser, _ := parsing.NewParser(getStandardGrammar())
+	buffer := &bytes.Buffer{}
+	return &kvSession{connection: buffer, parser: parser}, buffer
+}
+
+func sendToSession(t *testing.T, testObject *KvServer, session *kvSession, buffer *bytes.Buffer, data string) string {
+	buffer.Reset()
+	if carryOn, err := testObject.handleReceivedBytes(session, []byte(data)); !carryOn || err != nil {
+		t.Fatalf("param: carryOn, expected: true, actual: %v (%v)", carryOn, err)
+	}
+	return buffer.String()
+}
+
+func TestTransactionQueuesAndCommits(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+	session, buffer := createTestSession()
+
+	assert.String("exc outside transaction", "err", sendToSession(t, testObject, session, buffer, "exc"))
+	assert.String("mlt", "ack", sendToSession(t, testObject, session, buffer, "mlt"))
+	assert.String("mlt again", "err", sendToSession(t, testObject, session, buffer, "mlt"))
+	assert.String("put", "qud", sendToSession(t, testObject, session, buffer, "put11a11x"))
+	assert.String("get", "qud", sendToSession(t, testObject, session, buffer, "get11a"))
+	assert.String("del", "qud", sendToSession(t, testObject, session, buffer, "del11b"))
+	assert.String("unqueueable", "err", sendToSession(t, testObject, session, buffer, "ttl11a"))
+
+	// nothing is visible until the commit...
+	other, otherBuffer := createTestSession()
+	assert.String("other get", "nil", sendToSession(t, testObject, other, otherBuffer, "get11a"))
+
+	response := sendToSession(t, testObject, session, buffer, "exc")
+	_, version, _ := testObject.store.(kvstore.VersionedStore).GetWithVersion("a")
+	assert.String("exc", "exc"+versionResponse(version)+"val11x"+"vsn110", response)
+	assert.String("other get", "val11x", sendToSession(t, testObject, other, otherBuffer, "get11a"))
+}
+
+func TestTransactionDiscard(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+	session, buffer := createTestSession()
+
+	assert.String("dsc outside transaction", "err", sendToSession(t, testObject, session, buffer, "dsc"))
+	assert.String("mlt", "ack", sendToSession(t, testObject, session, buffer, "mlt"))
+	assert.String("put", "qud", sendToSession(t, testObject, session, buffer, "put11a11x"))
+	assert.String("dsc", "ack", sendToSession(t, testObject, session, buffer, "dsc"))
+	assert.String("get", "nil", sendToSession(t, testObject, session, buffer, "get11a"))
+}
+
+func TestTransactionAbortsWhenWatchedKeyChanges(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+	session, buffer := createTestSession()
+	other, otherBuffer := createTestSession()
+
+	assert.String("wch", "ack", sendToSession(t, testObject, session, buffer, "wch11a"))
+	assert.String("mlt", "ack", sendToSession(t, testObject, session, buffer, "mlt"))
+	assert.String("wch in transaction", "err", sendToSession(t, testObject, session, buffer, "wch11a"))
+	assert.String("put", "qud", sendToSession(t, testObject, session, buffer, "put11b11x"))
+	assert.String("other put", "vsn", sendToSession(t, testObject, other, otherBuffer, "put11a11y")[:3])
+	assert.String("exc", "abt", sendToSession(t, testObject, session, buffer, "exc"))
+	assert.String("get", "nil", sendToSession(t, testObject, session, buffer, "get11b"))
+
+	// watches are cleared by the commit...
+	assert.String("mlt", "ack", sendToSession(t, testObject, session, buffer, "mlt"))
+	assert.String("put", "qud", sendToSession(t, testObject, session, buffer, "put11b11x"))
+	response := sendToSession(t, testObject, session, buffer, "exc")
+	_, version, _ := testObject.store.(kvstore.VersionedStore).GetWithVersion("b")
+	assert.String("exc", "exc"+versionResponse(version), response)
+}
@@ -0,0 +1,47 @@
+package kvstore
+
+// Keys and values are held as Go strings, which are immutable byte sequences rather than text:
+// nothing in the store, its log or its snapshots assumes they are valid UTF-8, so NULs and any
+// other bytes round-trip unchanged.
+
+// GetBytes is Get for binary values, such as encoded protobufs; the slice is a copy the caller owns.
+func (store *KvStore) GetBytes(key string) ([]byte, error) {
+	value, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
+
+// UpsertBytes is Upsert for binary values; value is copied, so the caller may reuse it.
+func (store *KvStore) UpsertBytes(key string, value []byte) (uint64, error) {
+	return store.Upsert(key, string(value))
+}
+
+// GetBytes is Get for binary values; the slice is a copy the caller owns.
+func (store *ShardedStore) GetBytes(key string) ([]byte, error) {
+	value, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
+
+// UpsertBytes is Upsert for binary values; value is copied, so the caller may reuse it.
+func (store *ShardedStore) UpsertBytes(key string, value []byte) (uint64, error) {
+	return store.Upsert(key, string(value))
+}
+
+// GetBytes is Get for binary values; the slice is a copy the caller owns.
+func (store *DiskStore) GetBytes(key string) ([]byte, error) {
+	value, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
+
+// UpsertBytes is Upsert for binary values; value is copied, so the caller may reuse it.
+func (store *DiskStore) UpsertBytes(key string, value []byte) (uint64, error) {
+	return store.Upsert(key, string(value))
+}
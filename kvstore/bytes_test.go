@@ -0,0 +1,123 @@
+package kvstore_test
+
+import (
+	"bytes"
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"path/filepath"
+	"testing"
+)
+
+// every byte value, including NUL and ones that aren't valid UTF-8 on their own...
+func everyByte() []byte {
+	result := make([]byte, 256)
+	for i := range result {
+		result[i] = byte(i)
+	}
+	return result
+}
+
+func TestBinaryValuesSurviveTheLogAndSnapshots(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	path := filepath.Join(t.TempDir(), "store.wal")
+	key, value := "k\x00\xff", everyByte()
+
+	store := createLoggedTestObject(path, kvstore.LogSyncAlways)
+	if err := store.Open(); err != nil {
+		t.Fatalf("test setup failure (open): %s", err.Error())
+	}
+	_, err := store.UpsertBytes(key, value)
+	assert.Error(nil, err)
+	store.Close()
+
+	// replayed from the log...
+	store = createLoggedTestObject(path, kvstore.LogSyncAlways)
+	assert.Error(nil, store.Open())
+	actual, err := store.GetBytes(key)
+	assert.Error(nil, err)
+	assert.True("value from log", bytes.Equal(value, actual))
+	assert.Error(nil, store.Snapshot())
+	store.Close()
+
+	// and loaded from the snapshot...
+	store = createLoggedTestObject(path, kvstore.LogSyncAlways)
+	assert.Error(nil, store.Open())
+	defer store.Close()
+	actual, err = store.GetBytes(key)
+	assert.Error(nil, err)
+	assert.True("value from snapshot", bytes.Equal(value, actual))
+}
+
+func TestGetBytesReturnsACopy(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	value := []byte("abc")
+	store.UpsertBytes("key", value)
+	value[0] = 'x'
+	actual, _ := store.GetBytes("key")
+	assert.String("value", "abc", string(actual))
+	actual[1] = 'x'
+	actual, _ = store.GetBytes("key")
+	assert.String("value", "abc", string(actual))
+
+	_, err := store.GetBytes("missing")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+}
+
+func FuzzUpsertBytes(f *testing.F) {
+	f.Add("key", []byte("value"))
+	f.Add("\x00", []byte{0x00, 0xff, 0xc3, 0x28})
+	f.Add("\xe2\x82", everyByte())
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	f.Fuzz(func(t *testing.T, key string, value []byte) {
+		if _, err := store.UpsertBytes(key, value); err != nil {
+			t.Fatalf("upsert %q: %s", key, err.Error())
+		}
+		actual, err := store.GetBytes(key)
+		if err != nil || !bytes.Equal(value, actual) {
+			t.Errorf("key: %q, expected: %q, actual: %q (%v)", key, value, actual, err)
+		}
+	})
+}
+
+func TestShardedAndDiskStoresHoldBinaryValues(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	path := filepath.Join(t.TempDir(), "store.dat")
+	key, value := "k\x00\xff", everyByte()
+
+	for name, store := range map[string]interface {
+		kvstore.Store
+		kvstore.BinaryStore
+	}{
+		"sharded": kvstore.NewShardedStore(4),
+		"disk":    createDiskTestObject(path),
+	} {
+		assert.TestError(name, nil, store.Open())
+		_, err := store.UpsertBytes(key, value)
+		assert.TestError(name, nil, err)
+		value[0] = 'x'
+		actual, err := store.GetBytes(key)
+		assert.TestError(name, nil, err)
+		assert.TestBoolean(name, "value", true, bytes.Equal(everyByte(), actual))
+		value[0] = 0
+		_, err = store.GetBytes("missing")
+		assert.TestError(name, kvstore.ErrKeyNotFound, err)
+		store.Close()
+	}
+
+	// the disk store's value is read back from its data file...
+	store := createDiskTestObject(path)
+	assert.Error(nil, store.Open())
+	defer store.Close()
+	actual, _ := store.GetBytes(key)
+	assert.True("value from disk", bytes.Equal(everyByte(), actual))
+}
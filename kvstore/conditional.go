@@ -0,0 +1,85 @@
+package kvstore
+
+import "errors"
+
+var ErrValueMismatch = errors.New("value mismatch")
+var ErrKeyExists = errors.New("key exists")
+
+// CompareAndSwap replaces the value of key with value only if it currently holds expected; like Upsert, any expiry is cleared.
+func (store *KvStore) CompareAndSwap(key string, expected string, value string) (uint64, error) {
+	request := kvStoreRequest{
+		Command:  kvCommandCompareAndSwap,
+		Key:      key,
+		Value:    value,
+		Expected: expected,
+		Results:  make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return response.Version, response.Error
+}
+
+// PutIfAbsent inserts key only if it does not already exist.
+func (store *KvStore) PutIfAbsent(key string, value string) (uint64, error) {
+	request := kvStoreRequest{
+		Command: kvCommandPutIfAbsent,
+		Key:     key,
+		Value:   value,
+		Results: make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return response.Version, response.Error
+}
+
+// DeleteIfEquals removes key only if it currently holds expected.
+func (store *KvStore) DeleteIfEquals(key string, expected string) (uint64, error) {
+	request := kvStoreRequest{
+		Command:  kvCommandDeleteIfEquals,
+		Key:      key,
+		Value:    "",
+		Expected: expected,
+		Results:  make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return response.Version, response.Error
+}
+
+func (store *KvStore) compareAndSwap(key string, expected string, value string) (uint64, error) {
+	current, exists := store.items[key]
+	if !exists {
+		return 0, ErrKeyNotFound
+	}
+	if current.kind != KindString {
+		return current.version, ErrWrongType
+	}
+	if current.value != expected {
+		return current.version, ErrValueMismatch
+	}
+	return store.upsert(key, value)
+}
+
+func (store *KvStore) putIfAbsent(key string, value string) (uint64, error) {
+	if current, exists := store.items[key]; exists {
+		return current.version, ErrKeyExists
+	}
+	return store.upsert(key, value)
+}
+
+func (store *KvStore) deleteIfEquals(key string, expected string) (uint64, error) {
+	current, exists := store.items[key]
+	if !exists {
+		return 0, ErrKeyNotFound
+	}
+	if current.kind != KindString {
+		return current.version, ErrWrongType
+	}
+	if current.value != expected {
+		return current.version, ErrValueMismatch
+	}
+	return store.delete(key)
+}
@@ -0,0 +1,61 @@
+package kvstore_test
+
+import (
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"testing"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	_, err := store.CompareAndSwap("key", "old", "new")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+	store.Upsert("key", "old")
+	_, err = store.CompareAndSwap("key", "other", "new")
+	assert.Error(kvstore.ErrValueMismatch, err)
+	actualValue, _ := store.Get("key")
+	assert.String("value", "old", actualValue)
+
+	_, err = store.CompareAndSwap("key", "old", "new")
+	assert.Error(nil, err)
+	actualValue, _ = store.Get("key")
+	assert.String("value", "new", actualValue)
+}
+
+func TestPutIfAbsent(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	_, err := store.PutIfAbsent("key", "first")
+	assert.Error(nil, err)
+	_, err = store.PutIfAbsent("key", "second")
+	assert.Error(kvstore.ErrKeyExists, err)
+	actualValue, _ := store.Get("key")
+	assert.String("value", "first", actualValue)
+}
+
+func TestDeleteIfEquals(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	_, err := store.DeleteIfEquals("key", "value")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+	store.Upsert("key", "value")
+	_, err = store.DeleteIfEquals("key", "other")
+	assert.Error(kvstore.ErrValueMismatch, err)
+	_, err = store.DeleteIfEquals("key", "value")
+	assert.Error(nil, err)
+	_, err = store.Get("key")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+}
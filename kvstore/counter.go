@@ -0,0 +1,59 @@
+package kvstore
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+var ErrNotInteger = errors.New("value is not an integer")
+var ErrIntegerOverflow = errors.New("increment or decrement would overflow")
+
+// IncrementBy adds delta to the base-10 integer held by key, treating a missing key as zero, and
+// returns the new value along with its version. The read and the write are one request to the
+// store, so concurrent increments are never lost; any expiry the key has is kept.
+func (store *KvStore) IncrementBy(key string, delta int64) (int64, uint64, error) {
+	request := kvStoreRequest{
+		Command: kvCommandIncrement,
+		Key:     key,
+		Value:   "",
+		Delta:   delta,
+		Results: make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return response.Integer, response.Version, response.Error
+}
+
+// Increment adds one to the integer held by key.
+func (store *KvStore) Increment(key string) (int64, uint64, error) {
+	return store.IncrementBy(key, 1)
+}
+
+// Decrement subtracts one from the integer held by key.
+func (store *KvStore) Decrement(key string) (int64, uint64, error) {
+	return store.IncrementBy(key, -1)
+}
+
+func (store *KvStore) incrementBy(key string, delta int64) (int64, uint64, error) {
+	current := int64(0)
+	if item, exists := store.items[key]; exists {
+		if item.kind != KindString {
+			return 0, item.version, ErrWrongType
+		}
+		parsed, err := strconv.ParseInt(item.value, 10, 64)
+		if err != nil {
+			return 0, item.version, ErrNotInteger
+		}
+		current = parsed
+	}
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return current, 0, ErrIntegerOverflow
+	}
+	version, err := store.upsertKeepingExpiry(key, strconv.FormatInt(current+delta, 10))
+	if err != nil && version == 0 {
+		return current, 0, err
+	}
+	return current + delta, version, err
+}
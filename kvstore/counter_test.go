@@ -0,0 +1,94 @@
+package kvstore_test
+
+import (
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIncrementAndDecrement(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	value, version1, err := store.Increment("counter")
+	assert.Error(nil, err)
+	assert.Int("value", 1, int(value))
+	value, version2, _ := store.IncrementBy("counter", 41)
+	assert.Int("value", 42, int(value))
+	assert.True("version2 > version1", version2 > version1)
+	value, _, _ = store.Decrement("counter")
+	assert.Int("value", 41, int(value))
+	value, _, _ = store.IncrementBy("counter", -50)
+	assert.Int("value", -9, int(value))
+
+	actualValue, _ := store.Get("counter")
+	assert.String("stored", "-9", actualValue)
+	_, version, _ := store.GetWithVersion("counter")
+	_, latest, _ := store.IncrementBy("counter", 0)
+	assert.Uint64("unchanged", version, latest)
+}
+
+func TestIncrementRejectsNonIntegersAndOverflow(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	store.Upsert("text", "abc")
+	_, _, err := store.Increment("text")
+	assert.Error(kvstore.ErrNotInteger, err)
+	actualValue, _ := store.Get("text")
+	assert.String("value", "abc", actualValue)
+
+	store.Upsert("big", strconv.FormatInt(math.MaxInt64, 10))
+	_, _, err = store.Increment("big")
+	assert.Error(kvstore.ErrIntegerOverflow, err)
+	store.Upsert("small", strconv.FormatInt(math.MinInt64, 10))
+	_, _, err = store.Decrement("small")
+	assert.Error(kvstore.ErrIntegerOverflow, err)
+}
+
+func TestIncrementKeepsExpiry(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	store.UpsertWithTTL("window", "1", time.Minute)
+	store.Increment("window")
+	remaining, err := store.TTL("window")
+	assert.Error(nil, err)
+	assert.True("ttl kept", remaining > 0 && remaining <= time.Minute)
+}
+
+func TestConcurrentIncrementsAreNotLost(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	const writers, incrementsPerWriter = 8, 100
+	var wait sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := 0; i < incrementsPerWriter; i++ {
+				store.Increment("counter")
+			}
+		}()
+	}
+	wait.Wait()
+	actualValue, _ := store.Get("counter")
+	assert.String("value", strconv.Itoa(writers*incrementsPerWriter), actualValue)
+}
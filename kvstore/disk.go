@@ -0,0 +1,418 @@
+package kvstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DiskStore keeps every value in an append-only data file made of write-ahead log records,
+// holding only the position of each key's latest record in memory (in the manner of bitcask).
+// Reads go to disk; Snapshot rewrites the file with just the live records.
+type DiskStore struct {
+	options  DiskStoreOptions
+	lock     sync.RWMutex
+	log      *writeAheadLog
+	keys     map[string]diskEntry
+	deleted  *tombstones
+	revision uint64
+	stopped  chan struct{}
+}
+
+type DiskStoreOptions struct {
+	Path            string        // data file location
+	LogSyncPolicy   LogSyncPolicy // when appended records are flushed to disk
+	LogSyncInterval time.Duration // flush period for LogSyncInterval
+}
+
+// where a key's latest record lives in the data file...
+type diskEntry struct {
+	offset  int64
+	length  int64
+	version uint64
+}
+
+var ErrStoreClosed = errors.New("store closed")
+
+var _ Store = (*DiskStore)(nil)
+var _ SnapshottingStore = (*DiskStore)(nil)
+var _ VersionedStore = (*DiskStore)(nil)
+var _ ScanningStore = (*DiskStore)(nil)
+var _ BinaryStore = (*DiskStore)(nil)
+
+func NewDiskStore(options DiskStoreOptions) *DiskStore {
+	if options.LogSyncInterval <= 0 {
+		options.LogSyncInterval = DefaultLogSyncInterval
+	}
+	return &DiskStore{options: options}
+}
+
+func (store *DiskStore) Open() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.log != nil {
+		return nil
+	}
+	if store.options.Path == "" {
+		return errors.New("disk store requires a path")
+	}
+	store.keys = make(map[string]diskEntry)
+	store.deleted = newTombstones(DefaultTombstoneGracePeriod)
+	store.revision = 0
+	offset := int64(0)
+	log, err := openWriteAheadLog(store.options.Path, store.options.LogSyncPolicy, func(record walRecord) {
+		length := int64(walHeaderSize + walPayloadLength(record))
+		store.applyRecord(record, offset, length)
+		offset += length
+	})
+	if err != nil {
+		store.keys = nil
+		return err
+	}
+	store.log = log
+	store.stopped = make(chan struct{})
+	if store.options.LogSyncPolicy == LogSyncInterval {
+		go store.syncPeriodically(store.stopped)
+	}
+	return nil
+}
+
+func (store *DiskStore) Close() {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.log != nil {
+		close(store.stopped)
+		if err := store.log.close(); err != nil {
+			fmt.Printf("store: error closing data file '%s'\n", err.Error())
+		}
+		store.log = nil
+		store.keys = nil
+		store.deleted = nil
+	}
+}
+
+func (store *DiskStore) syncPeriodically(stopped chan struct{}) {
+	ticker := time.NewTicker(store.options.LogSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopped:
+			return
+		case <-ticker.C:
+			store.lock.Lock()
+			if store.log != nil {
+				if err := store.log.sync(); err != nil {
+					fmt.Printf("store: error syncing data file '%s'\n", err.Error())
+				}
+			}
+			store.lock.Unlock()
+		}
+	}
+}
+
+// tracks a record found while loading the data file...
+func (store *DiskStore) applyRecord(record walRecord, offset int64, length int64) {
+	switch {
+	case record.Op == walOpUpsert && len(record.Fields) == 3:
+		version := recordVersion(record, 2, store.revision)
+		store.keys[record.Fields[0]] = diskEntry{offset: offset, length: length, version: version}
+		store.deleted.remove(record.Fields[0])
+		store.observeVersion(version)
+	case record.Op == walOpDelete && len(record.Fields) == 2:
+		version := recordVersion(record, 1, store.revision)
+		delete(store.keys, record.Fields[0])
+		store.deleted.add(record.Fields[0], version, time.Now())
+		store.observeVersion(version)
+	case record.Op == walOpRevision && len(record.Fields) == 1:
+		store.observeVersion(recordVersion(record, 0, store.revision))
+	}
+}
+
+func (store *DiskStore) observeVersion(version uint64) {
+	if version > store.revision {
+		store.revision = version
+	}
+}
+
+func (store *DiskStore) Get(key string) (string, error) {
+	return store.GetContext(context.Background(), key)
+}
+
+func (store *DiskStore) GetContext(ctx context.Context, key string) (string, error) {
+	value, _, err := store.getWithVersion(ctx, key)
+	return value, err
+}
+
+// GetWithVersion returns the value of a key along with its current version. For a key that has
+// been deleted recently it returns ErrKeyNotFound along with the version of the deletion.
+func (store *DiskStore) GetWithVersion(key string) (string, uint64, error) {
+	return store.getWithVersion(context.Background(), key)
+}
+
+func (store *DiskStore) getWithVersion(ctx context.Context, key string) (string, uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return "", 0, err
+	}
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	if store.log == nil {
+		return "", 0, ErrStoreClosed
+	}
+	entry, exists := store.keys[key]
+	if !exists {
+		return "", store.deleted.version(key), ErrKeyNotFound
+	}
+	value, err := store.readValue(entry)
+	return value, entry.version, err
+}
+
+func (store *DiskStore) readValue(entry diskEntry) (string, error) {
+	buffer := make([]byte, entry.length)
+	if _, err := store.log.file.ReadAt(buffer, entry.offset); err != nil {
+		return "", err
+	}
+	record, _, err := readWalRecord(bytes.NewReader(buffer), entry.length)
+	if err != nil {
+		return "", err
+	}
+	if record.Op != walOpUpsert || len(record.Fields) != 3 {
+		return "", ErrLogCorrupt
+	}
+	return record.Fields[1], nil
+}
+
+// Upsert inserts or updates a key, returning its new version.
+func (store *DiskStore) Upsert(key string, value string) (uint64, error) {
+	return store.UpsertContext(context.Background(), key, value)
+}
+
+func (store *DiskStore) UpsertContext(ctx context.Context, key string, value string) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.log == nil {
+		return 0, ErrStoreClosed
+	}
+	// unlike the in-memory stores, rewriting a key's value isn't skipped, which would take a read
+	// from disk to find out...
+	return store.upsertVersion(key, value, nextVersion(store.revision, time.Now()))
+}
+
+// UpsertVersion applies a write made elsewhere in the cluster, keeping the version it was given
+// there; it is ignored with ErrStaleVersion if the key already has a newer version, or the same
+// version and a value that sorts at or after the one given.
+func (store *DiskStore) UpsertVersion(key string, value string, version uint64) (uint64, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.log == nil {
+		return 0, ErrStoreClosed
+	}
+	if entry, exists := store.keys[key]; exists && entry.version >= version {
+		current := ""
+		if entry.version == version {
+			var err error
+			if current, err = store.readValue(entry); err != nil {
+				return 0, err
+			}
+		}
+		if !supersedes(version, value, entry.version, current) {
+			return entry.version, ErrStaleVersion
+		}
+	}
+	if deleted := store.deleted.version(key); deleted > 0 && !supersedes(version, value, deleted, "") {
+		return deleted, ErrStaleVersion
+	}
+	return store.upsertVersion(key, value, version)
+}
+
+// DeleteVersion applies a delete made elsewhere in the cluster, keeping the version it was given
+// there; it is ignored with ErrStaleVersion if the key already has a write at or after that
+// version. The key is remembered as deleted, so that older writes arriving later are ignored too.
+func (store *DiskStore) DeleteVersion(key string, version uint64) (uint64, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.log == nil {
+		return 0, ErrStoreClosed
+	}
+	if entry, exists := store.keys[key]; exists && !deleteSupersedes(version, entry.version) {
+		return entry.version, ErrStaleVersion
+	}
+	if deleted := store.deleted.version(key); deleted >= version {
+		return deleted, ErrStaleVersion
+	}
+	return store.deleteVersion(key, version)
+}
+
+func (store *DiskStore) upsertVersion(key string, value string, version uint64) (uint64, error) {
+	offset := store.log.size
+	record := walRecord{Op: walOpUpsert, Fields: []string{key, value, formatVersion(version)}}
+	if err := store.log.append(record); err != nil {
+		return 0, err
+	}
+	store.keys[key] = diskEntry{offset: offset, length: store.log.size - offset, version: version}
+	store.deleted.remove(key)
+	store.observeVersion(version)
+	return version, nil
+}
+
+// Delete removes a key, returning the version of the deletion (zero if the key didn't exist).
+func (store *DiskStore) Delete(key string) (uint64, error) {
+	return store.DeleteContext(context.Background(), key)
+}
+
+func (store *DiskStore) DeleteContext(ctx context.Context, key string) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.log == nil {
+		return 0, ErrStoreClosed
+	}
+	if _, exists := store.keys[key]; !exists {
+		return 0, nil
+	}
+	return store.deleteVersion(key, nextVersion(store.revision, time.Now()))
+}
+
+func (store *DiskStore) deleteVersion(key string, version uint64) (uint64, error) {
+	if err := store.log.append(walRecord{Op: walOpDelete, Fields: []string{key, formatVersion(version)}}); err != nil {
+		return 0, err
+	}
+	delete(store.keys, key)
+	store.deleted.add(key, version, time.Now())
+	store.observeVersion(version)
+	return version, nil
+}
+
+func (store *DiskStore) ListKeys() []string {
+	keys, _ := store.ListKeysContext(context.Background())
+	return keys
+}
+
+func (store *DiskStore) ListKeysContext(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.sortedKeys("", ""), nil
+}
+
+// Scan returns up to limit entries, in lexical key order, whose keys are at or after start
+// and before end. An empty end means no upper bound; a limit of zero or less means no limit.
+func (store *DiskStore) Scan(start string, end string, limit int) ([]KeyValue, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	if store.log == nil {
+		return nil, ErrStoreClosed
+	}
+	keys := store.sortedKeys(start, end)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	result := make([]KeyValue, 0, len(keys))
+	for _, key := range keys {
+		value, err := store.readValue(store.keys[key])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, KeyValue{Key: key, Value: value})
+	}
+	return result, nil
+}
+
+func (store *DiskStore) sortedKeys(start string, end string) []string {
+	result := make([]string, 0)
+	for key := range store.keys {
+		if key >= start && (end == "" || key < end) {
+			result = append(result, key)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Snapshot compacts the data file, copying the live records to a new file and swapping it in.
+// Other requests wait until it is complete.
+func (store *DiskStore) Snapshot() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.log == nil {
+		return ErrStoreClosed
+	}
+	temporaryPath := store.options.Path + ".compact"
+	keys, err := store.writeCompacted(temporaryPath)
+	if err != nil {
+		_ = os.Remove(temporaryPath)
+		return err
+	}
+	if err := store.log.file.Close(); err != nil {
+		return err
+	}
+	renameErr := os.Rename(temporaryPath, store.options.Path)
+	file, err := os.OpenFile(store.options.Path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		store.log = nil
+		return err
+	}
+	store.log.file = file
+	if renameErr != nil {
+		return renameErr
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	store.log.size = info.Size()
+	store.log.dirty = false
+	store.keys = keys
+	return syncDirectory(store.options.Path)
+}
+
+// writes the revision then every live record to path, returning where each record now lives...
+func (store *DiskStore) writeCompacted(path string) (map[string]diskEntry, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := store.writeCompactedRecords(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return keys, err
+}
+
+func (store *DiskStore) writeCompactedRecords(file *os.File) (map[string]diskEntry, error) {
+	offset := int64(0)
+	write := func(data []byte) error {
+		written, err := file.Write(data)
+		offset += int64(written)
+		return err
+	}
+	if err := write(encodeWalRecord(walRecord{Op: walOpRevision, Fields: []string{formatVersion(store.revision)}})); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]diskEntry, len(store.keys))
+	for key, entry := range store.keys {
+		buffer := make([]byte, entry.length)
+		if _, err := store.log.file.ReadAt(buffer, entry.offset); err != nil {
+			return nil, err
+		}
+		keys[key] = diskEntry{offset: offset, length: entry.length, version: entry.version}
+		if err := write(buffer); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,194 @@
+package kvstore_test
+
+import (
+	"context"
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createDiskTestObject(path string) *kvstore.DiskStore {
+	return kvstore.NewDiskStore(kvstore.DiskStoreOptions{Path: path, LogSyncPolicy: kvstore.LogSyncNever})
+}
+
+func TestDiskStoreGetUpsertDelete(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createDiskTestObject(filepath.Join(t.TempDir(), "store.dat"))
+	assert.Error(nil, store.Open())
+	defer store.Close()
+
+	_, err := store.Get("key")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+	version1, _ := store.Upsert("key", "value1")
+	assert.True("version1 > 0", version1 > 0)
+	version2, _ := store.Upsert("key", "value2")
+	assert.True("version2 > version1", version2 > version1)
+	// the same value is written again, rather than read back to compare...
+	rewritten, _ := store.Upsert("key", "value2")
+	assert.True("rewritten > version2", rewritten > version2)
+	actualValue, err := store.Get("key")
+	assert.Error(nil, err)
+	assert.String("value", "value2", actualValue)
+
+	deleted, _ := store.Delete("key")
+	assert.True("deleted > rewritten", deleted > rewritten)
+	version, _ := store.Delete("key")
+	assert.Uint64("version", 0, version)
+	_, err = store.Get("key")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+}
+
+func TestDiskStoreReloadsOnOpen(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	path := filepath.Join(t.TempDir(), "store.dat")
+
+	store := createDiskTestObject(path)
+	store.Open()
+	store.Upsert("kept", "value1")
+	kept, _ := store.Upsert("kept", "value2")
+	store.Upsert("deleted", "value")
+	deleted, _ := store.Delete("deleted")
+	store.Close()
+
+	store = createDiskTestObject(path)
+	assert.Error(nil, store.Open())
+	defer store.Close()
+	actualValue, version, err := store.GetWithVersion("kept")
+	assert.Error(nil, err)
+	assert.String("value", "value2", actualValue)
+	assert.Uint64("version", kept, version)
+	_, err = store.Get("deleted")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+	version, _ = store.Upsert("new", "value")
+	assert.True("version > deleted", version > deleted)
+}
+
+func TestDiskStoreSnapshotCompactsDataFile(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	path := filepath.Join(t.TempDir(), "store.dat")
+
+	store := createDiskTestObject(path)
+	store.Open()
+	expectedVersion := uint64(0)
+	for i := 0; i < 100; i++ {
+		expectedVersion, _ = store.Upsert("key", string(rune('a'+i%26)))
+	}
+	store.Upsert("other", "value")
+	store.Delete("other")
+	before, _ := os.Stat(path)
+
+	assert.Error(nil, store.Snapshot())
+	after, _ := os.Stat(path)
+	assert.True("compacted", after.Size() < before.Size())
+	actualValue, _ := store.Get("key")
+	assert.String("value", "v", actualValue)
+	compacted, _ := store.Upsert("after", "compaction")
+	store.Close()
+
+	store = createDiskTestObject(path)
+	assert.Error(nil, store.Open())
+	defer store.Close()
+	actualValue, version, _ := store.GetWithVersion("key")
+	assert.String("value", "v", actualValue)
+	assert.Uint64("version", expectedVersion, version)
+	actualValue, _ = store.Get("after")
+	assert.String("value", "compaction", actualValue)
+	version, _ = store.Upsert("next", "value")
+	assert.True("version > compacted", version > compacted)
+}
+
+func TestDiskStoreUpsertVersionIgnoresStaleWrites(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createDiskTestObject(filepath.Join(t.TempDir(), "store.dat"))
+	store.Open()
+	defer store.Close()
+
+	_, err := store.UpsertVersion("key", "remote", remoteVersion)
+	assert.Error(nil, err)
+	_, err = store.UpsertVersion("key", "stale", remoteVersion-1)
+	assert.Error(kvstore.ErrStaleVersion, err)
+	_, err = store.UpsertVersion("key", "other", remoteVersion)
+	assert.Error(kvstore.ErrStaleVersion, err)
+	_, err = store.UpsertVersion("key", "replacement", remoteVersion)
+	assert.Error(nil, err)
+	actualValue, _ := store.Get("key")
+	assert.String("value", "replacement", actualValue)
+}
+
+func TestDiskStoreScan(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createDiskTestObject(filepath.Join(t.TempDir(), "store.dat"))
+	store.Open()
+	defer store.Close()
+
+	for _, key := range []string{"c", "a", "b", "d"} {
+		store.Upsert(key, "value-"+key)
+	}
+	entries, err := store.Scan("b", "d", 0)
+	assert.Error(nil, err)
+	assert.Int("count", 2, len(entries))
+	assert.String("key", "b", entries[0].Key)
+	assert.String("value", "value-b", entries[0].Value)
+	assert.String("key", "c", entries[1].Key)
+}
+
+func TestStoresHonourCancelledContexts(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	memory := createTestObject()
+	memory.Open()
+	defer memory.Close()
+	disk := createDiskTestObject(filepath.Join(t.TempDir(), "store.dat"))
+	disk.Open()
+	defer disk.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, store := range []kvstore.Store{memory, disk} {
+		_, err := store.UpsertContext(ctx, "key", "value")
+		assert.Error(context.Canceled, err)
+		_, err = store.GetContext(ctx, "key")
+		assert.Error(context.Canceled, err)
+		_, err = store.DeleteContext(ctx, "key")
+		assert.Error(context.Canceled, err)
+		_, err = store.ListKeysContext(ctx)
+		assert.Error(context.Canceled, err)
+
+		_, err = store.UpsertContext(context.Background(), "key", "value")
+		assert.Error(nil, err)
+		actualValue, err := store.GetContext(context.Background(), "key")
+		assert.Error(nil, err)
+		assert.String("value", "value", actualValue)
+	}
+}
+
+func TestDiskStoreDeletesSurviveReopenAsTombstones(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	path := filepath.Join(t.TempDir(), "store.dat")
+	store := createDiskTestObject(path)
+	store.Open()
+	store.UpsertVersion("key", "value", remoteVersion)
+	_, err := store.DeleteVersion("key", remoteVersion-1)
+	assert.Error(kvstore.ErrStaleVersion, err)
+	_, err = store.DeleteVersion("key", remoteVersion+2)
+	assert.Error(nil, err)
+	store.Close()
+
+	// the delete is replayed from the log, so a late write is still recognised as one...
+	store = createDiskTestObject(path)
+	assert.Error(nil, store.Open())
+	defer store.Close()
+	_, err = store.UpsertVersion("key", "late", remoteVersion+1)
+	assert.Error(kvstore.ErrStaleVersion, err)
+	_, version, err := store.GetWithVersion("key")
+	assert.Uint64("version", remoteVersion+2, version)
+	assert.Error(kvstore.ErrKeyNotFound, err)
+}
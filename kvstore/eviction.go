@@ -0,0 +1,171 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EvictionPolicy decides what happens when a write would take the store past its limits.
+type EvictionPolicy int
+
+const (
+	EvictionNone        EvictionPolicy = iota // reject the write with ErrOutOfMemory
+	EvictionAllKeysLRU                        // evict the least recently used key
+	EvictionAllKeysLFU                        // evict the least frequently used key
+	EvictionVolatileTTL                       // evict the key with a ttl that expires soonest
+)
+
+// how many keys are looked at to choose each one to evict; like redis, eviction is approximate...
+const evictionSampleSize int = 5
+
+var ErrOutOfMemory = errors.New("out of memory")
+
+// MemoryStats describes how much the store holds and what its limits have done about it.
+type MemoryStats struct {
+	Keys       int
+	Memory     int64  // bytes of keys plus values
+	Evictions  uint64 // keys removed to make room for writes
+	Rejections uint64 // writes refused with ErrOutOfMemory
+}
+
+func ParseEvictionPolicy(value string) (EvictionPolicy, error) {
+	switch strings.ToLower(value) {
+	case "noeviction":
+		return EvictionNone, nil
+	case "allkeys-lru":
+		return EvictionAllKeysLRU, nil
+	case "allkeys-lfu":
+		return EvictionAllKeysLFU, nil
+	case "volatile-ttl":
+		return EvictionVolatileTTL, nil
+	}
+	return EvictionNone, fmt.Errorf("unknown eviction policy '%s'", value)
+}
+
+// MemoryStats returns the current size of the store and its eviction counters.
+func (store *KvStore) MemoryStats() MemoryStats {
+	request := kvStoreRequest{
+		Command: kvCommandMemoryStats,
+		Key:     "",
+		Value:   "",
+		Results: make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return response.Stats
+}
+
+func (store *KvStore) memoryStats() MemoryStats {
+	return MemoryStats{
+		Keys:       len(store.items),
+		Memory:     store.memory,
+		Evictions:  store.evictions,
+		Rejections: store.rejections,
+	}
+}
+
+func itemSize(key string, value string) int64 {
+	return int64(len(key) + len(value))
+}
+
+// the bytes an item counts for against MaxMemory...
+func (item *kvItem) size(key string) int64 {
+	return itemSize(key, item.value) + item.elementBytes
+}
+
+// records a read, for the lru and lfu policies...
+func (item *kvItem) touch(now time.Time) {
+	item.accessed = now.UnixNano()
+	if item.hits < ^uint32(0) {
+		item.hits++
+	}
+}
+
+// evicts keys, other than key itself, until writing value to key fits within the limits...
+func (store *KvStore) makeRoom(key string, value string) error {
+	growth, added := itemSize(key, value), 1
+	if previous, exists := store.items[key]; exists {
+		growth, added = itemSize(key, value)-previous.size(key), 0
+	}
+	return store.makeRoomFor(key, growth, added)
+}
+
+// evicts keys, other than key itself, until growing the store by growth bytes and added keys
+// fits within the limits...
+func (store *KvStore) makeRoomFor(key string, growth int64, added int) error {
+	limits := store.options
+	if limits.MaxMemory <= 0 && limits.MaxKeys <= 0 {
+		return nil
+	}
+	size := growth
+	if previous, exists := store.items[key]; exists {
+		size += previous.size(key)
+	}
+	return store.makeRoomExcluding(map[string]struct{}{key: {}}, size, growth, added)
+}
+
+// evicts keys other than those in exclude, which will be size bytes once written, until growing
+// the store by growth bytes and added keys fits within the limits...
+func (store *KvStore) makeRoomExcluding(exclude map[string]struct{}, size int64, growth int64, added int) error {
+	limits := store.options
+	if limits.MaxMemory > 0 && size > limits.MaxMemory {
+		store.rejections++
+		return ErrOutOfMemory
+	}
+	for {
+		overMemory := limits.MaxMemory > 0 && store.memory+growth > limits.MaxMemory
+		overKeys := limits.MaxKeys > 0 && len(store.items)+added > limits.MaxKeys
+		if !overMemory && !overKeys {
+			return nil
+		}
+		victim, found := store.chooseVictim(exclude)
+		if !found {
+			store.rejections++
+			return ErrOutOfMemory
+		}
+		if _, err := store.remove(victim, ChangeEvict); err != nil {
+			return err
+		}
+		store.evictions++
+	}
+}
+
+func (store *KvStore) chooseVictim(exclude map[string]struct{}) (string, bool) {
+	victim, found := "", false
+	best := int64(0)
+	sampled := 0
+	consider := func(key string, score int64) bool {
+		if _, excluded := exclude[key]; excluded {
+			return true
+		}
+		if !found || score < best {
+			victim, best, found = key, score, true
+		}
+		sampled++
+		return sampled < evictionSampleSize
+	}
+	switch store.options.EvictionPolicy {
+	case EvictionAllKeysLRU:
+		for key, item := range store.items {
+			if !consider(key, item.accessed) {
+				break
+			}
+		}
+	case EvictionAllKeysLFU:
+		for key, item := range store.items {
+			if !consider(key, int64(item.hits)) {
+				break
+			}
+		}
+	case EvictionVolatileTTL:
+		for key, expiry := range store.expiries {
+			if !consider(key, expiry.UnixNano()) {
+				break
+			}
+		}
+	}
+	return victim, found
+}
@@ -0,0 +1,121 @@
+package kvstore_test
+
+import (
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"testing"
+	"time"
+)
+
+func createLimitedTestObject(maxMemory int64, maxKeys int, policy kvstore.EvictionPolicy) *kvstore.KvStore {
+	return kvstore.NewKvStoreWithOptions(kvstore.KvStoreOptions{MaxMemory: maxMemory, MaxKeys: maxKeys, EvictionPolicy: policy})
+}
+
+func TestNoEvictionRejectsWritesOverTheLimit(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createLimitedTestObject(0, 2, kvstore.EvictionNone)
+	store.Open()
+	defer store.Close()
+
+	store.Upsert("a", "1")
+	store.Upsert("b", "2")
+	_, err := store.Upsert("c", "3")
+	assert.Error(kvstore.ErrOutOfMemory, err)
+	_, err = store.Upsert("a", "updated")
+	assert.Error(nil, err)
+	_, err = store.Get("c")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+
+	stats := store.MemoryStats()
+	assert.Int("keys", 2, stats.Keys)
+	assert.Uint64("evictions", 0, stats.Evictions)
+	assert.Uint64("rejections", 1, stats.Rejections)
+}
+
+func TestAllKeysLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createLimitedTestObject(0, 3, kvstore.EvictionAllKeysLRU)
+	store.Open()
+	defer store.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		store.Upsert(key, "value")
+		time.Sleep(time.Millisecond)
+	}
+	store.Get("a")
+	time.Sleep(time.Millisecond)
+
+	_, err := store.Upsert("d", "value")
+	assert.Error(nil, err)
+	_, err = store.Get("b")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+	_, err = store.Get("a")
+	assert.Error(nil, err)
+	assert.Uint64("evictions", 1, store.MemoryStats().Evictions)
+}
+
+func TestAllKeysLFUEvictsLeastFrequentlyUsed(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createLimitedTestObject(0, 3, kvstore.EvictionAllKeysLFU)
+	store.Open()
+	defer store.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		store.Upsert(key, "value")
+	}
+	store.Get("a")
+	store.Get("a")
+	store.Get("c")
+
+	store.Upsert("d", "value")
+	_, err := store.Get("b")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+	assert.Int("keys", 3, len(store.ListKeys()))
+}
+
+func TestVolatileTTLEvictsSoonestExpiringKeyOnly(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createLimitedTestObject(0, 3, kvstore.EvictionVolatileTTL)
+	store.Open()
+	defer store.Close()
+
+	store.Upsert("a", "value")
+	store.UpsertWithTTL("b", "value", time.Hour)
+	store.UpsertWithTTL("c", "value", time.Minute)
+
+	store.Upsert("d", "value")
+	_, err := store.Get("c")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+	store.Upsert("e", "value")
+	_, err = store.Get("b")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+	_, err = store.Upsert("f", "value")
+	assert.Error(kvstore.ErrOutOfMemory, err)
+	_, err = store.Get("a")
+	assert.Error(nil, err)
+}
+
+func TestMaxMemoryCountsKeysAndValues(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createLimitedTestObject(10, 0, kvstore.EvictionAllKeysLRU)
+	store.Open()
+	defer store.Close()
+
+	store.Upsert("a", "1234")
+	store.Upsert("b", "1234")
+	assert.True("memory", store.MemoryStats().Memory == 10)
+	store.Upsert("c", "1")
+	stats := store.MemoryStats()
+	assert.Int("keys", 2, stats.Keys)
+	assert.True("memory", stats.Memory == 7)
+
+	_, err := store.Upsert("big", "12345678")
+	assert.Error(kvstore.ErrOutOfMemory, err)
+	store.Delete("c")
+	assert.True("memory", store.MemoryStats().Memory == 5)
+}
@@ -0,0 +1,169 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const DefaultExpirySweepInterval time.Duration = 1 * time.Second
+
+// expirations are reported on a buffered channel; if nobody is draining it they are dropped...
+const expiredKeysBufferSize int = 1024
+
+const walOpExpire byte = 3
+const walOpPersist byte = 4
+
+var ErrKeyHasNoExpiry = errors.New("key has no expiry")
+
+// UpsertWithTTL inserts or updates a key which will expire after ttl, returning its new version.
+func (store *KvStore) UpsertWithTTL(key string, value string, ttl time.Duration) (uint64, error) {
+	request := kvStoreRequest{
+		Command: kvCommandUpsertWithTTL,
+		Key:     key,
+		Value:   value,
+		TTL:     ttl,
+		Results: make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return response.Version, response.Error
+}
+
+// Expire sets a key to expire after ttl; a ttl of zero or less removes the key immediately.
+func (store *KvStore) Expire(key string, ttl time.Duration) error {
+	request := kvStoreRequest{
+		Command: kvCommandExpire,
+		Key:     key,
+		Value:   "",
+		TTL:     ttl,
+		Results: make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return response.Error
+}
+
+// TTL returns the time remaining before a key expires.
+func (store *KvStore) TTL(key string) (time.Duration, error) {
+	request := kvStoreRequest{
+		Command: kvCommandTTL,
+		Key:     key,
+		Value:   "",
+		Results: make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return response.TTL, response.Error
+}
+
+// Persist removes any expiry from a key.
+func (store *KvStore) Persist(key string) error {
+	request := kvStoreRequest{
+		Command: kvCommandPersist,
+		Key:     key,
+		Value:   "",
+		Results: make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return response.Error
+}
+
+// Expired returns a channel on which the keys removed by expiry are reported.
+func (store *KvStore) Expired() <-chan string {
+	return store.expired
+}
+
+func (store *KvStore) isExpired(key string, now time.Time) bool {
+	expiry, volatile := store.expiries[key]
+	return volatile && !now.Before(expiry)
+}
+
+// lazily removes a key that has passed its expiry...
+func (store *KvStore) expireIfDue(key string, now time.Time) {
+	if store.isExpired(key, now) {
+		store.removeExpired(key)
+	}
+}
+
+func (store *KvStore) removeExpired(key string) {
+	if _, err := store.remove(key, ChangeExpire); err != nil {
+		fmt.Printf("store: unable to expire key '%s', error '%s'\n", key, err.Error())
+		return
+	}
+	select {
+	case store.expired <- key:
+	default:
+	}
+}
+
+// actively removes every key that has passed its expiry...
+func (store *KvStore) sweepExpired() {
+	now := time.Now()
+	for key := range store.expiries {
+		if store.isExpired(key, now) {
+			store.removeExpired(key)
+		}
+	}
+}
+
+func (store *KvStore) setExpiry(key string, expiry time.Time) error {
+	if err := store.writeAhead(walOpExpire, key, strconv.FormatInt(expiry.UnixNano(), 10)); err != nil {
+		return err
+	}
+	store.expiries[key] = expiry
+	return nil
+}
+
+func (store *KvStore) upsertWithTTL(key string, value string, ttl time.Duration) (uint64, error) {
+	if ttl <= 0 {
+		return store.delete(key)
+	}
+	version, err := store.upsert(key, value)
+	if err != nil {
+		return 0, err
+	}
+	return version, store.setExpiry(key, time.Now().Add(ttl))
+}
+
+func (store *KvStore) expire(key string, ttl time.Duration) error {
+	if _, exists := store.items[key]; !exists {
+		return ErrKeyNotFound
+	}
+	if ttl <= 0 {
+		store.removeExpired(key)
+		return nil
+	}
+	return store.setExpiry(key, time.Now().Add(ttl))
+}
+
+func (store *KvStore) ttl(key string) (time.Duration, error) {
+	if _, exists := store.items[key]; !exists {
+		return 0, ErrKeyNotFound
+	}
+	expiry, volatile := store.expiries[key]
+	if !volatile {
+		return 0, ErrKeyHasNoExpiry
+	}
+	return time.Until(expiry), nil
+}
+
+func (store *KvStore) persist(key string) error {
+	if _, exists := store.items[key]; !exists {
+		return ErrKeyNotFound
+	}
+	if _, volatile := store.expiries[key]; !volatile {
+		return nil
+	}
+	if err := store.writeAhead(walOpPersist, key); err != nil {
+		return err
+	}
+	delete(store.expiries, key)
+	return nil
+}
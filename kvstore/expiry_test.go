@@ -0,0 +1,112 @@
+package kvstore_test
+
+import (
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGetLazilyExpiresKey(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := kvstore.NewKvStoreWithOptions(kvstore.KvStoreOptions{ExpirySweepInterval: time.Hour})
+	store.Open()
+	defer store.Close()
+
+	store.UpsertWithTTL("key", "value", 20*time.Millisecond)
+	actualValue, err := store.Get("key")
+	assert.String("value", "value", actualValue)
+	assert.Error(nil, err)
+
+	time.Sleep(40 * time.Millisecond)
+	actualValue, err = store.Get("key")
+	assert.String("value", "", actualValue)
+	assert.Error(kvstore.ErrKeyNotFound, err)
+	assert.String("expired", "key", <-store.Expired())
+}
+
+func TestSweeperExpiresKey(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := kvstore.NewKvStoreWithOptions(kvstore.KvStoreOptions{ExpirySweepInterval: 10 * time.Millisecond})
+	store.Open()
+	defer store.Close()
+
+	store.Upsert("key", "value")
+	assert.Error(nil, store.Expire("key", 20*time.Millisecond))
+
+	select {
+	case key := <-store.Expired():
+		assert.String("expired", "key", key)
+	case <-time.After(time.Second):
+		t.Fatalf("param: expired, expected: key, actual: timeout")
+	}
+	assert.Boolean("listed", false, len(store.ListKeys()) > 0)
+}
+
+func TestTtlAndPersist(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	_, err := store.TTL("missing")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+	assert.Error(kvstore.ErrKeyNotFound, store.Expire("missing", time.Minute))
+	assert.Error(kvstore.ErrKeyNotFound, store.Persist("missing"))
+
+	store.UpsertWithTTL("key", "value", time.Minute)
+	remaining, err := store.TTL("key")
+	assert.Error(nil, err)
+	assert.True("remaining", remaining > 0 && remaining <= time.Minute)
+
+	assert.Error(nil, store.Persist("key"))
+	_, err = store.TTL("key")
+	assert.Error(kvstore.ErrKeyHasNoExpiry, err)
+
+	// a plain upsert clears any expiry...
+	store.UpsertWithTTL("key", "value", time.Minute)
+	store.Upsert("key", "value")
+	_, err = store.TTL("key")
+	assert.Error(kvstore.ErrKeyHasNoExpiry, err)
+}
+
+func TestExpireWithoutPositiveTtlRemovesKey(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	store.Upsert("key", "value")
+	assert.Error(nil, store.Expire("key", 0))
+	_, err := store.Get("key")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+}
+
+func TestTtlSurvivesReopen(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	path := filepath.Join(t.TempDir(), "store.wal")
+
+	store := createLoggedTestObject(path, kvstore.LogSyncAlways)
+	if err := store.Open(); err != nil {
+		t.Fatalf("test setup failure (open): %s", err.Error())
+	}
+	store.UpsertWithTTL("volatile", "value", time.Minute)
+	store.UpsertWithTTL("persisted", "value", time.Minute)
+	store.Persist("persisted")
+	store.Close()
+
+	store = createLoggedTestObject(path, kvstore.LogSyncAlways)
+	assert.Error(nil, store.Open())
+	defer store.Close()
+	remaining, err := store.TTL("volatile")
+	assert.Error(nil, err)
+	assert.True("remaining", remaining > 0)
+	_, err = store.TTL("persisted")
+	assert.Error(kvstore.ErrKeyHasNoExpiry, err)
+}
@@ -1,54 +1,205 @@
 package kvstore
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"strconv"
+	"time"
 )
 
 const kvCommandUpsert string = "UPSERT"
 const kvCommandGet string = "GET"
 const kvCommandDelete string = "DELETE"
 const kvCommandList string = "LIST"
+const kvCommandSnapshot string = "SNAPSHOT"
+const kvCommandUpsertWithTTL string = "UPSERTTTL"
+const kvCommandExpire string = "EXPIRE"
+const kvCommandTTL string = "TTL"
+const kvCommandPersist string = "PERSIST"
+const kvCommandCompareAndSwap string = "CAS"
+const kvCommandPutIfAbsent string = "PUTIFABSENT"
+const kvCommandDeleteIfEquals string = "DELETEIFEQUALS"
+const kvCommandGetWithVersion string = "GETWITHVERSION"
+const kvCommandUpsertVersion string = "UPSERTVERSION"
+const kvCommandDeleteVersion string = "DELETEVERSION"
+const kvCommandTransact string = "TRANSACT"
+const kvCommandScan string = "SCAN"
+const kvCommandMemoryStats string = "MEMORYSTATS"
+const kvCommandWatch string = "WATCH"
+const kvCommandIncrement string = "INCREMENT"
+const kvCommandGetMany string = "GETMANY"
+const kvCommandUpsertMany string = "UPSERTMANY"
+const kvCommandAppend string = "APPEND"
+const kvCommandGetRange string = "GETRANGE"
+const kvCommandSetRange string = "SETRANGE"
+const kvCommandStrlen string = "STRLEN"
+const kvCommandHashSet string = "HASHSET"
+const kvCommandHashGet string = "HASHGET"
+const kvCommandHashDelete string = "HASHDELETE"
+const kvCommandHashGetAll string = "HASHGETALL"
+const kvCommandListPush string = "LISTPUSH"
+const kvCommandListPop string = "LISTPOP"
+const kvCommandListRange string = "LISTRANGE"
+const kvCommandSetAdd string = "SETADD"
+const kvCommandSetRemove string = "SETREMOVE"
+const kvCommandSetMembers string = "SETMEMBERS"
+const kvCommandSetIsMember string = "SETISMEMBER"
+const kvCommandGetStructure string = "GETSTRUCTURE"
+const kvCommandUpsertStructure string = "UPSERTSTRUCTURE"
 
 type KvStore struct {
-	items    map[string]string
-	requests chan kvStoreRequest
+	items     map[string]*kvItem
+	index     *skiplist
+	deleted   *tombstones
+	revision  uint64
+	memory    int64
+	expiries  map[string]time.Time
+	expired   chan string
+	requests  chan kvStoreRequest
+	stopped   chan struct{}
+	options   KvStoreOptions
+	log       *writeAheadLog
+	batch     *walBatch
+	watchers  map[*kvWatcher]struct{}
+	unwatches chan *kvWatcher
+
+	snapshotting   bool
+	snapshotWaiter chan kvStoreResponse
+	snapshotDone   chan error
+
+	evictions  uint64
+	rejections uint64
+}
+
+type KvStoreOptions struct {
+	LogPath         string        // write-ahead log location; empty disables persistence
+	LogSyncPolicy   LogSyncPolicy // when log records are flushed to disk
+	LogSyncInterval time.Duration // flush period for LogSyncInterval
+
+	SnapshotLogSize  int64         // snapshot once the log grows to this many bytes; zero disables
+	SnapshotInterval time.Duration // snapshot periodically if anything was logged; zero disables
+
+	ExpirySweepInterval time.Duration // how often the background sweeper removes expired keys
+
+	MaxMemory      int64          // bytes of keys plus values to stay within; zero for no limit
+	MaxKeys        int            // number of keys to stay within; zero for no limit
+	EvictionPolicy EvictionPolicy // how room is made once a limit is reached
+
+	TombstoneGracePeriod time.Duration // how long a deleted key's version is kept; zero for DefaultTombstoneGracePeriod
 }
 
 var ErrKeyNotFound error = errors.New("key not found")
 
+type kvItem struct {
+	value    string
+	version  uint64
+	accessed int64  // unix nanos of the last read or write
+	hits     uint32 // reads and writes, saturating
+
+	kind         ValueKind
+	hash         map[string]string
+	list         []string
+	set          map[string]struct{}
+	elementBytes int64 // bytes of fields, values and members held by a hash, list or set
+}
+
 type kvStoreRequest struct {
-	Command string
-	Key     string
-	Value   string
-	Results chan kvStoreResponse
+	Command    string
+	Key        string
+	Value      string
+	Expected   string
+	TTL        time.Duration
+	Version    uint64
+	Operations []Operation
+	Watched    map[string]uint64
+	Limit      int
+	Delta      int64
+	Field      string
+	Front      bool
+	Start      int
+	Stop       int
+	Kind       ValueKind
+	Values     []string
+	Entries    []KeyValue
+	Watcher    *kvWatcher
+	Results    chan kvStoreResponse
 }
 
 type kvStoreResponse struct {
-	Value  string
-	Values []string
-	Error  error
+	Value      string
+	Values     []string
+	TTL        time.Duration
+	Version    uint64
+	Operations []OperationResult
+	Entries    []KeyValue
+	Versions   []uint64
+	Stats      MemoryStats
+	Integer    int64
+	Found      bool
+	Kind       ValueKind
+	Error      error
 }
 
 func NewKvStore() *KvStore {
+	return NewKvStoreWithOptions(KvStoreOptions{})
+}
+
+func NewKvStoreWithOptions(options KvStoreOptions) *KvStore {
+	if options.LogSyncInterval <= 0 {
+		options.LogSyncInterval = DefaultLogSyncInterval
+	}
+	if options.ExpirySweepInterval <= 0 {
+		options.ExpirySweepInterval = DefaultExpirySweepInterval
+	}
 	return &KvStore{
-		items:    nil,
-		requests: nil,
+		items:     nil,
+		requests:  nil,
+		expired:   make(chan string, expiredKeysBufferSize),
+		unwatches: make(chan *kvWatcher),
+		options:   options,
 	}
 }
 
-func (store *KvStore) Open() {
+func (store *KvStore) Open() error {
 	if store.items == nil {
-		store.items = make(map[string]string)
+		store.items = make(map[string]*kvItem)
+		store.index = newSkiplist()
+		store.deleted = newTombstones(store.options.TombstoneGracePeriod)
+		store.revision = 0
+		store.memory = 0
+		store.watchers = make(map[*kvWatcher]struct{})
+		store.expiries = make(map[string]time.Time)
+		if store.options.LogPath != "" {
+			if err := store.loadSnapshot(store.applyWalRecord); err != nil {
+				store.items = nil
+				return err
+			}
+			log, err := openWriteAheadLog(store.options.LogPath, store.options.LogSyncPolicy, store.applyWalRecord)
+			if err != nil {
+				store.items = nil
+				return err
+			}
+			store.log = log
+		}
 		store.requests = make(chan kvStoreRequest)
+		store.stopped = make(chan struct{})
+		store.snapshotDone = make(chan error)
 		go handleRequests(store)
 	}
+	return nil
 }
 
 func (store *KvStore) Close() {
 	if store.items != nil {
-		store.items = nil
 		close(store.requests)
+		<-store.stopped
+		store.items = nil
+		store.index = nil
+		store.deleted = nil
+		store.expiries = nil
 		store.requests = nil
+		store.log = nil
 	}
 }
 
@@ -56,94 +207,352 @@ func (store *KvStore) query(request kvStoreRequest) {
 	store.requests <- request
 }
 
+// like query, but stops waiting once ctx is done. the results channel is buffered and never
+// closed so that the store can still answer a request nobody is waiting for any more...
+func (store *KvStore) queryContext(ctx context.Context, request kvStoreRequest) kvStoreResponse {
+	request.Results = make(chan kvStoreResponse, 1)
+	select {
+	case store.requests <- request:
+	case <-ctx.Done():
+		return kvStoreResponse{Error: ctx.Err()}
+	}
+	select {
+	case response := <-request.Results:
+		return response
+	case <-ctx.Done():
+		return kvStoreResponse{Error: ctx.Err()}
+	}
+}
+
 func (store *KvStore) Get(key string) (string, error) {
-	request := kvStoreRequest{
+	return store.GetContext(context.Background(), key)
+}
+
+// GetContext is Get, giving up with the context's error if it is done before the store responds.
+func (store *KvStore) GetContext(ctx context.Context, key string) (string, error) {
+	response := store.queryContext(ctx, kvStoreRequest{
 		Command: kvCommandGet,
 		Key:     key,
 		Value:   "",
-		Results: make(chan kvStoreResponse),
-	}
-	store.query(request)
-	response := <-request.Results
-	defer close(request.Results)
+	})
 	return response.Value, response.Error
 }
 
-func (store *KvStore) Upsert(key string, value string) (string, error) {
-	request := kvStoreRequest{
+// Upsert inserts or updates a key, returning its new version.
+func (store *KvStore) Upsert(key string, value string) (uint64, error) {
+	return store.UpsertContext(context.Background(), key, value)
+}
+
+// UpsertContext is Upsert, giving up with the context's error if it is done before the store
+// responds; a write that was already queued may still be applied.
+func (store *KvStore) UpsertContext(ctx context.Context, key string, value string) (uint64, error) {
+	response := store.queryContext(ctx, kvStoreRequest{
 		Command: kvCommandUpsert,
 		Key:     key,
 		Value:   value,
-		Results: make(chan kvStoreResponse),
-	}
-	store.query(request)
-	response := <-request.Results
-	defer close(request.Results)
-	return response.Value, response.Error
+	})
+	return response.Version, response.Error
 }
 
-func (store *KvStore) Delete(key string) (string, error) {
-	request := kvStoreRequest{
+// Delete removes a key, returning the version of the deletion (zero if the key didn't exist).
+func (store *KvStore) Delete(key string) (uint64, error) {
+	return store.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext is Delete, giving up with the context's error if it is done before the store
+// responds; a delete that was already queued may still be applied.
+func (store *KvStore) DeleteContext(ctx context.Context, key string) (uint64, error) {
+	response := store.queryContext(ctx, kvStoreRequest{
 		Command: kvCommandDelete,
 		Key:     key,
 		Value:   "",
+	})
+	return response.Version, response.Error
+}
+
+// Snapshot writes the whole store to disk and discards the log behind it, returning once the snapshot is complete.
+func (store *KvStore) Snapshot() error {
+	request := kvStoreRequest{
+		Command: kvCommandSnapshot,
+		Key:     "",
+		Value:   "",
 		Results: make(chan kvStoreResponse),
 	}
 	store.query(request)
 	response := <-request.Results
 	defer close(request.Results)
-	return response.Value, response.Error
+	return response.Error
 }
 
 func (store *KvStore) ListKeys() []string {
-	request := kvStoreRequest{
+	keys, _ := store.ListKeysContext(context.Background())
+	return keys
+}
+
+// ListKeysContext is ListKeys, giving up with the context's error if it is done before the store responds.
+func (store *KvStore) ListKeysContext(ctx context.Context) ([]string, error) {
+	response := store.queryContext(ctx, kvStoreRequest{
 		Command: kvCommandList,
 		Key:     "",
 		Value:   "",
-		Results: make(chan kvStoreResponse),
+	})
+	return response.Values, response.Error
+}
+
+func (store *KvStore) applyWalRecord(record walRecord) {
+	switch {
+	case record.Op == walOpUpsert && len(record.Fields) >= 2:
+		store.setItem(record.Fields[0], record.Fields[1], recordVersion(record, 2, store.revision))
+	case record.Op == walOpDelete && len(record.Fields) >= 1:
+		version := recordVersion(record, 1, store.revision)
+		store.removeItem(record.Fields[0])
+		store.deleted.add(record.Fields[0], version, time.Now())
+		store.observeVersion(version)
+	case record.Op == walOpRevision && len(record.Fields) == 1:
+		store.observeVersion(recordVersion(record, 0, store.revision))
+	case record.Op == walOpExpire && len(record.Fields) == 2:
+		if nanos, err := strconv.ParseInt(record.Fields[1], 10, 64); err == nil {
+			store.expiries[record.Fields[0]] = time.Unix(0, nanos)
+		}
+	case record.Op == walOpPersist && len(record.Fields) == 1:
+		delete(store.expiries, record.Fields[0])
+	case isStructureRecord(record.Op):
+		store.applyStructureRecord(record)
+	case record.Op == walOpBatch:
+		for _, payload := range record.Fields {
+			if nested, err := decodeWalPayload([]byte(payload)); err == nil {
+				store.applyWalRecord(nested)
+			}
+		}
 	}
-	store.query(request)
-	response := <-request.Results
-	defer close(request.Results)
-	return response.Values
+}
+
+// the primitive mutations, shared by request handling and log replay...
+func (store *KvStore) setItem(key string, value string, version uint64) {
+	item := &kvItem{value: value, version: version}
+	if previous, exists := store.items[key]; exists {
+		store.memory -= previous.size(key)
+		item.hits = previous.hits
+	} else {
+		store.index.insert(key)
+		store.deleted.remove(key)
+	}
+	item.touch(time.Now())
+	store.items[key] = item
+	store.memory += itemSize(key, value)
+	delete(store.expiries, key)
+	store.observeVersion(version)
+}
+
+func (store *KvStore) removeItem(key string) {
+	if previous, exists := store.items[key]; exists {
+		store.index.remove(key)
+		store.memory -= previous.size(key)
+	}
+	delete(store.items, key)
+	delete(store.expiries, key)
+}
+
+// puts back an item exactly as it was, after removeItem...
+func (store *KvStore) restoreItem(key string, item *kvItem) {
+	store.index.insert(key)
+	store.items[key] = item
+	store.memory += item.size(key)
+}
+
+func (store *KvStore) upsert(key string, value string) (uint64, error) {
+	previous, exists := store.items[key]
+	if _, volatile := store.expiries[key]; exists && !volatile && previous.kind == KindString && previous.value == value {
+		return previous.version, nil
+	}
+	if err := store.makeRoom(key, value); err != nil {
+		return 0, err
+	}
+	return store.upsertVersion(key, value, nextVersion(store.revision, time.Now()))
+}
+
+func (store *KvStore) upsertVersion(key string, value string, version uint64) (uint64, error) {
+	if err := store.writeAhead(walOpUpsert, key, value, formatVersion(version)); err != nil {
+		return 0, err
+	}
+	oldValue := ""
+	if previous, exists := store.items[key]; exists {
+		oldValue = previous.value
+	}
+	store.setItem(key, value, version)
+	store.publish(ChangeEvent{Op: ChangeUpsert, Key: key, OldValue: oldValue, NewValue: value, Version: version})
+	return version, nil
+}
+
+func (store *KvStore) delete(key string) (uint64, error) {
+	return store.remove(key, ChangeDelete)
+}
+
+// deletes a key, telling watchers why...
+func (store *KvStore) remove(key string, op ChangeOp) (uint64, error) {
+	previous, exists := store.items[key]
+	if !exists {
+		return 0, nil
+	}
+	version := nextVersion(store.revision, time.Now())
+	if err := store.writeAhead(walOpDelete, key, formatVersion(version)); err != nil {
+		return 0, err
+	}
+	store.removeItem(key)
+	store.deleted.add(key, version, time.Now())
+	store.observeVersion(version)
+	store.publish(ChangeEvent{Op: op, Key: key, OldValue: previous.value, Version: version})
+	return version, nil
+}
+
+// appends a record to the write-ahead log, if there is one...
+func (store *KvStore) writeAhead(op byte, fields ...string) error {
+	if store.log == nil {
+		return nil
+	}
+	if store.batch != nil {
+		store.batch.add(store, walRecord{Op: op, Fields: fields})
+		return nil
+	}
+	return store.log.append(walRecord{Op: op, Fields: fields})
 }
 
 func handleRequests(store *KvStore) {
-	for {
-		request, isOpen := <-store.requests
-		if !isOpen {
-			return
+	defer close(store.stopped)
+
+	var syncTicks <-chan time.Time
+	var snapshotTicks <-chan time.Time
+	sweepTicker := time.NewTicker(store.options.ExpirySweepInterval)
+	defer sweepTicker.Stop()
+	if store.log != nil {
+		defer func() { _ = store.log.close() }()
+		if store.options.LogSyncPolicy == LogSyncInterval {
+			ticker := time.NewTicker(store.options.LogSyncInterval)
+			defer ticker.Stop()
+			syncTicks = ticker.C
 		}
-		response := kvStoreResponse{
-			Value:  "",
-			Values: nil,
-			Error:  nil,
+		if store.options.SnapshotInterval > 0 {
+			ticker := time.NewTicker(store.options.SnapshotInterval)
+			defer ticker.Stop()
+			snapshotTicks = ticker.C
 		}
-		switch request.Command {
-		case kvCommandUpsert:
-			previous, exists := store.items[request.Key]
-			if !exists {
-				store.items[request.Key] = request.Value
-			} else {
-				if previous != request.Value {
-					store.items[request.Key] = request.Value
+	}
+
+	for {
+		select {
+		case request, isOpen := <-store.requests:
+			if !isOpen {
+				if store.snapshotting {
+					store.finishSnapshot(<-store.snapshotDone)
 				}
+				store.unwatchAll()
+				return
+			}
+			if request.Command == kvCommandSnapshot {
+				store.startSnapshot(request.Results)
+				continue
 			}
-		case kvCommandGet:
-			value, exists := store.items[request.Key]
-			if !exists {
-				response.Error = ErrKeyNotFound
-			} else {
-				response.Value = value
+			request.Results <- store.handleRequest(request)
+			if store.snapshotIsDue() {
+				store.startSnapshot(nil)
 			}
-		case kvCommandDelete:
-			delete(store.items, request.Key)
-		case kvCommandList:
-			response.Values = make([]string, 0)
-			for k := range store.items {
-				response.Values = append(response.Values, k)
+		case watcher := <-store.unwatches:
+			store.unwatch(watcher)
+		case err := <-store.snapshotDone:
+			store.finishSnapshot(err)
+		case <-syncTicks:
+			if err := store.log.sync(); err != nil {
+				fmt.Printf("store: error syncing log '%s'\n", err.Error())
+			}
+		case <-snapshotTicks:
+			if !store.snapshotting && store.log.size > 0 {
+				store.startSnapshot(nil)
+			}
+		case <-sweepTicker.C:
+			store.sweepExpired()
+		}
+	}
+}
+
+func (store *KvStore) handleRequest(request kvStoreRequest) kvStoreResponse {
+	response := kvStoreResponse{
+		Value:  "",
+		Values: nil,
+		Error:  nil,
+	}
+	if request.Key != "" {
+		store.expireIfDue(request.Key, time.Now())
+	}
+	switch request.Command {
+	case kvCommandUpsert:
+		response.Version, response.Error = store.upsert(request.Key, request.Value)
+	case kvCommandGet, kvCommandGetWithVersion:
+		item, exists := store.items[request.Key]
+		if !exists {
+			response.Version = store.deleted.version(request.Key)
+			response.Error = ErrKeyNotFound
+		} else if item.kind != KindString {
+			response.Error = ErrWrongType
+		} else {
+			item.touch(time.Now())
+			response.Value = item.value
+			response.Version = item.version
+		}
+	case kvCommandDelete:
+		response.Version, response.Error = store.delete(request.Key)
+	case kvCommandList:
+		response.Values = make([]string, 0)
+		now := time.Now()
+		for node := store.index.first(); node != nil; node = node.next[0] {
+			if !store.isExpired(node.key, now) {
+				response.Values = append(response.Values, node.key)
 			}
 		}
-		request.Results <- response
+	case kvCommandUpsertWithTTL:
+		response.Version, response.Error = store.upsertWithTTL(request.Key, request.Value, request.TTL)
+	case kvCommandExpire:
+		response.Error = store.expire(request.Key, request.TTL)
+	case kvCommandTTL:
+		response.TTL, response.Error = store.ttl(request.Key)
+	case kvCommandPersist:
+		response.Error = store.persist(request.Key)
+	case kvCommandCompareAndSwap:
+		response.Version, response.Error = store.compareAndSwap(request.Key, request.Expected, request.Value)
+	case kvCommandPutIfAbsent:
+		response.Version, response.Error = store.putIfAbsent(request.Key, request.Value)
+	case kvCommandDeleteIfEquals:
+		response.Version, response.Error = store.deleteIfEquals(request.Key, request.Expected)
+	case kvCommandUpsertVersion:
+		response.Version, response.Error = store.upsertReplicated(request.Key, request.Value, request.Version)
+	case kvCommandDeleteVersion:
+		response.Version, response.Error = store.deleteReplicated(request.Key, request.Version)
+	case kvCommandTransact:
+		response.Operations, response.Error = store.transact(request.Operations, request.Watched)
+	case kvCommandScan:
+		response.Entries = store.scan(request.Key, request.Value, request.Limit)
+	case kvCommandMemoryStats:
+		response.Stats = store.memoryStats()
+	case kvCommandWatch:
+		store.watchers[request.Watcher] = struct{}{}
+	case kvCommandIncrement:
+		response.Integer, response.Version, response.Error = store.incrementBy(request.Key, request.Delta)
+	case kvCommandGetMany:
+		response.Operations = store.getMany(request.Values)
+	case kvCommandUpsertMany:
+		response.Versions, response.Error = store.upsertMany(request.Entries)
+	case kvCommandAppend:
+		response.Integer, response.Version, response.Error = store.append(request.Key, request.Value)
+	case kvCommandGetRange:
+		response.Value, response.Error = store.getRange(request.Key, request.Start, request.Limit)
+	case kvCommandSetRange:
+		response.Integer, response.Version, response.Error = store.setRange(request.Key, request.Start, request.Value)
+	case kvCommandStrlen:
+		response.Integer, response.Error = store.strlen(request.Key)
+	case kvCommandHashSet, kvCommandHashGet, kvCommandHashDelete, kvCommandHashGetAll,
+		kvCommandListPush, kvCommandListPop, kvCommandListRange,
+		kvCommandSetAdd, kvCommandSetRemove, kvCommandSetMembers, kvCommandSetIsMember,
+		kvCommandGetStructure, kvCommandUpsertStructure:
+		store.handleStructureRequest(request, &response)
 	}
+	return response
 }
@@ -1,8 +1,10 @@
 package kvstore_test
 
 import (
+	"fmt"
 	"kvsapp/assertions"
 	"kvsapp/kvstore"
+	"sync/atomic"
 	"testing"
 )
 
@@ -96,7 +98,90 @@ func TestDeleteReturnsNoErrorOnUnknownKey(t *testing.T) {
 	defer store.Close()
 
 	expectedKey := "TestGetReturnsErrorOnUnknownKey"
-	actualValue, err := store.Delete(expectedKey)
-	assert.String("value", "", actualValue)
+	actualVersion, err := store.Delete(expectedKey)
+	assert.Uint64("version", 0, actualVersion)
 	assert.Error(nil, err)
 }
+
+// benchmarks comparing the single-goroutine store with the sharded one, run with -cpu to vary contention...
+const benchmarkKeyCount int = 1024
+
+func benchmarkKeys() []string {
+	keys := make([]string, benchmarkKeyCount)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+	return keys
+}
+
+func benchmarkStores() map[string]func() kvstore.Store {
+	return map[string]func() kvstore.Store{
+		"KvStore":      func() kvstore.Store { return kvstore.NewKvStore() },
+		"ShardedStore": func() kvstore.Store { return kvstore.NewShardedStore(kvstore.DefaultShardCount) },
+	}
+}
+
+func BenchmarkUpsertParallel(b *testing.B) {
+	keys := benchmarkKeys()
+	for name, create := range benchmarkStores() {
+		b.Run(name, func(b *testing.B) {
+			store := create()
+			store.Open()
+			defer store.Close()
+			var counter uint64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := atomic.AddUint64(&counter, 1)
+					store.Upsert(keys[i%uint64(len(keys))], fmt.Sprintf("%d", i))
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkGetParallel(b *testing.B) {
+	keys := benchmarkKeys()
+	for name, create := range benchmarkStores() {
+		b.Run(name, func(b *testing.B) {
+			store := create()
+			store.Open()
+			defer store.Close()
+			for _, key := range keys {
+				store.Upsert(key, "value")
+			}
+			var counter uint64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := atomic.AddUint64(&counter, 1)
+					store.Get(keys[i%uint64(len(keys))])
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkMixedParallel(b *testing.B) {
+	keys := benchmarkKeys()
+	for name, create := range benchmarkStores() {
+		b.Run(name, func(b *testing.B) {
+			store := create()
+			store.Open()
+			defer store.Close()
+			var counter uint64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := atomic.AddUint64(&counter, 1)
+					key := keys[i%uint64(len(keys))]
+					if i%10 == 0 {
+						store.Upsert(key, "value")
+					} else {
+						store.Get(key)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package kvstore
+
+import "time"
+
+// GetMany reads several keys as one request, returning a result for each key in the order
+// given: the value and version, or ErrKeyNotFound (or ErrWrongType) as its Error.
+func (store *KvStore) GetMany(keys []string) ([]OperationResult, error) {
+	request := kvStoreRequest{
+		Command: kvCommandGetMany,
+		Key:     "",
+		Value:   "",
+		Values:  keys,
+		Results: make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return response.Operations, response.Error
+}
+
+// UpsertMany writes several keys as one request, all or none of them: room is made for every
+// value before any is written (evicting none of the keys being written), and the writes are
+// logged as a single record. It returns the version of each entry in the order given; where a
+// key appears more than once the last value wins, and every entry for it gets that version.
+func (store *KvStore) UpsertMany(entries []KeyValue) ([]uint64, error) {
+	request := kvStoreRequest{
+		Command: kvCommandUpsertMany,
+		Key:     "",
+		Value:   "",
+		Entries: entries,
+		Results: make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return response.Versions, response.Error
+}
+
+func (store *KvStore) getMany(keys []string) []OperationResult {
+	now := time.Now()
+	results := make([]OperationResult, len(keys))
+	for i, key := range keys {
+		store.expireIfDue(key, now)
+		item, exists := store.items[key]
+		switch {
+		case !exists:
+			results[i].Error = ErrKeyNotFound
+		case item.kind != KindString:
+			results[i].Error = ErrWrongType
+		default:
+			item.touch(now)
+			results[i].Value, results[i].Version = item.value, item.version
+		}
+	}
+	return results
+}
+
+func (store *KvStore) upsertMany(entries []KeyValue) ([]uint64, error) {
+	now := time.Now()
+	latest := make(map[string]string, len(entries))
+	order := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if _, seen := latest[entry.Key]; !seen {
+			store.expireIfDue(entry.Key, now)
+			order = append(order, entry.Key)
+		}
+		latest[entry.Key] = entry.Value
+	}
+	if err := store.makeRoomForMany(latest); err != nil {
+		return nil, err
+	}
+
+	store.beginBatch()
+	written := make(map[string]uint64, len(order))
+	for _, key := range order {
+		version, err := store.upsert(key, latest[key])
+		if err != nil {
+			store.abortBatch()
+			return nil, err
+		}
+		written[key] = version
+	}
+	if err := store.commitBatch(); err != nil {
+		return nil, err
+	}
+	versions := make([]uint64, len(entries))
+	for i, entry := range entries {
+		versions[i] = written[entry.Key]
+	}
+	return versions, nil
+}
+
+// makes room for every value at once, so that writing one can't evict another...
+func (store *KvStore) makeRoomForMany(values map[string]string) error {
+	limits := store.options
+	if limits.MaxMemory <= 0 && limits.MaxKeys <= 0 {
+		return nil
+	}
+	if limits.MaxKeys > 0 && len(values) > limits.MaxKeys {
+		store.rejections++
+		return ErrOutOfMemory
+	}
+	exclude := make(map[string]struct{}, len(values))
+	size, growth, added := int64(0), int64(0), 0
+	for key, value := range values {
+		exclude[key] = struct{}{}
+		size += itemSize(key, value)
+		growth += itemSize(key, value)
+		if previous, exists := store.items[key]; exists {
+			growth -= previous.size(key)
+		} else {
+			added++
+		}
+	}
+	return store.makeRoomExcluding(exclude, size, growth, added)
+}
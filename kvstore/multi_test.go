@@ -0,0 +1,89 @@
+package kvstore_test
+
+import (
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetMany(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	version, _ := store.Upsert("a", "1")
+	store.Upsert("b", "2")
+	store.SetAdd("tags", "red")
+
+	results, err := store.GetMany([]string{"a", "missing", "b", "tags"})
+	assert.Error(nil, err)
+	assert.Int("results", 4, len(results))
+	assert.String("a", "1", results[0].Value)
+	assert.Uint64("a version", version, results[0].Version)
+	assert.Error(kvstore.ErrKeyNotFound, results[1].Error)
+	assert.String("b", "2", results[2].Value)
+	assert.Error(kvstore.ErrWrongType, results[3].Error)
+}
+
+func TestUpsertMany(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	versions, err := store.UpsertMany([]kvstore.KeyValue{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}, {Key: "a", Value: "3"}})
+	assert.Error(nil, err)
+	assert.Int("versions", 3, len(versions))
+	assert.Uint64("same key, same version", versions[0], versions[2])
+	value, _ := store.Get("a")
+	assert.String("last value wins", "3", value)
+	_, actualVersion, _ := store.GetWithVersion("b")
+	assert.Uint64("b version", versions[1], actualVersion)
+}
+
+func TestUpsertManyIsAllOrNothingWhenOutOfMemory(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := kvstore.NewKvStoreWithOptions(kvstore.KvStoreOptions{MaxKeys: 2, EvictionPolicy: kvstore.EvictionAllKeysLRU})
+	store.Open()
+	defer store.Close()
+
+	store.Upsert("old", "x")
+	_, err := store.UpsertMany([]kvstore.KeyValue{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}, {Key: "c", Value: "3"}})
+	assert.Error(kvstore.ErrOutOfMemory, err)
+	assert.Int("keys", 1, len(store.ListKeys()))
+
+	// room is made for both by evicting other keys, never one of the batch...
+	_, err = store.UpsertMany([]kvstore.KeyValue{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}})
+	assert.Error(nil, err)
+	for _, key := range []string{"a", "b"} {
+		_, err := store.Get(key)
+		assert.Error(nil, err)
+	}
+	_, err = store.Get("old")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+}
+
+func TestUpsertManyRestoresFromLog(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	path := filepath.Join(t.TempDir(), "store.wal")
+
+	store := createLoggedTestObject(path, kvstore.LogSyncAlways)
+	if err := store.Open(); err != nil {
+		t.Fatalf("test setup failure (open): %s", err.Error())
+	}
+	store.UpsertMany([]kvstore.KeyValue{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}})
+	store.Close()
+
+	store = createLoggedTestObject(path, kvstore.LogSyncAlways)
+	assert.Error(nil, store.Open())
+	defer store.Close()
+	results, _ := store.GetMany([]string{"a", "b"})
+	assert.String("a", "1", results[0].Value)
+	assert.String("b", "2", results[1].Value)
+}
@@ -0,0 +1,62 @@
+package kvstore
+
+import "time"
+
+// KeyValue is a single entry returned by a scan.
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+// Scan returns up to limit entries, in lexical key order, whose keys are at or after start
+// and before end. An empty end means no upper bound; a limit of zero or less means no limit.
+func (store *KvStore) Scan(start string, end string, limit int) ([]KeyValue, error) {
+	request := kvStoreRequest{
+		Command: kvCommandScan,
+		Key:     start,
+		Value:   end,
+		Limit:   limit,
+		Results: make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return response.Entries, response.Error
+}
+
+// ScanPrefix returns up to limit entries, in lexical key order, whose keys start with prefix.
+func (store *KvStore) ScanPrefix(prefix string, limit int) ([]KeyValue, error) {
+	return store.Scan(prefix, PrefixEnd(prefix), limit)
+}
+
+// PrefixEnd returns the first key after every key starting with prefix, or an empty string if there is none.
+func PrefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for len(end) > 0 {
+		last := len(end) - 1
+		if end[last] < 0xff {
+			end[last]++
+			return string(end)
+		}
+		end = end[:last]
+	}
+	return ""
+}
+
+func (store *KvStore) scan(start string, end string, limit int) []KeyValue {
+	result := make([]KeyValue, 0)
+	now := time.Now()
+	for node := store.index.seek(start); node != nil; node = node.next[0] {
+		if end != "" && node.key >= end {
+			break
+		}
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+		if store.isExpired(node.key, now) || store.items[node.key].kind != KindString {
+			continue
+		}
+		result = append(result, KeyValue{Key: node.key, Value: store.items[node.key].value})
+	}
+	return result
+}
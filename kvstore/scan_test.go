@@ -0,0 +1,106 @@
+package kvstore_test
+
+import (
+	"fmt"
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"testing"
+	"time"
+)
+
+func TestScanReturnsEntriesInKeyOrder(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	for _, key := range []string{"d", "b", "e", "a", "c"} {
+		store.Upsert(key, "value-"+key)
+	}
+	store.Delete("c")
+
+	entries, err := store.Scan("b", "e", 0)
+	assert.Error(nil, err)
+	assert.Int("count", 2, len(entries))
+	assert.String("key", "b", entries[0].Key)
+	assert.String("value", "value-b", entries[0].Value)
+	assert.String("key", "d", entries[1].Key)
+
+	entries, _ = store.Scan("", "", 3)
+	assert.Int("count", 3, len(entries))
+	assert.String("key", "a", entries[0].Key)
+	assert.String("key", "d", entries[2].Key)
+}
+
+func TestScanPrefix(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	for i := 0; i < 20; i++ {
+		store.Upsert(fmt.Sprintf("user:%02d", i), "u")
+		store.Upsert(fmt.Sprintf("group:%02d", i), "g")
+	}
+	store.Upsert("user", "not in prefix")
+	store.Upsert("users", "in prefix")
+
+	entries, _ := store.ScanPrefix("user:", 0)
+	assert.Int("count", 20, len(entries))
+	assert.String("first", "user:00", entries[0].Key)
+	assert.String("last", "user:19", entries[19].Key)
+
+	entries, _ = store.ScanPrefix("group:1", 5)
+	assert.Int("count", 5, len(entries))
+	assert.String("first", "group:10", entries[0].Key)
+}
+
+func TestScanSkipsExpiredKeys(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	store.Upsert("a", "1")
+	store.UpsertWithTTL("b", "2", time.Millisecond)
+	store.Upsert("c", "3")
+	time.Sleep(5 * time.Millisecond)
+
+	entries, _ := store.ScanPrefix("", 0)
+	assert.Int("count", 2, len(entries))
+	assert.String("key", "c", entries[1].Key)
+}
+
+func TestScanAfterReplay(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	path := t.TempDir() + "/store.wal"
+
+	store := createLoggedTestObject(path, kvstore.LogSyncAlways)
+	store.Open()
+	store.Upsert("b", "2")
+	store.Upsert("a", "1")
+	store.Upsert("c", "3")
+	store.Delete("b")
+	store.Close()
+
+	store = createLoggedTestObject(path, kvstore.LogSyncAlways)
+	store.Open()
+	defer store.Close()
+	entries, _ := store.Scan("", "", 0)
+	assert.Int("count", 2, len(entries))
+	assert.String("key", "a", entries[0].Key)
+	assert.String("key", "c", entries[1].Key)
+}
+
+func TestPrefixEnd(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	assert.String("end", "abd", kvstore.PrefixEnd("abc"))
+	assert.String("end", "b", kvstore.PrefixEnd("a\xff\xff"))
+	assert.String("end", "", kvstore.PrefixEnd("\xff"))
+	assert.String("end", "", kvstore.PrefixEnd(""))
+}
@@ -0,0 +1,364 @@
+package kvstore
+
+import (
+	"container/heap"
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultShardCount int = 32
+
+// ShardedStore is an in-memory store that spreads keys across a fixed number of shards by hash,
+// each guarded by its own lock, so requests for different shards never wait on each other. It
+// has no log, expiry or transactions. Each shard keeps its own hybrid logical clock, like the one
+// in KvStore, so writes to different shards don't contend on it; as a key never moves between
+// shards its versions still only ever increase.
+type ShardedStore struct {
+	shards []*kvShard
+	opened int32
+}
+
+type kvShard struct {
+	lock     sync.RWMutex
+	items    map[string]*kvItem
+	index    *skiplist
+	deleted  *tombstones
+	revision uint64
+}
+
+var _ Store = (*ShardedStore)(nil)
+var _ ConditionalStore = (*ShardedStore)(nil)
+var _ VersionedStore = (*ShardedStore)(nil)
+var _ ScanningStore = (*ShardedStore)(nil)
+var _ BinaryStore = (*ShardedStore)(nil)
+
+// NewShardedStore creates a store with the given number of shards, or DefaultShardCount if that is zero or less.
+func NewShardedStore(shardCount int) *ShardedStore {
+	if shardCount <= 0 {
+		shardCount = DefaultShardCount
+	}
+	store := &ShardedStore{shards: make([]*kvShard, shardCount)}
+	for i := range store.shards {
+		store.shards[i] = &kvShard{}
+	}
+	return store
+}
+
+func (store *ShardedStore) Open() error {
+	if atomic.CompareAndSwapInt32(&store.opened, 0, 1) {
+		for _, shard := range store.shards {
+			shard.lock.Lock()
+			shard.items = make(map[string]*kvItem)
+			shard.index = newSkiplist()
+			shard.deleted = newTombstones(DefaultTombstoneGracePeriod)
+			shard.revision = 0
+			shard.lock.Unlock()
+		}
+	}
+	return nil
+}
+
+func (store *ShardedStore) Close() {
+	if atomic.CompareAndSwapInt32(&store.opened, 1, 0) {
+		for _, shard := range store.shards {
+			shard.lock.Lock()
+			shard.items = nil
+			shard.index = nil
+			shard.deleted = nil
+			shard.lock.Unlock()
+		}
+	}
+}
+
+func (store *ShardedStore) shardFor(key string) *kvShard {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return store.shards[hash.Sum32()%uint32(len(store.shards))]
+}
+
+func (store *ShardedStore) Get(key string) (string, error) {
+	return store.GetContext(context.Background(), key)
+}
+
+func (store *ShardedStore) GetContext(ctx context.Context, key string) (string, error) {
+	value, _, err := store.getWithVersion(ctx, key)
+	return value, err
+}
+
+// GetWithVersion returns the value of a key along with its current version. For a key that has
+// been deleted recently it returns ErrKeyNotFound along with the version of the deletion.
+func (store *ShardedStore) GetWithVersion(key string) (string, uint64, error) {
+	return store.getWithVersion(context.Background(), key)
+}
+
+func (store *ShardedStore) getWithVersion(ctx context.Context, key string) (string, uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return "", 0, err
+	}
+	shard := store.shardFor(key)
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	if shard.items == nil {
+		return "", 0, ErrStoreClosed
+	}
+	item, exists := shard.items[key]
+	if !exists {
+		return "", shard.deleted.version(key), ErrKeyNotFound
+	}
+	return item.value, item.version, nil
+}
+
+// Upsert inserts or updates a key, returning its new version.
+func (store *ShardedStore) Upsert(key string, value string) (uint64, error) {
+	return store.UpsertContext(context.Background(), key, value)
+}
+
+func (store *ShardedStore) UpsertContext(ctx context.Context, key string, value string) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	shard := store.shardFor(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if shard.items == nil {
+		return 0, ErrStoreClosed
+	}
+	if previous, exists := shard.items[key]; exists && previous.value == value {
+		return previous.version, nil
+	}
+	return shard.set(key, value, shard.advance()), nil
+}
+
+// UpsertVersion applies a write made elsewhere in the cluster, keeping the version it was given
+// there; it is ignored with ErrStaleVersion if the key already has a newer version, or the same
+// version and a value that sorts at or after the one given.
+func (store *ShardedStore) UpsertVersion(key string, value string, version uint64) (uint64, error) {
+	shard := store.shardFor(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if shard.items == nil {
+		return 0, ErrStoreClosed
+	}
+	if current, exists := shard.items[key]; exists && !supersedes(version, value, current.version, current.value) {
+		return current.version, ErrStaleVersion
+	}
+	if deleted := shard.deleted.version(key); deleted > 0 && !supersedes(version, value, deleted, "") {
+		return deleted, ErrStaleVersion
+	}
+	shard.observeVersion(version)
+	return shard.set(key, value, version), nil
+}
+
+// DeleteVersion applies a delete made elsewhere in the cluster, keeping the version it was given
+// there; it is ignored with ErrStaleVersion if the key already has a write at or after that
+// version. The key is remembered as deleted, so that older writes arriving later are ignored too.
+func (store *ShardedStore) DeleteVersion(key string, version uint64) (uint64, error) {
+	shard := store.shardFor(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if shard.items == nil {
+		return 0, ErrStoreClosed
+	}
+	if current, exists := shard.items[key]; exists && !deleteSupersedes(version, current.version) {
+		return current.version, ErrStaleVersion
+	}
+	if deleted := shard.deleted.version(key); deleted >= version {
+		return deleted, ErrStaleVersion
+	}
+	shard.observeVersion(version)
+	return shard.remove(key, version), nil
+}
+
+// Delete removes a key, returning the version of the deletion (zero if the key didn't exist).
+func (store *ShardedStore) Delete(key string) (uint64, error) {
+	return store.DeleteContext(context.Background(), key)
+}
+
+func (store *ShardedStore) DeleteContext(ctx context.Context, key string) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	shard := store.shardFor(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if shard.items == nil {
+		return 0, ErrStoreClosed
+	}
+	if _, exists := shard.items[key]; !exists {
+		return 0, nil
+	}
+	return shard.remove(key, shard.advance()), nil
+}
+
+// CompareAndSwap sets a key to value only if it currently holds expected.
+func (store *ShardedStore) CompareAndSwap(key string, expected string, value string) (uint64, error) {
+	shard := store.shardFor(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if shard.items == nil {
+		return 0, ErrStoreClosed
+	}
+	current, exists := shard.items[key]
+	if !exists {
+		return 0, ErrKeyNotFound
+	}
+	if current.value != expected {
+		return 0, ErrValueMismatch
+	}
+	return shard.set(key, value, shard.advance()), nil
+}
+
+// PutIfAbsent sets a key only if it doesn't already exist.
+func (store *ShardedStore) PutIfAbsent(key string, value string) (uint64, error) {
+	shard := store.shardFor(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if shard.items == nil {
+		return 0, ErrStoreClosed
+	}
+	if _, exists := shard.items[key]; exists {
+		return 0, ErrKeyExists
+	}
+	return shard.set(key, value, shard.advance()), nil
+}
+
+// DeleteIfEquals removes a key only if it currently holds expected.
+func (store *ShardedStore) DeleteIfEquals(key string, expected string) (uint64, error) {
+	shard := store.shardFor(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if shard.items == nil {
+		return 0, ErrStoreClosed
+	}
+	current, exists := shard.items[key]
+	if !exists {
+		return 0, ErrKeyNotFound
+	}
+	if current.value != expected {
+		return 0, ErrValueMismatch
+	}
+	return shard.remove(key, shard.advance()), nil
+}
+
+// moves the shard's clock on, returning the version for a write; the shard's lock is held...
+func (shard *kvShard) advance() uint64 {
+	shard.revision = nextVersion(shard.revision, time.Now())
+	return shard.revision
+}
+
+// raises the shard's revision to at least version...
+func (shard *kvShard) observeVersion(version uint64) {
+	if version > shard.revision {
+		shard.revision = version
+	}
+}
+
+func (shard *kvShard) set(key string, value string, version uint64) uint64 {
+	if _, exists := shard.items[key]; !exists {
+		shard.index.insert(key)
+	}
+	shard.items[key] = &kvItem{value: value, version: version}
+	shard.deleted.remove(key)
+	return version
+}
+
+func (shard *kvShard) remove(key string, version uint64) uint64 {
+	delete(shard.items, key)
+	shard.index.remove(key)
+	shard.deleted.add(key, version, time.Now())
+	return version
+}
+
+func (store *ShardedStore) ListKeys() []string {
+	keys, _ := store.ListKeysContext(context.Background())
+	return keys
+}
+
+func (store *ShardedStore) ListKeysContext(ctx context.Context) ([]string, error) {
+	entries, err := store.scan(ctx, "", "", 0, false)
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.Key
+	}
+	return keys, err
+}
+
+// Scan returns up to limit entries, in lexical key order, whose keys are at or after start
+// and before end. An empty end means no upper bound; a limit of zero or less means no limit.
+// Each shard is read in turn, so the result is not a point-in-time view of the whole store.
+func (store *ShardedStore) Scan(start string, end string, limit int) ([]KeyValue, error) {
+	return store.scan(context.Background(), start, end, limit, true)
+}
+
+// takes up to limit entries from each shard, already in order from its index, then merges them...
+func (store *ShardedStore) scan(ctx context.Context, start string, end string, limit int, withValues bool) ([]KeyValue, error) {
+	runs := make(kvRuns, 0, len(store.shards))
+	for _, shard := range store.shards {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		shard.lock.RLock()
+		if shard.index != nil {
+			if run := shard.scan(start, end, limit, withValues); len(run) > 0 {
+				runs = append(runs, run)
+			}
+		}
+		shard.lock.RUnlock()
+	}
+	return runs.merge(limit), nil
+}
+
+func (shard *kvShard) scan(start string, end string, limit int, withValues bool) []KeyValue {
+	run := make([]KeyValue, 0)
+	for node := shard.index.seek(start); node != nil; node = node.next[0] {
+		if (end != "" && node.key >= end) || (limit > 0 && len(run) >= limit) {
+			break
+		}
+		entry := KeyValue{Key: node.key}
+		if withValues {
+			entry.Value = shard.items[node.key].value
+		}
+		run = append(run, entry)
+	}
+	return run
+}
+
+// the entries taken from each shard, as a heap ordered by the first key of each...
+type kvRuns [][]KeyValue
+
+func (runs kvRuns) Len() int            { return len(runs) }
+func (runs kvRuns) Less(i, j int) bool  { return runs[i][0].Key < runs[j][0].Key }
+func (runs kvRuns) Swap(i, j int)       { runs[i], runs[j] = runs[j], runs[i] }
+func (runs *kvRuns) Push(x interface{}) { *runs = append(*runs, x.([]KeyValue)) }
+func (runs *kvRuns) Pop() interface{} {
+	last := (*runs)[len(*runs)-1]
+	*runs = (*runs)[:len(*runs)-1]
+	return last
+}
+
+// merges the runs into one in key order, stopping at limit; a key is only ever in one shard...
+func (runs kvRuns) merge(limit int) []KeyValue {
+	total := 0
+	for _, run := range runs {
+		total += len(run)
+	}
+	if limit > 0 && total > limit {
+		total = limit
+	}
+	result := make([]KeyValue, 0, total)
+	heap.Init(&runs)
+	for len(runs) > 0 && len(result) < total {
+		run := runs[0]
+		result = append(result, run[0])
+		if len(run) == 1 {
+			heap.Pop(&runs)
+		} else {
+			runs[0] = run[1:]
+			heap.Fix(&runs, 0)
+		}
+	}
+	return result
+}
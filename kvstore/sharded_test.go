@@ -0,0 +1,163 @@
+package kvstore_test
+
+import (
+	"fmt"
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestShardedStoreGetUpsertDelete(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := kvstore.NewShardedStore(4)
+	store.Open()
+	defer store.Close()
+
+	_, err := store.Get("key")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+	version1, _ := store.Upsert("key", "value1")
+	assert.True("version1 > 0", version1 > 0)
+	unchanged, _ := store.Upsert("key", "value1")
+	assert.Uint64("unchanged", version1, unchanged)
+	version2, _ := store.Upsert("key", "value2")
+	assert.True("version2 > version1", version2 > version1)
+	actualValue, err := store.Get("key")
+	assert.Error(nil, err)
+	assert.String("value", "value2", actualValue)
+
+	deleted, _ := store.Delete("key")
+	assert.True("deleted > version2", deleted > version2)
+	version, _ := store.Delete("key")
+	assert.Uint64("version", 0, version)
+	_, err = store.Get("key")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+}
+
+func TestShardedStoreConditionalWrites(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := kvstore.NewShardedStore(4)
+	store.Open()
+	defer store.Close()
+
+	_, err := store.CompareAndSwap("key", "old", "new")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+	_, err = store.PutIfAbsent("key", "old")
+	assert.Error(nil, err)
+	_, err = store.PutIfAbsent("key", "other")
+	assert.Error(kvstore.ErrKeyExists, err)
+	_, err = store.CompareAndSwap("key", "other", "new")
+	assert.Error(kvstore.ErrValueMismatch, err)
+	_, err = store.CompareAndSwap("key", "old", "new")
+	assert.Error(nil, err)
+	_, err = store.DeleteIfEquals("key", "old")
+	assert.Error(kvstore.ErrValueMismatch, err)
+	_, err = store.DeleteIfEquals("key", "new")
+	assert.Error(nil, err)
+
+	_, err = store.UpsertVersion("key", "remote", remoteVersion)
+	assert.Error(nil, err)
+	_, err = store.UpsertVersion("key", "stale", remoteVersion-1)
+	assert.Error(kvstore.ErrStaleVersion, err)
+	// local writes to the key carry on from the highest version its shard has seen...
+	version, _ := store.Upsert("key", "local")
+	assert.Uint64("version", remoteVersion+1, version)
+}
+
+func TestShardedStoreConcurrentWritesToAKeyGetDistinctVersions(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := kvstore.NewShardedStore(8)
+	store.Open()
+	defer store.Close()
+
+	const writers = 8
+	const writesPerWriter = 100
+	versions := make(chan uint64, writers*writesPerWriter)
+	var group sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		group.Add(1)
+		go func(w int) {
+			defer group.Done()
+			for i := 0; i < writesPerWriter; i++ {
+				version, _ := store.Upsert("key", fmt.Sprintf("value-%d-%d", w, i))
+				versions <- version
+			}
+		}(w)
+	}
+	group.Wait()
+	close(versions)
+
+	seen := make(map[uint64]bool)
+	for version := range versions {
+		assert.False("duplicate version", seen[version])
+		seen[version] = true
+	}
+	_, latest, _ := store.GetWithVersion("key")
+	for version := range seen {
+		assert.True("latest >= version", latest >= version)
+	}
+}
+
+func TestShardedStoreListKeysIsOrdered(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := kvstore.NewShardedStore(8)
+	store.Open()
+	defer store.Close()
+
+	for i := 999; i >= 0; i-- {
+		store.Upsert(fmt.Sprintf("key-%03d", i), "value")
+	}
+	store.Delete("key-500")
+	keys := store.ListKeys()
+	assert.Int("keys", 999, len(keys))
+	assert.True("sorted", sort.StringsAreSorted(keys))
+	assert.String("first", "key-000", keys[0])
+	assert.String("last", "key-999", keys[len(keys)-1])
+
+	entries, err := store.Scan("key-498", "", 3)
+	assert.Error(nil, err)
+	assert.Int("count", 3, len(entries))
+	assert.String("key", "key-501", entries[2].Key)
+}
+
+func TestShardedStoreScanIsOrdered(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := kvstore.NewShardedStore(4)
+	store.Open()
+	defer store.Close()
+
+	for i := 0; i < 50; i++ {
+		store.Upsert(fmt.Sprintf("key-%02d", i), "value")
+	}
+	entries, err := store.Scan("key-10", "key-20", 5)
+	assert.Error(nil, err)
+	assert.Int("count", 5, len(entries))
+	for i, entry := range entries {
+		assert.String("key", fmt.Sprintf("key-%02d", 10+i), entry.Key)
+	}
+}
+
+func TestShardedStoreLateWritesDoNotResurrectDeletedKeys(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := kvstore.NewShardedStore(4)
+	store.Open()
+	defer store.Close()
+
+	store.UpsertVersion("key", "value", remoteVersion)
+	_, err := store.DeleteVersion("key", remoteVersion-1)
+	assert.Error(kvstore.ErrStaleVersion, err)
+	_, err = store.DeleteVersion("key", remoteVersion+2)
+	assert.Error(nil, err)
+	_, err = store.UpsertVersion("key", "late", remoteVersion+1)
+	assert.Error(kvstore.ErrStaleVersion, err)
+	_, version, err := store.GetWithVersion("key")
+	assert.Uint64("version", remoteVersion+2, version)
+	assert.Error(kvstore.ErrKeyNotFound, err)
+}
@@ -0,0 +1,92 @@
+package kvstore
+
+import "math/rand"
+
+// an ordered set of keys, kept alongside the items map so that keys can be walked in
+// lexical order without sorting the whole keyspace...
+const skiplistMaxLevel int = 24
+const skiplistPromotionChance float64 = 0.25
+
+type skiplistNode struct {
+	key  string
+	next []*skiplistNode
+}
+
+type skiplist struct {
+	head   *skiplistNode
+	level  int
+	length int
+	random *rand.Rand
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		head:   &skiplistNode{next: make([]*skiplistNode, skiplistMaxLevel)},
+		level:  1,
+		random: rand.New(rand.NewSource(1)),
+	}
+}
+
+func (list *skiplist) randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && list.random.Float64() < skiplistPromotionChance {
+		level++
+	}
+	return level
+}
+
+// finds, at every level, the last node whose key is before key...
+func (list *skiplist) predecessors(key string) []*skiplistNode {
+	result := make([]*skiplistNode, skiplistMaxLevel)
+	node := list.head
+	for level := list.level - 1; level >= 0; level-- {
+		for node.next[level] != nil && node.next[level].key < key {
+			node = node.next[level]
+		}
+		result[level] = node
+	}
+	return result
+}
+
+func (list *skiplist) insert(key string) bool {
+	previous := list.predecessors(key)
+	if next := previous[0].next[0]; next != nil && next.key == key {
+		return false
+	}
+	level := list.randomLevel()
+	for ; list.level < level; list.level++ {
+		previous[list.level] = list.head
+	}
+	node := &skiplistNode{key: key, next: make([]*skiplistNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = previous[i].next[i]
+		previous[i].next[i] = node
+	}
+	list.length++
+	return true
+}
+
+func (list *skiplist) remove(key string) bool {
+	previous := list.predecessors(key)
+	node := previous[0].next[0]
+	if node == nil || node.key != key {
+		return false
+	}
+	for i := 0; i < len(node.next); i++ {
+		previous[i].next[i] = node.next[i]
+	}
+	for list.level > 1 && list.head.next[list.level-1] == nil {
+		list.level--
+	}
+	list.length--
+	return true
+}
+
+// returns the first node whose key is at or after key, or nil...
+func (list *skiplist) seek(key string) *skiplistNode {
+	return list.predecessors(key)[0].next[0]
+}
+
+func (list *skiplist) first() *skiplistNode {
+	return list.head.next[0]
+}
@@ -0,0 +1,221 @@
+package kvstore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// a snapshot is a sequence of upsert (and expire) records in the write-ahead log format,
+// written to a temporary file and renamed into place once complete. while it is being
+// written the log is rotated aside so that, should we crash, snapshot + previous log +
+// current log still replays to the latest state. if a snapshot fails its previous log is
+// left in place, and the next rotation moves the log to a new numbered segment after it
+// (.prev.1, .prev.2...) rather than copying it, so rotating never takes longer than a rename...
+const snapshotFileSuffix string = ".snap"
+const snapshotTemporaryFileSuffix string = ".snap.tmp"
+const previousLogFileSuffix string = ".prev"
+
+var ErrSnapshotInProgress = errors.New("snapshot in progress")
+var ErrPersistenceDisabled = errors.New("persistence disabled")
+
+func (store *KvStore) snapshotPath() string {
+	return store.options.LogPath + snapshotFileSuffix
+}
+
+// the previous log segments left on disk, oldest first...
+func (store *KvStore) previousLogPaths() ([]string, error) {
+	first := store.options.LogPath + previousLogFileSuffix
+	if _, err := os.Stat(first); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	numbered, err := filepath.Glob(first + ".*")
+	if err != nil {
+		return nil, err
+	}
+	sequence := func(path string) int {
+		number, _ := strconv.Atoi(strings.TrimPrefix(path, first+"."))
+		return number
+	}
+	sort.Slice(numbered, func(i, j int) bool { return sequence(numbered[i]) < sequence(numbered[j]) })
+	return append([]string{first}, numbered...), nil
+}
+
+// where the log should be rotated to, given the segments already there...
+func (store *KvStore) nextPreviousLogPath(existing []string) string {
+	first := store.options.LogPath + previousLogFileSuffix
+	if len(existing) == 0 {
+		return first
+	}
+	last, _ := strconv.Atoi(strings.TrimPrefix(existing[len(existing)-1], first+"."))
+	return fmt.Sprintf("%s.%d", first, last+1)
+}
+
+// loads the latest snapshot and any log segment left behind by an interrupted snapshot...
+func (store *KvStore) loadSnapshot(apply func(record walRecord)) error {
+	_ = os.Remove(store.options.LogPath + snapshotTemporaryFileSuffix)
+	if err := readSnapshot(store.snapshotPath(), apply); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	previousLogPaths, err := store.previousLogPaths()
+	if err != nil {
+		return err
+	}
+	for _, path := range previousLogPaths {
+		if err := replayWriteAheadLogFile(path, apply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readSnapshot(path string, apply func(record walRecord)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(file)
+	for remaining := info.Size(); remaining > 0; {
+		record, length, err := readWalRecord(reader, remaining)
+		if err != nil {
+			// snapshots are renamed into place once complete, so any damage is real...
+			fmt.Printf("store: bad snapshot record at offset %d\n", info.Size()-remaining)
+			return ErrLogCorrupt
+		}
+		apply(record)
+		remaining -= length
+	}
+	return nil
+}
+
+func writeSnapshot(path string, revision uint64, items map[string]kvItem, expiries map[string]time.Time) error {
+	temporaryPath := path + ".tmp"
+	file, err := os.Create(temporaryPath)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	err = writeSnapshotRecords(writer, revision, items, expiries)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temporaryPath, path)
+	}
+	if err != nil {
+		_ = os.Remove(temporaryPath)
+	}
+	return err
+}
+
+func writeSnapshotRecords(writer io.Writer, revision uint64, items map[string]kvItem, expiries map[string]time.Time) error {
+	if _, err := writer.Write(encodeWalRecord(walRecord{Op: walOpRevision, Fields: []string{formatVersion(revision)}})); err != nil {
+		return err
+	}
+	for key, item := range items {
+		record := walRecord{Op: walOpUpsert, Fields: []string{key, item.value, formatVersion(item.version)}}
+		if item.kind != KindString {
+			fields := []string{key, formatVersion(item.version), strconv.Itoa(int(item.kind))}
+			record = walRecord{Op: walOpStructurePut, Fields: append(fields, item.elements()...)}
+		}
+		if _, err := writer.Write(encodeWalRecord(record)); err != nil {
+			return err
+		}
+		if expiry, volatile := expiries[key]; volatile {
+			fields := []string{key, strconv.FormatInt(expiry.UnixNano(), 10)}
+			if _, err := writer.Write(encodeWalRecord(walRecord{Op: walOpExpire, Fields: fields})); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// called on the request goroutine: copies the items and rotates the log, leaving
+// the slow part (writing the copy to disk) to a background goroutine...
+func (store *KvStore) startSnapshot(waiter chan kvStoreResponse) {
+	reply := func(err error) {
+		if waiter != nil {
+			waiter <- kvStoreResponse{Error: err}
+		}
+	}
+	if store.log == nil {
+		reply(ErrPersistenceDisabled)
+		return
+	}
+	if store.snapshotting {
+		reply(ErrSnapshotInProgress)
+		return
+	}
+	previousLogPaths, err := store.previousLogPaths()
+	if err == nil {
+		previousLogPath := store.nextPreviousLogPath(previousLogPaths)
+		if err = store.log.rotate(previousLogPath); err == nil {
+			previousLogPaths = append(previousLogPaths, previousLogPath)
+		}
+	}
+	if err != nil {
+		fmt.Printf("store: unable to rotate log for snapshot '%s'\n", err.Error())
+		reply(err)
+		return
+	}
+	revision := store.revision
+	items := make(map[string]kvItem, len(store.items))
+	for k, v := range store.items {
+		items[k] = v.clone()
+	}
+	expiries := make(map[string]time.Time, len(store.expiries))
+	for k, v := range store.expiries {
+		expiries[k] = v
+	}
+	store.snapshotting = true
+	store.snapshotWaiter = waiter
+	go func(snapshotPath string, previousLogPaths []string) {
+		err := writeSnapshot(snapshotPath, revision, items, expiries)
+		// everything in the previous segments is now covered by the snapshot. they go newest
+		// first, so a failure part way leaves the oldest ones, still in order...
+		for i := len(previousLogPaths) - 1; i >= 0 && err == nil; i-- {
+			err = os.Remove(previousLogPaths[i])
+		}
+		store.snapshotDone <- err
+	}(store.snapshotPath(), previousLogPaths)
+}
+
+// called on the request goroutine once the background write has finished...
+func (store *KvStore) finishSnapshot(err error) {
+	if err != nil {
+		fmt.Printf("store: snapshot failed '%s'\n", err.Error())
+	} else {
+		fmt.Println("store: snapshot complete")
+	}
+	if store.snapshotWaiter != nil {
+		store.snapshotWaiter <- kvStoreResponse{Error: err}
+	}
+	store.snapshotting = false
+	store.snapshotWaiter = nil
+}
+
+func (store *KvStore) snapshotIsDue() bool {
+	return store.log != nil && !store.snapshotting &&
+		store.options.SnapshotLogSize > 0 && store.log.size >= store.options.SnapshotLogSize
+}
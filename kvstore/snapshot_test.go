@@ -0,0 +1,160 @@
+package kvstore_test
+
+import (
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotCompactsLogAndRestores(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	path := filepath.Join(t.TempDir(), "store.wal")
+
+	store := createLoggedTestObject(path, kvstore.LogSyncAlways)
+	if err := store.Open(); err != nil {
+		t.Fatalf("test setup failure (open): %s", err.Error())
+	}
+	for i := 0; i < 10; i++ {
+		store.Upsert("key", "value"+string(rune('0'+i)))
+	}
+	store.Upsert("other", "other")
+	assert.Error(nil, store.Snapshot())
+	store.Upsert("after", "after")
+	store.Close()
+
+	info, _ := os.Stat(path)
+	if info.Size() == 0 {
+		t.Errorf("param: log size, expected: >0, actual: 0")
+	}
+	if _, err := os.Stat(path + ".snap"); err != nil {
+		t.Errorf("param: snapshot, expected: file, actual: %s", err.Error())
+	}
+	if _, err := os.Stat(path + ".prev"); !os.IsNotExist(err) {
+		t.Errorf("param: previous log, expected: removed, actual: present")
+	}
+
+	store = createLoggedTestObject(path, kvstore.LogSyncAlways)
+	assert.Error(nil, store.Open())
+	defer store.Close()
+	for key, expectedValue := range map[string]string{"key": "value9", "other": "other", "after": "after"} {
+		actualValue, err := store.Get(key)
+		assert.String(key, expectedValue, actualValue)
+		assert.Error(nil, err)
+	}
+}
+
+func TestSnapshotRecoversFromInterruptedSnapshot(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	path := filepath.Join(t.TempDir(), "store.wal")
+
+	store := createLoggedTestObject(path, kvstore.LogSyncAlways)
+	if err := store.Open(); err != nil {
+		t.Fatalf("test setup failure (open): %s", err.Error())
+	}
+	store.Upsert("key", "value")
+	store.Close()
+
+	// simulate a crash after the log was rotated but before the snapshot was written...
+	if err := os.Rename(path, path+".prev"); err != nil {
+		t.Fatalf("test setup failure (rename): %s", err.Error())
+	}
+
+	store = createLoggedTestObject(path, kvstore.LogSyncAlways)
+	assert.Error(nil, store.Open())
+	defer store.Close()
+	actualValue, err := store.Get("key")
+	assert.String("value", "value", actualValue)
+	assert.Error(nil, err)
+}
+
+func TestSnapshotIsTriggeredByLogSize(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "store.wal")
+
+	store := kvstore.NewKvStoreWithOptions(kvstore.KvStoreOptions{LogPath: path, SnapshotLogSize: 64})
+	if err := store.Open(); err != nil {
+		t.Fatalf("test setup failure (open): %s", err.Error())
+	}
+	defer store.Close()
+	for i := 0; i < 10; i++ {
+		store.Upsert("key", "value"+string(rune('0'+i)))
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(path + ".snap"); err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("param: snapshot, expected: file, actual: none")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSnapshotWithoutPersistenceReturnsError(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	assert.Error(kvstore.ErrPersistenceDisabled, store.Snapshot())
+}
+
+func TestFailedSnapshotsLeaveNumberedLogSegments(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	path := filepath.Join(t.TempDir(), "store.wal")
+
+	store := createLoggedTestObject(path, kvstore.LogSyncAlways)
+	store.Open()
+	store.Upsert("first", "1")
+	store.Upsert("key", "a")
+	store.Close()
+	// an earlier snapshot that never completed...
+	if err := os.Rename(path, path+".prev"); err != nil {
+		t.Fatalf("test setup failure (rename): %s", err.Error())
+	}
+	// and one that will fail, since its temporary file can't be created...
+	if err := os.MkdirAll(filepath.Join(path+".snap.tmp", "blocker"), 0o755); err != nil {
+		t.Fatalf("test setup failure (mkdir): %s", err.Error())
+	}
+
+	store = createLoggedTestObject(path, kvstore.LogSyncAlways)
+	assert.Error(nil, store.Open())
+	store.Upsert("second", "2")
+	store.Upsert("key", "b")
+	if err := store.Snapshot(); err == nil {
+		t.Errorf("param: snapshot, expected: error, actual: nil")
+	}
+	store.Upsert("key", "c")
+	store.Close()
+	for _, segment := range []string{".prev", ".prev.1"} {
+		if _, err := os.Stat(path + segment); err != nil {
+			t.Errorf("param: %s, expected: present, actual: %s", segment, err.Error())
+		}
+	}
+
+	if err := os.RemoveAll(path + ".snap.tmp"); err != nil {
+		t.Fatalf("test setup failure (remove): %s", err.Error())
+	}
+	store = createLoggedTestObject(path, kvstore.LogSyncAlways)
+	assert.Error(nil, store.Open())
+	defer store.Close()
+	for key, expectedValue := range map[string]string{"first": "1", "second": "2", "key": "c"} {
+		actualValue, _ := store.Get(key)
+		assert.String(key, expectedValue, actualValue)
+	}
+	assert.Error(nil, store.Snapshot())
+	for _, segment := range []string{".prev", ".prev.1", ".prev.2"} {
+		if _, err := os.Stat(path + segment); !os.IsNotExist(err) {
+			t.Errorf("param: %s, expected: removed, actual: present", segment)
+		}
+	}
+}
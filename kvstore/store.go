@@ -0,0 +1,129 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Store is the storage a server runs against. Anything beyond these core operations is
+// offered through the optional interfaces below, which callers should type-assert for.
+type Store interface {
+	Open() error
+	Close()
+	Get(key string) (string, error)
+	Upsert(key string, value string) (uint64, error)
+	Delete(key string) (uint64, error)
+	ListKeys() []string
+	GetContext(ctx context.Context, key string) (string, error)
+	UpsertContext(ctx context.Context, key string, value string) (uint64, error)
+	DeleteContext(ctx context.Context, key string) (uint64, error)
+	ListKeysContext(ctx context.Context) ([]string, error)
+}
+
+// SnapshottingStore is a Store that can compact its persisted state on demand.
+type SnapshottingStore interface {
+	Snapshot() error
+}
+
+// ExpiringStore is a Store whose keys can be given a time to live.
+type ExpiringStore interface {
+	UpsertWithTTL(key string, value string, ttl time.Duration) (uint64, error)
+	Expire(key string, ttl time.Duration) error
+	TTL(key string) (time.Duration, error)
+	Persist(key string) error
+	Expired() <-chan string
+}
+
+// ConditionalStore is a Store that can make writes conditional on a key's current value.
+type ConditionalStore interface {
+	CompareAndSwap(key string, expected string, value string) (uint64, error)
+	PutIfAbsent(key string, value string) (uint64, error)
+	DeleteIfEquals(key string, expected string) (uint64, error)
+}
+
+// VersionedStore is a Store that exposes the version stamped on each write.
+type VersionedStore interface {
+	GetWithVersion(key string) (string, uint64, error)
+	UpsertVersion(key string, value string, version uint64) (uint64, error)
+	DeleteVersion(key string, version uint64) (uint64, error)
+}
+
+// TransactionalStore is a Store that can run several operations as one.
+type TransactionalStore interface {
+	Transact(operations []Operation, watched map[string]uint64) ([]OperationResult, error)
+}
+
+// ScanningStore is a Store that can walk its keys in lexical order.
+type ScanningStore interface {
+	Scan(start string, end string, limit int) ([]KeyValue, error)
+}
+
+// MemoryLimitedStore is a Store that reports its size against its memory limits.
+type MemoryLimitedStore interface {
+	MemoryStats() MemoryStats
+}
+
+// WatchableStore is a Store that can push changes to its keys.
+type WatchableStore interface {
+	Watch(prefix string) (<-chan ChangeEvent, func())
+}
+
+// CountingStore is a Store that can add to integer values in place.
+type CountingStore interface {
+	IncrementBy(key string, delta int64) (int64, uint64, error)
+}
+
+// MultiKeyStore is a Store that can read or write several keys in one request.
+type MultiKeyStore interface {
+	GetMany(keys []string) ([]OperationResult, error)
+	UpsertMany(entries []KeyValue) ([]uint64, error)
+}
+
+// RangedStore is a Store that can read and write part of a string value in place.
+type RangedStore interface {
+	Append(key string, value string) (int, uint64, error)
+	GetRange(key string, offset int, length int) (string, error)
+	SetRange(key string, offset int, value string) (int, uint64, error)
+	Strlen(key string) (int, error)
+}
+
+// StructuredStore is a Store whose keys can hold hashes, lists and sets as well as strings.
+type StructuredStore interface {
+	HashSet(key string, field string, value string) (bool, uint64, error)
+	HashGet(key string, field string) (string, error)
+	HashDelete(key string, field string) (bool, uint64, error)
+	HashGetAll(key string) ([]KeyValue, error)
+	ListPush(key string, value string, front bool) (int, uint64, error)
+	ListPop(key string, front bool) (string, uint64, error)
+	ListRange(key string, start int, stop int) ([]string, error)
+	SetAdd(key string, member string) (bool, uint64, error)
+	SetRemove(key string, member string) (bool, uint64, error)
+	SetMembers(key string) ([]string, error)
+	SetIsMember(key string, member string) (bool, error)
+	GetStructure(key string) (ValueKind, []string, uint64, error)
+	UpsertStructureVersion(key string, kind ValueKind, elements []string, version uint64) (uint64, error)
+}
+
+// BinaryStore is a Store with byte slice forms of its core operations, for values that aren't text.
+type BinaryStore interface {
+	GetBytes(key string) ([]byte, error)
+	UpsertBytes(key string, value []byte) (uint64, error)
+}
+
+var ErrNotSupported = errors.New("not supported by this store")
+
+var _ Store = (*KvStore)(nil)
+var _ SnapshottingStore = (*KvStore)(nil)
+var _ ExpiringStore = (*KvStore)(nil)
+var _ ConditionalStore = (*KvStore)(nil)
+var _ VersionedStore = (*KvStore)(nil)
+var _ TransactionalStore = (*KvStore)(nil)
+var _ ScanningStore = (*KvStore)(nil)
+var _ MemoryLimitedStore = (*KvStore)(nil)
+var _ CountingStore = (*KvStore)(nil)
+var _ MultiKeyStore = (*KvStore)(nil)
+var _ RangedStore = (*KvStore)(nil)
+var _ StructuredStore = (*KvStore)(nil)
+var _ WatchableStore = (*KvStore)(nil)
+var _ BinaryStore = (*KvStore)(nil)
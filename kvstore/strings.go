@@ -0,0 +1,177 @@
+package kvstore
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// the longest value SetRange will pad a value out to, as in redis...
+const MaxStringLength int = 512 * 1024 * 1024
+
+var ErrInvalidRange = errors.New("invalid offset or length")
+
+// Append adds value to the end of the string held by key, treating a missing key as empty, and
+// returns the new length along with its version; any expiry the key has is kept.
+func (store *KvStore) Append(key string, value string) (int, uint64, error) {
+	request := kvStoreRequest{
+		Command: kvCommandAppend,
+		Key:     key,
+		Value:   value,
+		Results: make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return int(response.Integer), response.Version, response.Error
+}
+
+// GetRange returns up to length bytes of the string held by key, starting at offset. A negative
+// offset counts back from the end; the range is clamped to the value, so reading past either
+// end returns what there is rather than an error. A negative length is ErrInvalidRange.
+func (store *KvStore) GetRange(key string, offset int, length int) (string, error) {
+	request := kvStoreRequest{
+		Command: kvCommandGetRange,
+		Key:     key,
+		Value:   "",
+		Start:   offset,
+		Limit:   length,
+		Results: make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return response.Value, response.Error
+}
+
+// SetRange overwrites the string held by key with value, starting at offset, padding it with zero
+// bytes if it is shorter than offset, and returns the new length along with its version; any expiry
+// the key has is kept. A negative offset, or one that would take the value past MaxStringLength,
+// is ErrInvalidRange.
+func (store *KvStore) SetRange(key string, offset int, value string) (int, uint64, error) {
+	request := kvStoreRequest{
+		Command: kvCommandSetRange,
+		Key:     key,
+		Value:   value,
+		Start:   offset,
+		Results: make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return int(response.Integer), response.Version, response.Error
+}
+
+// Strlen returns the length of the string held by key, or zero if it is missing.
+func (store *KvStore) Strlen(key string) (int, error) {
+	request := kvStoreRequest{
+		Command: kvCommandStrlen,
+		Key:     key,
+		Value:   "",
+		Results: make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return int(response.Integer), response.Error
+}
+
+// the string held by key, empty if it is missing...
+func (store *KvStore) stringValue(key string) (string, bool, error) {
+	item, exists := store.items[key]
+	if !exists {
+		return "", false, nil
+	}
+	if item.kind != KindString {
+		return "", true, ErrWrongType
+	}
+	item.touch(time.Now())
+	return item.value, true, nil
+}
+
+func (store *KvStore) append(key string, value string) (int64, uint64, error) {
+	current, _, err := store.stringValue(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(current)+len(value) > MaxStringLength {
+		return int64(len(current)), 0, ErrInvalidRange
+	}
+	version, err := store.upsertKeepingExpiry(key, current+value)
+	return int64(len(current) + len(value)), version, err
+}
+
+func (store *KvStore) getRange(key string, offset int, length int) (string, error) {
+	if length < 0 {
+		return "", ErrInvalidRange
+	}
+	current, exists, err := store.stringValue(key)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", ErrKeyNotFound
+	}
+	if offset < 0 {
+		offset += len(current)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(current) {
+		return "", nil
+	}
+	end := len(current)
+	if length < end-offset {
+		end = offset + length
+	}
+	return current[offset:end], nil
+}
+
+func (store *KvStore) setRange(key string, offset int, value string) (int64, uint64, error) {
+	if offset < 0 || offset > MaxStringLength-len(value) {
+		return 0, 0, ErrInvalidRange
+	}
+	current, exists, err := store.stringValue(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	if value == "" {
+		// nothing to write, so a missing key stays missing...
+		var version uint64
+		if exists {
+			version = store.items[key].version
+		}
+		return int64(len(current)), version, nil
+	}
+	if len(current) < offset {
+		current += strings.Repeat("\x00", offset-len(current))
+	}
+	result := current[:offset] + value
+	if offset+len(value) < len(current) {
+		result += current[offset+len(value):]
+	}
+	version, err := store.upsertKeepingExpiry(key, result)
+	return int64(len(result)), version, err
+}
+
+func (store *KvStore) strlen(key string) (int64, error) {
+	current, _, err := store.stringValue(key)
+	return int64(len(current)), err
+}
+
+// writes value to key without clearing its expiry, as a change to part of a value shouldn't...
+func (store *KvStore) upsertKeepingExpiry(key string, value string) (uint64, error) {
+	expiry, volatile := store.expiries[key]
+	version, err := store.upsert(key, value)
+	if err != nil {
+		return 0, err
+	}
+	// writing the value clears its expiry, as Upsert does, so it goes straight back...
+	if volatile {
+		if err := store.setExpiry(key, expiry); err != nil {
+			return version, err
+		}
+	}
+	return version, nil
+}
@@ -0,0 +1,120 @@
+package kvstore_test
+
+import (
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"testing"
+	"time"
+)
+
+func TestAppendAndStrlen(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	length, version1, err := store.Append("key", "hello")
+	assert.Error(nil, err)
+	assert.Int("length", 5, length)
+	length, version2, _ := store.Append("key", " world")
+	assert.Int("length", 11, length)
+	assert.True("version2 > version1", version2 > version1)
+	value, _ := store.Get("key")
+	assert.String("value", "hello world", value)
+
+	length, err = store.Strlen("key")
+	assert.Error(nil, err)
+	assert.Int("strlen", 11, length)
+	length, err = store.Strlen("missing")
+	assert.Error(nil, err)
+	assert.Int("strlen missing", 0, length)
+}
+
+func TestGetRangeClamps(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	store.Upsert("key", "abcdef")
+	for _, testCase := range []struct {
+		offset   int
+		length   int
+		expected string
+	}{
+		{0, 3, "abc"},
+		{2, 100, "cdef"},
+		{6, 1, ""},
+		{100, 1, ""},
+		{-2, 5, "ef"},
+		{-100, 2, "ab"},
+		{1, 0, ""},
+	} {
+		value, err := store.GetRange("key", testCase.offset, testCase.length)
+		assert.Error(nil, err)
+		assert.String("range", testCase.expected, value)
+	}
+	_, err := store.GetRange("key", 0, -1)
+	assert.Error(kvstore.ErrInvalidRange, err)
+	_, err = store.GetRange("missing", 0, 1)
+	assert.Error(kvstore.ErrKeyNotFound, err)
+}
+
+func TestSetRange(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	store.Upsert("key", "hello world")
+	length, _, err := store.SetRange("key", 6, "there")
+	assert.Error(nil, err)
+	assert.Int("length", 11, length)
+	value, _ := store.Get("key")
+	assert.String("overwritten", "hello there", value)
+
+	length, _, _ = store.SetRange("key", 6, "everyone!")
+	assert.Int("length", 15, length)
+	value, _ = store.Get("key")
+	assert.String("extended", "hello everyone!", value)
+
+	length, _, _ = store.SetRange("padded", 2, "x")
+	assert.Int("length", 3, length)
+	value, _ = store.Get("padded")
+	assert.String("padded", "\x00\x00x", value)
+
+	_, _, err = store.SetRange("key", -1, "x")
+	assert.Error(kvstore.ErrInvalidRange, err)
+	_, _, err = store.SetRange("key", kvstore.MaxStringLength, "x")
+	assert.Error(kvstore.ErrInvalidRange, err)
+	length, _, _ = store.SetRange("empty", 5, "")
+	assert.Int("nothing written", 0, length)
+	_, err = store.Get("empty")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+}
+
+func TestStringOperationsKeepExpiryAndCheckKind(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	store.UpsertWithTTL("key", "abc", time.Hour)
+	store.Append("key", "def")
+	store.SetRange("key", 0, "x")
+	ttl, err := store.TTL("key")
+	assert.Error(nil, err)
+	assert.True("ttl kept", ttl > 0)
+
+	store.SetAdd("tags", "red")
+	_, _, err = store.Append("tags", "x")
+	assert.Error(kvstore.ErrWrongType, err)
+	_, err = store.GetRange("tags", 0, 1)
+	assert.Error(kvstore.ErrWrongType, err)
+	_, err = store.Strlen("tags")
+	assert.Error(kvstore.ErrWrongType, err)
+}
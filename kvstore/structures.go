@@ -0,0 +1,644 @@
+package kvstore
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// besides strings, a key can hold a hash (a map of fields to values), a list or a set. each
+// change to one is a small log record of its own, stamped with a version like any other write;
+// replaying a record whose version the key already has (or has passed) does nothing, so a log
+// segment replayed over a snapshot that covers it leaves the same state. a hash, list or set is
+// removed once its last element is, and keeps any expiry it has while it is being changed...
+type ValueKind int
+
+const (
+	KindString ValueKind = iota
+	KindHash
+	KindList
+	KindSet
+)
+
+const walOpStructurePut byte = 7 // key, version, kind, elements...
+const walOpHashSet byte = 8      // key, version, field, value
+const walOpHashDelete byte = 9   // key, version, field
+const walOpListPush byte = 10    // key, version, end, value
+const walOpListPop byte = 11     // key, version, end
+const walOpSetAdd byte = 12      // key, version, member
+const walOpSetRemove byte = 13   // key, version, member
+
+const listFront string = "front"
+const listBack string = "back"
+
+var ErrWrongType = errors.New("operation against a key holding the wrong kind of value")
+
+// HashSet sets a field of the hash held by key, reporting whether the field is new.
+func (store *KvStore) HashSet(key string, field string, value string) (bool, uint64, error) {
+	response := store.queryStructure(kvStoreRequest{Command: kvCommandHashSet, Key: key, Field: field, Value: value})
+	return response.Found, response.Version, response.Error
+}
+
+// HashGet returns a field of the hash held by key, or ErrKeyNotFound if either is missing.
+func (store *KvStore) HashGet(key string, field string) (string, error) {
+	response := store.queryStructure(kvStoreRequest{Command: kvCommandHashGet, Key: key, Field: field})
+	return response.Value, response.Error
+}
+
+// HashDelete removes a field of the hash held by key, reporting whether it was there.
+func (store *KvStore) HashDelete(key string, field string) (bool, uint64, error) {
+	response := store.queryStructure(kvStoreRequest{Command: kvCommandHashDelete, Key: key, Field: field})
+	return response.Found, response.Version, response.Error
+}
+
+// HashGetAll returns every field of the hash held by key, in lexical order of field.
+func (store *KvStore) HashGetAll(key string) ([]KeyValue, error) {
+	response := store.queryStructure(kvStoreRequest{Command: kvCommandHashGetAll, Key: key})
+	return response.Entries, response.Error
+}
+
+// ListPush adds value to the front or back of the list held by key, returning its new length.
+func (store *KvStore) ListPush(key string, value string, front bool) (int, uint64, error) {
+	response := store.queryStructure(kvStoreRequest{Command: kvCommandListPush, Key: key, Value: value, Front: front})
+	return int(response.Integer), response.Version, response.Error
+}
+
+// ListPop removes and returns the value at the front or back of the list held by key.
+func (store *KvStore) ListPop(key string, front bool) (string, uint64, error) {
+	response := store.queryStructure(kvStoreRequest{Command: kvCommandListPop, Key: key, Front: front})
+	return response.Value, response.Version, response.Error
+}
+
+// ListRange returns the values of the list held by key from start to stop, both inclusive.
+// Negative positions count back from the end (-1 is the last value), and positions beyond
+// either end are clamped, as in redis; a missing key is an empty list.
+func (store *KvStore) ListRange(key string, start int, stop int) ([]string, error) {
+	response := store.queryStructure(kvStoreRequest{Command: kvCommandListRange, Key: key, Start: start, Stop: stop})
+	return response.Values, response.Error
+}
+
+// SetAdd adds member to the set held by key, reporting whether it is new.
+func (store *KvStore) SetAdd(key string, member string) (bool, uint64, error) {
+	response := store.queryStructure(kvStoreRequest{Command: kvCommandSetAdd, Key: key, Field: member})
+	return response.Found, response.Version, response.Error
+}
+
+// SetRemove removes member from the set held by key, reporting whether it was there.
+func (store *KvStore) SetRemove(key string, member string) (bool, uint64, error) {
+	response := store.queryStructure(kvStoreRequest{Command: kvCommandSetRemove, Key: key, Field: member})
+	return response.Found, response.Version, response.Error
+}
+
+// SetMembers returns the members of the set held by key, in lexical order; a missing key is an empty set.
+func (store *KvStore) SetMembers(key string) ([]string, error) {
+	response := store.queryStructure(kvStoreRequest{Command: kvCommandSetMembers, Key: key})
+	return response.Values, response.Error
+}
+
+// SetIsMember reports whether member is in the set held by key.
+func (store *KvStore) SetIsMember(key string, member string) (bool, error) {
+	response := store.queryStructure(kvStoreRequest{Command: kvCommandSetIsMember, Key: key, Field: member})
+	return response.Found, response.Error
+}
+
+// GetStructure returns what a key holds as its kind and a flat list of elements: field and value
+// pairs for a hash (in field order), values for a list and members for a set (in order), or the
+// value alone for a string. Together with UpsertStructureVersion it copies keys around the cluster;
+// for a key deleted recently it returns ErrKeyNotFound with the version of the deletion.
+func (store *KvStore) GetStructure(key string) (ValueKind, []string, uint64, error) {
+	response := store.queryStructure(kvStoreRequest{Command: kvCommandGetStructure, Key: key})
+	return response.Kind, response.Values, response.Version, response.Error
+}
+
+// UpsertStructureVersion replaces whatever key holds with the hash, list or set described by
+// elements, as returned by GetStructure, keeping the version it was given elsewhere in the
+// cluster; like UpsertVersion it is ignored with ErrStaleVersion if the key already has a newer
+// version, or the same version and elements that sort at or after those given.
+func (store *KvStore) UpsertStructureVersion(key string, kind ValueKind, elements []string, version uint64) (uint64, error) {
+	response := store.queryStructure(kvStoreRequest{Command: kvCommandUpsertStructure, Key: key, Kind: kind, Values: elements, Version: version})
+	return response.Version, response.Error
+}
+
+func (store *KvStore) queryStructure(request kvStoreRequest) kvStoreResponse {
+	request.Results = make(chan kvStoreResponse)
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return response
+}
+
+func (store *KvStore) handleStructureRequest(request kvStoreRequest, response *kvStoreResponse) {
+	switch request.Command {
+	case kvCommandHashSet:
+		response.Found, response.Version, response.Error = store.hashSet(request.Key, request.Field, request.Value)
+	case kvCommandHashGet:
+		response.Value, response.Error = store.hashGet(request.Key, request.Field)
+	case kvCommandHashDelete:
+		response.Found, response.Version, response.Error = store.hashDelete(request.Key, request.Field)
+	case kvCommandHashGetAll:
+		response.Entries, response.Error = store.hashGetAll(request.Key)
+	case kvCommandListPush:
+		response.Integer, response.Version, response.Error = store.listPush(request.Key, request.Value, request.Front)
+	case kvCommandListPop:
+		response.Value, response.Version, response.Error = store.listPop(request.Key, request.Front)
+	case kvCommandListRange:
+		response.Values, response.Error = store.listRange(request.Key, request.Start, request.Stop)
+	case kvCommandSetAdd:
+		response.Found, response.Version, response.Error = store.setAdd(request.Key, request.Field)
+	case kvCommandSetRemove:
+		response.Found, response.Version, response.Error = store.setRemove(request.Key, request.Field)
+	case kvCommandSetMembers:
+		response.Values, response.Error = store.setMembers(request.Key)
+	case kvCommandSetIsMember:
+		response.Found, response.Error = store.setIsMember(request.Key, request.Field)
+	case kvCommandGetStructure:
+		response.Kind, response.Values, response.Version, response.Error = store.getStructure(request.Key)
+	case kvCommandUpsertStructure:
+		response.Version, response.Error = store.upsertStructure(request.Key, request.Kind, request.Values, request.Version)
+	}
+}
+
+// the item a read finds at key, if it is of the given kind...
+func (store *KvStore) structureToRead(key string, kind ValueKind) (*kvItem, error) {
+	item, exists := store.items[key]
+	if !exists {
+		return nil, ErrKeyNotFound
+	}
+	if item.kind != kind {
+		return nil, ErrWrongType
+	}
+	item.touch(time.Now())
+	return item, nil
+}
+
+// checks a write to a structure of the given kind is allowed, making room for it, and logs it
+// with a new version...
+func (store *KvStore) writeStructure(key string, kind ValueKind, growth int64, op byte, fields ...string) (uint64, error) {
+	item, exists := store.items[key]
+	if exists && item.kind != kind {
+		return item.version, ErrWrongType
+	}
+	added := 0
+	if !exists {
+		growth, added = growth+int64(len(key)), 1
+	}
+	if growth > 0 {
+		if err := store.makeRoomFor(key, growth, added); err != nil {
+			return 0, err
+		}
+	}
+	version := nextVersion(store.revision, time.Now())
+	if err := store.writeAhead(op, append([]string{key, formatVersion(version)}, fields...)...); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// tells watchers about a change to a structure, which carries no values, or its removal...
+func (store *KvStore) publishStructureChange(key string, version uint64) {
+	op := ChangeUpsert
+	if _, exists := store.items[key]; !exists {
+		op = ChangeDelete
+	}
+	store.publish(ChangeEvent{Op: op, Key: key, Version: version})
+}
+
+func (store *KvStore) hashSet(key string, field string, value string) (bool, uint64, error) {
+	growth := int64(len(field) + len(value))
+	if item, exists := store.items[key]; exists && item.kind == KindHash {
+		if previous, had := item.hash[field]; had {
+			growth = int64(len(value) - len(previous))
+		}
+	}
+	version, err := store.writeStructure(key, KindHash, growth, walOpHashSet, field, value)
+	if err != nil {
+		return false, version, err
+	}
+	created := store.applyHashSet(key, field, value, version)
+	store.publishStructureChange(key, version)
+	return created, version, nil
+}
+
+func (store *KvStore) hashGet(key string, field string) (string, error) {
+	item, err := store.structureToRead(key, KindHash)
+	if err != nil {
+		return "", err
+	}
+	value, exists := item.hash[field]
+	if !exists {
+		return "", ErrKeyNotFound
+	}
+	return value, nil
+}
+
+func (store *KvStore) hashDelete(key string, field string) (bool, uint64, error) {
+	item, err := store.structureToRead(key, KindHash)
+	if errors.Is(err, ErrKeyNotFound) {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	if _, exists := item.hash[field]; !exists {
+		return false, item.version, nil
+	}
+	version, err := store.writeStructure(key, KindHash, 0, walOpHashDelete, field)
+	if err != nil {
+		return false, version, err
+	}
+	store.applyHashDelete(key, field, version)
+	store.publishStructureChange(key, version)
+	return true, version, nil
+}
+
+func (store *KvStore) hashGetAll(key string) ([]KeyValue, error) {
+	item, err := store.structureToRead(key, KindHash)
+	if errors.Is(err, ErrKeyNotFound) {
+		return []KeyValue{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := make([]KeyValue, 0, len(item.hash))
+	for field, value := range item.hash {
+		result = append(result, KeyValue{Key: field, Value: value})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result, nil
+}
+
+func listEnd(front bool) string {
+	if front {
+		return listFront
+	}
+	return listBack
+}
+
+func (store *KvStore) listPush(key string, value string, front bool) (int64, uint64, error) {
+	version, err := store.writeStructure(key, KindList, int64(len(value)), walOpListPush, listEnd(front), value)
+	if err != nil {
+		return 0, version, err
+	}
+	store.applyListPush(key, value, front, version)
+	store.publishStructureChange(key, version)
+	return int64(len(store.items[key].list)), version, nil
+}
+
+func (store *KvStore) listPop(key string, front bool) (string, uint64, error) {
+	item, err := store.structureToRead(key, KindList)
+	if err != nil {
+		return "", 0, err
+	}
+	value := item.list[len(item.list)-1]
+	if front {
+		value = item.list[0]
+	}
+	version, err := store.writeStructure(key, KindList, 0, walOpListPop, listEnd(front))
+	if err != nil {
+		return "", version, err
+	}
+	store.applyListPop(key, front, version)
+	store.publishStructureChange(key, version)
+	return value, version, nil
+}
+
+func (store *KvStore) listRange(key string, start int, stop int) ([]string, error) {
+	item, err := store.structureToRead(key, KindList)
+	if errors.Is(err, ErrKeyNotFound) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	length := len(item.list)
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop {
+		return []string{}, nil
+	}
+	return append([]string{}, item.list[start:stop+1]...), nil
+}
+
+func (store *KvStore) setAdd(key string, member string) (bool, uint64, error) {
+	if item, exists := store.items[key]; exists && item.kind == KindSet {
+		if _, isMember := item.set[member]; isMember {
+			return false, item.version, nil
+		}
+	}
+	version, err := store.writeStructure(key, KindSet, int64(len(member)), walOpSetAdd, member)
+	if err != nil {
+		return false, version, err
+	}
+	store.applySetAdd(key, member, version)
+	store.publishStructureChange(key, version)
+	return true, version, nil
+}
+
+func (store *KvStore) setRemove(key string, member string) (bool, uint64, error) {
+	item, err := store.structureToRead(key, KindSet)
+	if errors.Is(err, ErrKeyNotFound) {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	if _, isMember := item.set[member]; !isMember {
+		return false, item.version, nil
+	}
+	version, err := store.writeStructure(key, KindSet, 0, walOpSetRemove, member)
+	if err != nil {
+		return false, version, err
+	}
+	store.applySetRemove(key, member, version)
+	store.publishStructureChange(key, version)
+	return true, version, nil
+}
+
+func (store *KvStore) setMembers(key string) ([]string, error) {
+	item, err := store.structureToRead(key, KindSet)
+	if errors.Is(err, ErrKeyNotFound) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.elements(), nil
+}
+
+func (store *KvStore) setIsMember(key string, member string) (bool, error) {
+	item, err := store.structureToRead(key, KindSet)
+	if errors.Is(err, ErrKeyNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	_, isMember := item.set[member]
+	return isMember, nil
+}
+
+func (store *KvStore) getStructure(key string) (ValueKind, []string, uint64, error) {
+	item, exists := store.items[key]
+	if !exists {
+		return KindString, nil, store.deleted.version(key), ErrKeyNotFound
+	}
+	return item.kind, item.elements(), item.version, nil
+}
+
+func (store *KvStore) upsertStructure(key string, kind ValueKind, elements []string, version uint64) (uint64, error) {
+	if kind == KindString || (kind == KindHash && len(elements)%2 != 0) || len(elements) == 0 {
+		return 0, ErrWrongType
+	}
+	if current, exists := store.items[key]; exists {
+		if !supersedes(version, structureSortKey(kind, elements), current.version, structureSortKey(current.kind, current.elements())) {
+			return current.version, ErrStaleVersion
+		}
+	}
+	if deleted := store.deleted.version(key); deleted > 0 && !supersedes(version, structureSortKey(kind, elements), deleted, "") {
+		return deleted, ErrStaleVersion
+	}
+	growth, added := int64(len(key)), 1
+	for _, element := range elements {
+		growth += int64(len(element))
+	}
+	if current, exists := store.items[key]; exists {
+		growth, added = growth-current.size(key), 0
+	}
+	if err := store.makeRoomFor(key, growth, added); err != nil {
+		return 0, err
+	}
+	fields := append([]string{key, formatVersion(version), strconv.Itoa(int(kind))}, elements...)
+	if err := store.writeAhead(walOpStructurePut, fields...); err != nil {
+		return 0, err
+	}
+	store.putStructure(key, kind, elements, version)
+	store.publishStructureChange(key, version)
+	return version, nil
+}
+
+// what the tie-breaker between equal versions compares...
+func structureSortKey(kind ValueKind, elements []string) string {
+	return strconv.Itoa(int(kind)) + "\x00" + strings.Join(elements, "\x00")
+}
+
+// the elements of an item as GetStructure describes them...
+func (item *kvItem) elements() []string {
+	switch item.kind {
+	case KindHash:
+		fields := make([]string, 0, len(item.hash))
+		for field := range item.hash {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		result := make([]string, 0, 2*len(fields))
+		for _, field := range fields {
+			result = append(result, field, item.hash[field])
+		}
+		return result
+	case KindList:
+		return append([]string{}, item.list...)
+	case KindSet:
+		result := make([]string, 0, len(item.set))
+		for member := range item.set {
+			result = append(result, member)
+		}
+		sort.Strings(result)
+		return result
+	}
+	return []string{item.value}
+}
+
+// a copy of an item that shares nothing with it, for a snapshot to write out in the background...
+func (item *kvItem) clone() kvItem {
+	result := *item
+	if item.hash != nil {
+		result.hash = make(map[string]string, len(item.hash))
+		for field, value := range item.hash {
+			result.hash[field] = value
+		}
+	}
+	if item.list != nil {
+		result.list = append([]string{}, item.list...)
+	}
+	if item.set != nil {
+		result.set = make(map[string]struct{}, len(item.set))
+		for member := range item.set {
+			result.set[member] = struct{}{}
+		}
+	}
+	return result
+}
+
+// the primitive structure mutations, shared by request handling and log replay. each takes the
+// item to change, creating it if need be, and removes it once it is empty...
+func (store *KvStore) changeStructure(key string, kind ValueKind, version uint64, change func(item *kvItem) int64) {
+	item, exists := store.items[key]
+	if !exists || item.kind != kind {
+		store.removeItem(key)
+		item = &kvItem{kind: kind}
+		switch kind {
+		case KindHash:
+			item.hash = make(map[string]string)
+		case KindSet:
+			item.set = make(map[string]struct{})
+		}
+		store.index.insert(key)
+		store.deleted.remove(key)
+		store.items[key] = item
+		store.memory += item.size(key)
+	}
+	growth := change(item)
+	item.elementBytes += growth
+	store.memory += growth
+	item.version = version
+	item.touch(time.Now())
+	store.observeVersion(version)
+	if len(item.hash) == 0 && len(item.list) == 0 && len(item.set) == 0 {
+		store.removeItem(key)
+		store.deleted.add(key, version, time.Now())
+	}
+}
+
+func (store *KvStore) applyHashSet(key string, field string, value string, version uint64) (created bool) {
+	store.changeStructure(key, KindHash, version, func(item *kvItem) int64 {
+		previous, exists := item.hash[field]
+		item.hash[field] = value
+		created = !exists
+		if exists {
+			return int64(len(value) - len(previous))
+		}
+		return int64(len(field) + len(value))
+	})
+	return created
+}
+
+func (store *KvStore) applyHashDelete(key string, field string, version uint64) {
+	store.changeStructure(key, KindHash, version, func(item *kvItem) int64 {
+		previous, exists := item.hash[field]
+		if !exists {
+			return 0
+		}
+		delete(item.hash, field)
+		return -int64(len(field) + len(previous))
+	})
+}
+
+func (store *KvStore) applyListPush(key string, value string, front bool, version uint64) {
+	store.changeStructure(key, KindList, version, func(item *kvItem) int64 {
+		if front {
+			item.list = append([]string{value}, item.list...)
+		} else {
+			item.list = append(item.list, value)
+		}
+		return int64(len(value))
+	})
+}
+
+func (store *KvStore) applyListPop(key string, front bool, version uint64) {
+	store.changeStructure(key, KindList, version, func(item *kvItem) int64 {
+		if len(item.list) == 0 {
+			return 0
+		}
+		value := item.list[len(item.list)-1]
+		if front {
+			value, item.list = item.list[0], item.list[1:]
+		} else {
+			item.list = item.list[:len(item.list)-1]
+		}
+		return -int64(len(value))
+	})
+}
+
+func (store *KvStore) applySetAdd(key string, member string, version uint64) {
+	store.changeStructure(key, KindSet, version, func(item *kvItem) int64 {
+		if _, exists := item.set[member]; exists {
+			return 0
+		}
+		item.set[member] = struct{}{}
+		return int64(len(member))
+	})
+}
+
+func (store *KvStore) applySetRemove(key string, member string, version uint64) {
+	store.changeStructure(key, KindSet, version, func(item *kvItem) int64 {
+		if _, exists := item.set[member]; !exists {
+			return 0
+		}
+		delete(item.set, member)
+		return -int64(len(member))
+	})
+}
+
+// replaces whatever key holds with a whole structure...
+func (store *KvStore) putStructure(key string, kind ValueKind, elements []string, version uint64) {
+	store.removeItem(key)
+	store.changeStructure(key, kind, version, func(item *kvItem) int64 {
+		growth := int64(0)
+		for i := 0; i < len(elements); i++ {
+			switch kind {
+			case KindHash:
+				if i+1 < len(elements) {
+					item.hash[elements[i]] = elements[i+1]
+					growth += int64(len(elements[i]) + len(elements[i+1]))
+					i++
+				}
+			case KindList:
+				item.list = append(item.list, elements[i])
+				growth += int64(len(elements[i]))
+			case KindSet:
+				if _, exists := item.set[elements[i]]; !exists {
+					item.set[elements[i]] = struct{}{}
+					growth += int64(len(elements[i]))
+				}
+			}
+		}
+		return growth
+	})
+}
+
+// applies a structure record found in the log, unless the key already has its version...
+func (store *KvStore) applyStructureRecord(record walRecord) {
+	if len(record.Fields) < 2 {
+		return
+	}
+	key := record.Fields[0]
+	version := recordVersion(record, 1, store.revision)
+	if item, exists := store.items[key]; exists && item.version >= version {
+		return
+	}
+	fields := record.Fields[2:]
+	switch {
+	case record.Op == walOpStructurePut && len(fields) >= 1:
+		if kind, err := strconv.Atoi(fields[0]); err == nil {
+			store.putStructure(key, ValueKind(kind), fields[1:], version)
+		}
+	case record.Op == walOpHashSet && len(fields) == 2:
+		store.applyHashSet(key, fields[0], fields[1], version)
+	case record.Op == walOpHashDelete && len(fields) == 1:
+		store.applyHashDelete(key, fields[0], version)
+	case record.Op == walOpListPush && len(fields) == 2:
+		store.applyListPush(key, fields[1], fields[0] == listFront, version)
+	case record.Op == walOpListPop && len(fields) == 1:
+		store.applyListPop(key, fields[0] == listFront, version)
+	case record.Op == walOpSetAdd && len(fields) == 1:
+		store.applySetAdd(key, fields[0], version)
+	case record.Op == walOpSetRemove && len(fields) == 1:
+		store.applySetRemove(key, fields[0], version)
+	}
+}
+
+func isStructureRecord(op byte) bool {
+	return op >= walOpStructurePut && op <= walOpSetRemove
+}
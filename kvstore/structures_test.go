@@ -0,0 +1,214 @@
+package kvstore_test
+
+import (
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashOperations(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	created, version1, err := store.HashSet("user", "name", "ann")
+	assert.Error(nil, err)
+	assert.True("created", created)
+	created, version2, _ := store.HashSet("user", "name", "bob")
+	assert.False("created again", created)
+	assert.True("version2 > version1", version2 > version1)
+	store.HashSet("user", "age", "42")
+
+	value, err := store.HashGet("user", "name")
+	assert.Error(nil, err)
+	assert.String("name", "bob", value)
+	_, err = store.HashGet("user", "email")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+	entries, _ := store.HashGetAll("user")
+	assert.Int("fields", 2, len(entries))
+	assert.String("first field", "age", entries[0].Key)
+	assert.String("second value", "bob", entries[1].Value)
+
+	deleted, _, _ := store.HashDelete("user", "age")
+	assert.True("deleted", deleted)
+	deleted, _, _ = store.HashDelete("user", "age")
+	assert.False("deleted again", deleted)
+	store.HashDelete("user", "name")
+	assert.Int("keys once empty", 0, len(store.ListKeys()))
+}
+
+func TestListOperations(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	store.ListPush("queue", "b", false)
+	store.ListPush("queue", "c", false)
+	length, _, err := store.ListPush("queue", "a", true)
+	assert.Error(nil, err)
+	assert.Int("length", 3, length)
+
+	values, _ := store.ListRange("queue", 0, -1)
+	assert.String("all", "a,b,c", strings.Join(values, ","))
+	values, _ = store.ListRange("queue", -2, 10)
+	assert.String("clamped", "b,c", strings.Join(values, ","))
+	values, _ = store.ListRange("queue", 2, 1)
+	assert.Int("empty range", 0, len(values))
+	values, err = store.ListRange("missing", 0, -1)
+	assert.Error(nil, err)
+	assert.Int("missing", 0, len(values))
+
+	value, _, _ := store.ListPop("queue", true)
+	assert.String("front", "a", value)
+	value, _, _ = store.ListPop("queue", false)
+	assert.String("back", "c", value)
+	store.ListPop("queue", false)
+	_, _, err = store.ListPop("queue", false)
+	assert.Error(kvstore.ErrKeyNotFound, err)
+}
+
+func TestSetOperations(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	added, _, err := store.SetAdd("tags", "red")
+	assert.Error(nil, err)
+	assert.True("added", added)
+	added, _, _ = store.SetAdd("tags", "red")
+	assert.False("added again", added)
+	store.SetAdd("tags", "blue")
+
+	members, _ := store.SetMembers("tags")
+	assert.String("members", "blue,red", strings.Join(members, ","))
+	isMember, _ := store.SetIsMember("tags", "red")
+	assert.True("red", isMember)
+	isMember, _ = store.SetIsMember("tags", "green")
+	assert.False("green", isMember)
+
+	removed, _, _ := store.SetRemove("tags", "red")
+	assert.True("removed", removed)
+	removed, _, _ = store.SetRemove("tags", "red")
+	assert.False("removed again", removed)
+}
+
+func TestOperationsAgainstWrongKind(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	store.Upsert("text", "abc")
+	store.SetAdd("tags", "red")
+
+	_, _, err := store.HashSet("text", "field", "value")
+	assert.Error(kvstore.ErrWrongType, err)
+	_, _, err = store.ListPush("tags", "value", false)
+	assert.Error(kvstore.ErrWrongType, err)
+	_, err = store.Get("tags")
+	assert.Error(kvstore.ErrWrongType, err)
+	_, _, err = store.Increment("tags")
+	assert.Error(kvstore.ErrWrongType, err)
+	_, err = store.CompareAndSwap("tags", "", "value")
+	assert.Error(kvstore.ErrWrongType, err)
+
+	// a plain write replaces whatever the key held...
+	store.Upsert("tags", "plain")
+	value, _ := store.Get("tags")
+	assert.String("replaced", "plain", value)
+}
+
+func TestStructuresKeepExpiry(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	store.SetAdd("tags", "red")
+	assert.Error(nil, store.Expire("tags", time.Hour))
+	store.SetAdd("tags", "blue")
+	ttl, err := store.TTL("tags")
+	assert.Error(nil, err)
+	assert.True("ttl kept", ttl > 0)
+}
+
+func TestUpsertStructureVersion(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	version, err := store.UpsertStructureVersion("user", kvstore.KindHash, []string{"age", "42", "name", "ann"}, remoteVersion)
+	assert.Error(nil, err)
+	assert.Uint64("version", remoteVersion, version)
+	value, _ := store.HashGet("user", "name")
+	assert.String("name", "ann", value)
+
+	_, err = store.UpsertStructureVersion("user", kvstore.KindHash, []string{"name", "old"}, remoteVersion-1)
+	assert.Error(kvstore.ErrStaleVersion, err)
+	kind, elements, actualVersion, _ := store.GetStructure("user")
+	assert.True("kind", kind == kvstore.KindHash)
+	assert.String("elements", "age,42,name,ann", strings.Join(elements, ","))
+	assert.Uint64("version kept", remoteVersion, actualVersion)
+
+	_, err = store.UpsertStructureVersion("user", kvstore.KindHash, []string{"odd"}, remoteVersion+1)
+	assert.Error(kvstore.ErrWrongType, err)
+}
+
+func TestStructuresRestoreFromLogAndSnapshot(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	path := filepath.Join(t.TempDir(), "store.wal")
+
+	store := createLoggedTestObject(path, kvstore.LogSyncAlways)
+	if err := store.Open(); err != nil {
+		t.Fatalf("test setup failure (open): %s", err.Error())
+	}
+	store.HashSet("user", "name", "ann")
+	store.ListPush("queue", "a", false)
+	store.ListPush("queue", "b", false)
+	store.SetAdd("tags", "red")
+	assert.Error(nil, store.Snapshot())
+	store.HashSet("user", "age", "42")
+	store.ListPop("queue", true)
+	store.SetAdd("tags", "blue")
+	store.SetRemove("tags", "red")
+	store.Close()
+
+	store = createLoggedTestObject(path, kvstore.LogSyncAlways)
+	assert.Error(nil, store.Open())
+	defer store.Close()
+	entries, _ := store.HashGetAll("user")
+	assert.Int("fields", 2, len(entries))
+	values, _ := store.ListRange("queue", 0, -1)
+	assert.String("queue", "b", strings.Join(values, ","))
+	members, _ := store.SetMembers("tags")
+	assert.String("tags", "blue", strings.Join(members, ","))
+}
+
+func TestStructuresCountTowardsMemory(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := kvstore.NewKvStoreWithOptions(kvstore.KvStoreOptions{MaxMemory: 20})
+	store.Open()
+	defer store.Close()
+
+	store.ListPush("list", "0123456789", false)
+	assert.Int("memory", 14, int(store.MemoryStats().Memory))
+	_, _, err := store.ListPush("list", "0123456789", false)
+	assert.Error(kvstore.ErrOutOfMemory, err)
+	store.ListPop("list", false)
+	assert.Int("memory once empty", 0, int(store.MemoryStats().Memory))
+}
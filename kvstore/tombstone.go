@@ -0,0 +1,67 @@
+package kvstore
+
+import (
+	"time"
+)
+
+// a deleted key is remembered, with the version of its deletion, for a while after it goes: a
+// write replicated from elsewhere in the cluster that is older than the delete, but arrives
+// after it, would otherwise bring the key back. Once the grace period has passed any write still
+// on its way is assumed to have arrived. Tombstones are held in memory alone, so those in a log
+// that has been compacted away are forgotten on a restart.
+const DefaultTombstoneGracePeriod time.Duration = 5 * time.Minute
+
+type tombstone struct {
+	version uint64
+	deleted time.Time
+}
+
+type tombstones struct {
+	entries   map[string]tombstone
+	grace     time.Duration
+	collected time.Time // when the tombstones past their grace period were last let go
+}
+
+func newTombstones(grace time.Duration) *tombstones {
+	if grace <= 0 {
+		grace = DefaultTombstoneGracePeriod
+	}
+	return &tombstones{entries: make(map[string]tombstone), grace: grace, collected: time.Now()}
+}
+
+// the version key was deleted at, or zero if it hasn't been, or not recently...
+func (t *tombstones) version(key string) uint64 {
+	return t.entries[key].version
+}
+
+func (t *tombstones) add(key string, version uint64, now time.Time) {
+	if current, exists := t.entries[key]; exists && current.version > version {
+		return
+	}
+	t.entries[key] = tombstone{version: version, deleted: now}
+	t.collect(now)
+}
+
+func (t *tombstones) remove(key string) {
+	delete(t.entries, key)
+}
+
+// lets go of the tombstones past their grace period, no more than once every half a grace period,
+// so that the cost of looking through them is spread across the deletes that made them...
+func (t *tombstones) collect(now time.Time) {
+	if now.Sub(t.collected) < t.grace/2 {
+		return
+	}
+	t.collected = now
+	for key, entry := range t.entries {
+		if now.Sub(entry.deleted) >= t.grace {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// whether a delete at version wins over a key's current version; a write and a delete given the
+// same version on different nodes are settled in favour of the write, the same way everywhere...
+func deleteSupersedes(version uint64, currentVersion uint64) bool {
+	return version > currentVersion
+}
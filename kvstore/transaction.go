@@ -0,0 +1,173 @@
+package kvstore
+
+import (
+	"errors"
+	"time"
+)
+
+type OperationCommand int
+
+const (
+	OperationGet OperationCommand = iota
+	OperationUpsert
+	OperationDelete
+)
+
+// Operation is a single step of a transaction.
+type Operation struct {
+	Command OperationCommand
+	Key     string
+	Value   string
+}
+
+// OperationResult is the outcome of a single step of a transaction; Value is only set for gets.
+type OperationResult struct {
+	Value   string
+	Version uint64
+	Error   error
+}
+
+// a committed transaction is logged as one record holding the payloads of its
+// individual records, so a torn write loses all of it rather than part of it...
+const walOpBatch byte = 6
+
+var ErrTransactionAborted = errors.New("transaction aborted")
+
+type walBatch struct {
+	records  []walRecord
+	undo     map[string]kvUndo
+	revision uint64
+	events   []ChangeEvent
+}
+
+// what a key looked like before the batch first touched it...
+type kvUndo struct {
+	item      *kvItem
+	expiry    time.Time
+	volatile  bool
+	tombstone tombstone
+	buried    bool
+}
+
+// Transact runs operations in order as a single request, so no other request interleaves
+// with them. If any key in watched no longer has the version given (zero meaning absent)
+// nothing is run and ErrTransactionAborted is returned.
+func (store *KvStore) Transact(operations []Operation, watched map[string]uint64) ([]OperationResult, error) {
+	request := kvStoreRequest{
+		Command:    kvCommandTransact,
+		Key:        "",
+		Value:      "",
+		Operations: operations,
+		Watched:    watched,
+		Results:    make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return response.Operations, response.Error
+}
+
+func (store *KvStore) transact(operations []Operation, watched map[string]uint64) ([]OperationResult, error) {
+	now := time.Now()
+	for key, expectedVersion := range watched {
+		store.expireIfDue(key, now)
+		actualVersion := uint64(0)
+		if item, exists := store.items[key]; exists {
+			actualVersion = item.version
+		}
+		if actualVersion != expectedVersion {
+			return nil, ErrTransactionAborted
+		}
+	}
+
+	store.beginBatch()
+	results := make([]OperationResult, len(operations))
+	for i, operation := range operations {
+		store.expireIfDue(operation.Key, now)
+		result := &results[i]
+		switch operation.Command {
+		case OperationGet:
+			if item, exists := store.items[operation.Key]; exists && item.kind != KindString {
+				result.Error = ErrWrongType
+			} else if exists {
+				result.Value, result.Version = item.value, item.version
+			} else {
+				result.Error = ErrKeyNotFound
+			}
+		case OperationUpsert:
+			result.Version, result.Error = store.upsert(operation.Key, operation.Value)
+		case OperationDelete:
+			result.Version, result.Error = store.delete(operation.Key)
+		}
+	}
+	if err := store.commitBatch(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// from here until commitBatch, log records are collected rather than written...
+func (store *KvStore) beginBatch() {
+	if store.log != nil {
+		store.batch = &walBatch{undo: make(map[string]kvUndo), revision: store.revision}
+	}
+}
+
+func (batch *walBatch) add(store *KvStore, record walRecord) {
+	if len(record.Fields) > 0 {
+		key := record.Fields[0]
+		if _, remembered := batch.undo[key]; !remembered {
+			expiry, volatile := store.expiries[key]
+			tombstone, buried := store.deleted.entries[key]
+			batch.undo[key] = kvUndo{item: store.items[key], expiry: expiry, volatile: volatile, tombstone: tombstone, buried: buried}
+		}
+	}
+	batch.records = append(batch.records, record)
+}
+
+// writes the collected records as one, putting everything back as it was if that fails...
+func (store *KvStore) commitBatch() error {
+	batch := store.batch
+	store.batch = nil
+	if batch == nil || len(batch.records) == 0 {
+		return nil
+	}
+	fields := make([]string, len(batch.records))
+	for i, record := range batch.records {
+		fields[i] = string(encodeWalPayload(record))
+	}
+	err := store.log.append(walRecord{Op: walOpBatch, Fields: fields})
+	if err != nil {
+		store.rollBack(batch)
+		return err
+	}
+	for _, event := range batch.events {
+		store.notify(event)
+	}
+	return nil
+}
+
+// puts everything the batch touched back as it was, without logging anything...
+func (store *KvStore) abortBatch() {
+	if batch := store.batch; batch != nil {
+		store.batch = nil
+		store.rollBack(batch)
+	}
+}
+
+func (store *KvStore) rollBack(batch *walBatch) {
+	for key, undo := range batch.undo {
+		store.removeItem(key)
+		if undo.item != nil {
+			store.restoreItem(key, undo.item)
+		}
+		if undo.volatile {
+			store.expiries[key] = undo.expiry
+		}
+		store.deleted.remove(key)
+		if undo.buried {
+			store.deleted.entries[key] = undo.tombstone
+		}
+	}
+	store.revision = batch.revision
+}
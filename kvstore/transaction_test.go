@@ -0,0 +1,84 @@
+package kvstore_test
+
+import (
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"path/filepath"
+	"testing"
+)
+
+func TestTransactRunsOperationsInOrder(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	store.Upsert("from", "10")
+	results, err := store.Transact([]kvstore.Operation{
+		{Command: kvstore.OperationGet, Key: "from"},
+		{Command: kvstore.OperationUpsert, Key: "from", Value: "5"},
+		{Command: kvstore.OperationUpsert, Key: "to", Value: "5"},
+		{Command: kvstore.OperationDelete, Key: "from"},
+		{Command: kvstore.OperationGet, Key: "from"},
+	}, nil)
+	assert.Error(nil, err)
+	if len(results) != 5 {
+		t.Fatalf("param: len(results), expected: 5, actual: %d", len(results))
+	}
+	assert.String("results[0].Value", "10", results[0].Value)
+	assert.True("results[2].Version > results[1].Version", results[2].Version > results[1].Version)
+	assert.Error(kvstore.ErrKeyNotFound, results[4].Error)
+
+	actualValue, _ := store.Get("to")
+	assert.String("to", "5", actualValue)
+}
+
+func TestTransactAbortsWhenWatchedKeyChanged(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	version, _ := store.Upsert("watched", "before")
+	store.Upsert("watched", "after")
+	results, err := store.Transact([]kvstore.Operation{
+		{Command: kvstore.OperationUpsert, Key: "other", Value: "value"},
+	}, map[string]uint64{"watched": version, "absent": 0})
+	assert.Error(kvstore.ErrTransactionAborted, err)
+	assert.Boolean("results", true, results == nil)
+	_, err = store.Get("other")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+
+	_, version, _ = store.GetWithVersion("watched")
+	_, err = store.Transact([]kvstore.Operation{
+		{Command: kvstore.OperationUpsert, Key: "other", Value: "value"},
+	}, map[string]uint64{"watched": version, "absent": 0})
+	assert.Error(nil, err)
+}
+
+func TestTransactSurvivesReopen(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	path := filepath.Join(t.TempDir(), "store.wal")
+
+	store := createLoggedTestObject(path, kvstore.LogSyncAlways)
+	if err := store.Open(); err != nil {
+		t.Fatalf("test setup failure (open): %s", err.Error())
+	}
+	store.Upsert("from", "10")
+	store.Transact([]kvstore.Operation{
+		{Command: kvstore.OperationDelete, Key: "from"},
+		{Command: kvstore.OperationUpsert, Key: "to", Value: "10"},
+	}, nil)
+	store.Close()
+
+	store = createLoggedTestObject(path, kvstore.LogSyncAlways)
+	assert.Error(nil, store.Open())
+	defer store.Close()
+	_, err := store.Get("from")
+	assert.Error(kvstore.ErrKeyNotFound, err)
+	actualValue, _ := store.Get("to")
+	assert.String("to", "10", actualValue)
+}
@@ -0,0 +1,133 @@
+package kvstore
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// every write is stamped with the next tick of a store-wide hybrid logical clock, so each key's
+// version only ever increases, even across a delete and re-insert...
+const walOpRevision byte = 5
+
+// versions hold the wall clock in milliseconds above a counter that orders writes made in the
+// same millisecond, or while the clock is behind a version seen from elsewhere in the cluster;
+// a node that has made fewer writes than its peers still stamps its writes with the time...
+const versionCounterBits = 16
+
+var ErrStaleVersion = errors.New("stale version")
+
+// GetWithVersion returns the value of a key along with its current version. For a key that has
+// been deleted recently it returns ErrKeyNotFound along with the version of the deletion.
+func (store *KvStore) GetWithVersion(key string) (string, uint64, error) {
+	request := kvStoreRequest{
+		Command: kvCommandGetWithVersion,
+		Key:     key,
+		Value:   "",
+		Results: make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return response.Value, response.Version, response.Error
+}
+
+// UpsertVersion applies a write made elsewhere in the cluster, keeping the version it was given
+// there; it is ignored with ErrStaleVersion if the key already has a newer version, or the same
+// version and a value that sorts at or after the one given, so every node settles on one value.
+func (store *KvStore) UpsertVersion(key string, value string, version uint64) (uint64, error) {
+	request := kvStoreRequest{
+		Command: kvCommandUpsertVersion,
+		Key:     key,
+		Value:   value,
+		Version: version,
+		Results: make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return response.Version, response.Error
+}
+
+// DeleteVersion applies a delete made elsewhere in the cluster, keeping the version it was given
+// there; it is ignored with ErrStaleVersion if the key already has a write at or after that
+// version. The key is remembered as deleted, so that older writes arriving later are ignored too.
+func (store *KvStore) DeleteVersion(key string, version uint64) (uint64, error) {
+	request := kvStoreRequest{
+		Command: kvCommandDeleteVersion,
+		Key:     key,
+		Version: version,
+		Results: make(chan kvStoreResponse),
+	}
+	store.query(request)
+	response := <-request.Results
+	defer close(request.Results)
+	return response.Version, response.Error
+}
+
+func (store *KvStore) upsertReplicated(key string, value string, version uint64) (uint64, error) {
+	if current, exists := store.items[key]; exists && !supersedes(version, value, current.version, current.value) {
+		return current.version, ErrStaleVersion
+	}
+	if deleted := store.deleted.version(key); deleted > 0 && !supersedes(version, value, deleted, "") {
+		return deleted, ErrStaleVersion
+	}
+	if err := store.makeRoom(key, value); err != nil {
+		return 0, err
+	}
+	return store.upsertVersion(key, value, version)
+}
+
+func (store *KvStore) deleteReplicated(key string, version uint64) (uint64, error) {
+	if current, exists := store.items[key]; exists && !deleteSupersedes(version, current.version) {
+		return current.version, ErrStaleVersion
+	}
+	if deleted := store.deleted.version(key); deleted >= version {
+		return deleted, ErrStaleVersion
+	}
+	if err := store.writeAhead(walOpDelete, key, formatVersion(version)); err != nil {
+		return 0, err
+	}
+	previous, exists := store.items[key]
+	store.removeItem(key)
+	store.deleted.add(key, version, time.Now())
+	store.observeVersion(version)
+	if exists {
+		store.publish(ChangeEvent{Op: ChangeDelete, Key: key, OldValue: previous.value, Version: version})
+	}
+	return version, nil
+}
+
+// the version for a write made after revision at the given time...
+func nextVersion(revision uint64, now time.Time) uint64 {
+	if clock := uint64(now.UnixMilli()) << versionCounterBits; clock > revision {
+		return clock
+	}
+	return revision + 1
+}
+
+// whether a replicated write wins over what a key holds; writes stamped with the same version
+// on different nodes are settled by their values, the same way on every node...
+func supersedes(version uint64, value string, currentVersion uint64, currentValue string) bool {
+	return version > currentVersion || (version == currentVersion && value > currentValue)
+}
+
+func (store *KvStore) observeVersion(version uint64) {
+	if version > store.revision {
+		store.revision = version
+	}
+}
+
+// reads the version held in a log record field, falling back to the next revision for older records...
+func recordVersion(record walRecord, field int, revision uint64) uint64 {
+	if field < len(record.Fields) {
+		if version, err := strconv.ParseUint(record.Fields[field], 10, 64); err == nil {
+			return version
+		}
+	}
+	return revision + 1
+}
+
+func formatVersion(version uint64) string {
+	return strconv.FormatUint(version, 10)
+}
@@ -0,0 +1,213 @@
+package kvstore_test
+
+import (
+	"fmt"
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// a version from well ahead of the clock, as if written by a node whose clock runs fast...
+const remoteVersion uint64 = 1 << 62
+
+func TestWritesReturnIncreasingVersions(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	version1, _ := store.Upsert("key", "value1")
+	version2, _ := store.Upsert("key", "value2")
+	unchanged, _ := store.Upsert("key", "value2")
+	deleted, _ := store.Delete("key")
+	recreated, _ := store.Upsert("key", "value3")
+	assert.True("version1 > 0", version1 > 0)
+	assert.True("version2 > version1", version2 > version1)
+	assert.Uint64("unchanged", version2, unchanged)
+	assert.True("deleted > version2", deleted > version2)
+	assert.True("recreated > deleted", recreated > deleted)
+
+	value, version, err := store.GetWithVersion("key")
+	assert.String("value", "value3", value)
+	assert.Uint64("version", recreated, version)
+	assert.Error(nil, err)
+
+	swapped, _ := store.CompareAndSwap("key", "value3", "value4")
+	assert.True("swapped > recreated", swapped > recreated)
+}
+
+func TestGetWithVersionReturnsErrorOnUnknownKey(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	_, version, err := store.GetWithVersion("missing")
+	assert.Uint64("version", 0, version)
+	assert.Error(kvstore.ErrKeyNotFound, err)
+}
+
+func TestUpsertVersionIgnoresStaleWrites(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	version, err := store.UpsertVersion("key", "remote", remoteVersion)
+	assert.Uint64("version", remoteVersion, version)
+	assert.Error(nil, err)
+
+	version, err = store.UpsertVersion("key", "stale", remoteVersion-1)
+	assert.Uint64("version", remoteVersion, version)
+	assert.Error(kvstore.ErrStaleVersion, err)
+	value, _ := store.Get("key")
+	assert.String("value", "remote", value)
+
+	// local writes carry on from the highest version seen, even one ahead of the clock...
+	version, _ = store.Upsert("key", "local")
+	assert.Uint64("version", remoteVersion+1, version)
+}
+
+func TestUpsertVersionSettlesEqualVersionsByValue(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	first, second := createTestObject(), createTestObject()
+	first.Open()
+	defer first.Close()
+	second.Open()
+	defer second.Close()
+
+	// both nodes write the key with the same version, then hear about each other's write...
+	first.UpsertVersion("key", "apple", remoteVersion)
+	second.UpsertVersion("key", "banana", remoteVersion)
+	_, err := first.UpsertVersion("key", "banana", remoteVersion)
+	assert.Error(nil, err)
+	_, err = second.UpsertVersion("key", "apple", remoteVersion)
+	assert.Error(kvstore.ErrStaleVersion, err)
+
+	firstValue, _ := first.Get("key")
+	secondValue, _ := second.Get("key")
+	assert.String("first", "banana", firstValue)
+	assert.String("second", "banana", secondValue)
+}
+
+func TestWritesFromNodeWithFewerWritesAreNotStale(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	busy, quiet := createTestObject(), createTestObject()
+	busy.Open()
+	defer busy.Close()
+	quiet.Open()
+	defer quiet.Close()
+
+	for i := 0; i < 1000; i++ {
+		busy.Upsert(fmt.Sprintf("other-%d", i), "value")
+	}
+	busyVersion, _ := busy.Upsert("key", "from busy")
+	time.Sleep(2 * time.Millisecond)
+
+	// the quiet node has seen none of that, but its later write still wins...
+	quietVersion, _ := quiet.Upsert("key", "from quiet")
+	assert.True("quietVersion > busyVersion", quietVersion > busyVersion)
+	_, err := busy.UpsertVersion("key", "from quiet", quietVersion)
+	assert.Error(nil, err)
+	value, _ := busy.Get("key")
+	assert.String("value", "from quiet", value)
+}
+
+func TestVersionsSurviveReopenAndSnapshot(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	path := filepath.Join(t.TempDir(), "store.wal")
+
+	store := createLoggedTestObject(path, kvstore.LogSyncAlways)
+	if err := store.Open(); err != nil {
+		t.Fatalf("test setup failure (open): %s", err.Error())
+	}
+	store.Upsert("key", "value1")
+	expectedVersion, _ := store.Upsert("key", "value2")
+	store.Snapshot()
+	store.Upsert("deleted", "value")
+	deleted, _ := store.Delete("deleted")
+	store.Close()
+
+	store = createLoggedTestObject(path, kvstore.LogSyncAlways)
+	assert.Error(nil, store.Open())
+	defer store.Close()
+	_, version, _ := store.GetWithVersion("key")
+	assert.Uint64("version", expectedVersion, version)
+	next, _ := store.Upsert("new", "value")
+	assert.True("next > deleted", next > deleted)
+}
+
+func TestLateWritesDoNotResurrectDeletedKeys(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	// the delete reaches this node before a write made ahead of it elsewhere...
+	store.UpsertVersion("key", "value", remoteVersion)
+	version, err := store.DeleteVersion("key", remoteVersion+2)
+	assert.Uint64("version", remoteVersion+2, version)
+	assert.Error(nil, err)
+	_, err = store.UpsertVersion("key", "late", remoteVersion+1)
+	assert.Error(kvstore.ErrStaleVersion, err)
+	_, version, err = store.GetWithVersion("key")
+	assert.Uint64("version", remoteVersion+2, version)
+	assert.Error(kvstore.ErrKeyNotFound, err)
+
+	// while a write after the delete brings the key back...
+	_, err = store.UpsertVersion("key", "later", remoteVersion+3)
+	assert.Error(nil, err)
+	value, _ := store.Get("key")
+	assert.String("value", "later", value)
+}
+
+func TestDeleteVersionIgnoresStaleDeletes(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	store.UpsertVersion("key", "value", remoteVersion)
+	version, err := store.DeleteVersion("key", remoteVersion)
+	assert.Uint64("version", remoteVersion, version)
+	assert.Error(kvstore.ErrStaleVersion, err)
+	version, err = store.DeleteVersion("key", remoteVersion-1)
+	assert.Uint64("version", remoteVersion, version)
+	assert.Error(kvstore.ErrStaleVersion, err)
+	value, _ := store.Get("key")
+	assert.String("value", "value", value)
+
+	// a delete that arrives twice is only applied once...
+	store.DeleteVersion("key", remoteVersion+1)
+	_, err = store.DeleteVersion("key", remoteVersion+1)
+	assert.Error(kvstore.ErrStaleVersion, err)
+}
+
+func TestTombstonesAreCollectedAfterTheGracePeriod(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := kvstore.NewKvStoreWithOptions(kvstore.KvStoreOptions{TombstoneGracePeriod: 20 * time.Millisecond})
+	store.Open()
+	defer store.Close()
+
+	store.DeleteVersion("key", remoteVersion)
+	time.Sleep(30 * time.Millisecond)
+	// tombstones are let go of as later deletes are made...
+	store.Upsert("other", "value")
+	store.Delete("other")
+
+	_, version, _ := store.GetWithVersion("key")
+	assert.Uint64("version", 0, version)
+	_, err := store.UpsertVersion("key", "late", remoteVersion-1)
+	assert.Error(nil, err)
+}
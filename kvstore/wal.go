@@ -0,0 +1,302 @@
+package kvstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// LogSyncPolicy controls when appended log records are flushed to stable storage.
+type LogSyncPolicy int
+
+const (
+	LogSyncAlways   LogSyncPolicy = iota // fsync after every record
+	LogSyncInterval                      // fsync every LogSyncInterval
+	LogSyncNever                         // leave flushing to the operating system
+)
+
+const DefaultLogSyncInterval time.Duration = 100 * time.Millisecond
+
+// record layout (integers are little-endian):
+//
+//	[uint32 payload length][uint32 crc32 of payload][payload]
+//
+// payload layout:
+//
+//	[byte op][uint32 field count]{[uint32 field length][field bytes]}...
+const walHeaderSize int = 8
+
+const walOpUpsert byte = 1
+const walOpDelete byte = 2
+
+var ErrLogCorrupt = errors.New("log corrupt")
+
+type walRecord struct {
+	Op     byte
+	Fields []string
+}
+
+type writeAheadLog struct {
+	path   string
+	file   *os.File
+	policy LogSyncPolicy
+	dirty  bool
+	size   int64
+	failed error // set if a failed append couldn't be undone, after which nothing more is appended
+}
+
+func ParseLogSyncPolicy(value string) (LogSyncPolicy, error) {
+	switch strings.ToLower(value) {
+	case "always":
+		return LogSyncAlways, nil
+	case "interval":
+		return LogSyncInterval, nil
+	case "never":
+		return LogSyncNever, nil
+	}
+	return LogSyncAlways, fmt.Errorf("unknown log sync policy '%s'", value)
+}
+
+// opens (creating if necessary) the log at path, passing every intact record to apply...
+func openWriteAheadLog(path string, policy LogSyncPolicy, apply func(record walRecord)) (*writeAheadLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	size, err := replayWriteAheadLog(file, apply)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &writeAheadLog{path: path, file: file, policy: policy, size: size}, nil
+}
+
+// replays a log that is no longer being appended to, such as the segment left behind by an interrupted snapshot...
+func replayWriteAheadLogFile(path string, apply func(record walRecord)) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+	_, err = replayWriteAheadLog(file, apply)
+	return err
+}
+
+// replays the log from the start, truncating a torn final record, and returns the intact size...
+func replayWriteAheadLog(file *os.File, apply func(record walRecord)) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	reader := bufio.NewReader(file)
+	offset := int64(0)
+	for offset < size {
+		record, length, err := readWalRecord(reader, size-offset)
+		if err != nil {
+			if offset+length < size {
+				// the damage isn't at the tail, so this wasn't a torn write...
+				fmt.Printf("store: bad log record at offset %d\n", offset)
+				return 0, ErrLogCorrupt
+			}
+			fmt.Printf("store: truncating torn log record at offset %d\n", offset)
+			return offset, file.Truncate(offset)
+		}
+		apply(record)
+		offset += length
+	}
+	return offset, nil
+}
+
+// reads one record, returning the number of bytes it claims to occupy, or just its header's if
+// its length is damaged...
+func readWalRecord(reader io.Reader, remaining int64) (walRecord, int64, error) {
+	header := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return walRecord{}, remaining, err
+	}
+	payloadLength := int64(binary.LittleEndian.Uint32(header[0:4]))
+	length := int64(walHeaderSize) + payloadLength
+	if length > remaining {
+		// a torn write leaves part of one record at the end of the log, where a damaged length
+		// claims more than is left with whole records still after it...
+		rest, err := io.ReadAll(reader)
+		if err != nil {
+			return walRecord{}, remaining, err
+		}
+		if containsWalRecord(rest) {
+			return walRecord{}, int64(walHeaderSize), ErrLogCorrupt
+		}
+		return walRecord{}, remaining, io.ErrUnexpectedEOF
+	}
+	payload := make([]byte, payloadLength)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return walRecord{}, remaining, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+		return walRecord{}, length, ErrLogCorrupt
+	}
+	record, err := decodeWalPayload(payload)
+	return record, length, err
+}
+
+// whether an intact record starts anywhere in data...
+func containsWalRecord(data []byte) bool {
+	for start := 0; start+walHeaderSize <= len(data); start++ {
+		end := uint64(start+walHeaderSize) + uint64(binary.LittleEndian.Uint32(data[start:start+4]))
+		if end > uint64(len(data)) {
+			continue
+		}
+		payload := data[start+walHeaderSize : end]
+		if _, err := decodeWalPayload(payload); err == nil && crc32.ChecksumIEEE(payload) == binary.LittleEndian.Uint32(data[start+4:start+8]) {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeWalPayload(payload []byte) (walRecord, error) {
+	if len(payload) < 5 {
+		return walRecord{}, ErrLogCorrupt
+	}
+	record := walRecord{Op: payload[0]}
+	count := binary.LittleEndian.Uint32(payload[1:5])
+	payload = payload[5:]
+	for i := uint32(0); i < count; i++ {
+		if len(payload) < 4 {
+			return walRecord{}, ErrLogCorrupt
+		}
+		fieldLength := binary.LittleEndian.Uint32(payload[0:4])
+		payload = payload[4:]
+		if uint64(len(payload)) < uint64(fieldLength) {
+			return walRecord{}, ErrLogCorrupt
+		}
+		record.Fields = append(record.Fields, string(payload[:fieldLength]))
+		payload = payload[fieldLength:]
+	}
+	return record, nil
+}
+
+func encodeWalRecord(record walRecord) []byte {
+	result := appendWalPayload(make([]byte, walHeaderSize, walHeaderSize+walPayloadLength(record)), record)
+	payload := result[walHeaderSize:]
+	binary.LittleEndian.PutUint32(result[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(result[4:8], crc32.ChecksumIEEE(payload))
+	return result
+}
+
+func encodeWalPayload(record walRecord) []byte {
+	return appendWalPayload(make([]byte, 0, walPayloadLength(record)), record)
+}
+
+func walPayloadLength(record walRecord) int {
+	payloadLength := 5
+	for _, field := range record.Fields {
+		payloadLength += 4 + len(field)
+	}
+	return payloadLength
+}
+
+func appendWalPayload(buffer []byte, record walRecord) []byte {
+	buffer = append(buffer, record.Op)
+	buffer = appendUint32(buffer, uint32(len(record.Fields)))
+	for _, field := range record.Fields {
+		buffer = appendUint32(buffer, uint32(len(field)))
+		buffer = append(buffer, field...)
+	}
+	return buffer
+}
+
+func (log *writeAheadLog) append(record walRecord) error {
+	if log.failed != nil {
+		return log.failed
+	}
+	written, err := log.file.Write(encodeWalRecord(record))
+	if err != nil {
+		// don't leave part of a record behind for the next one to be appended after...
+		if written > 0 {
+			if truncateErr := log.file.Truncate(log.size); truncateErr != nil {
+				fmt.Printf("store: unable to remove partly written log record, error '%s'\n", truncateErr.Error())
+				log.failed = err
+			}
+		}
+		return err
+	}
+	log.size += int64(written)
+	if log.policy == LogSyncAlways {
+		return log.file.Sync()
+	}
+	log.dirty = true
+	return nil
+}
+
+func (log *writeAheadLog) sync() error {
+	if !log.dirty {
+		return nil
+	}
+	log.dirty = false
+	return log.file.Sync()
+}
+
+// moves the current contents aside to previousPath, which must not exist yet, and starts a fresh, empty log...
+func (log *writeAheadLog) rotate(previousPath string) error {
+	if err := log.file.Sync(); err != nil {
+		return err
+	}
+	log.dirty = false
+	if err := log.file.Close(); err != nil {
+		return err
+	}
+	renameErr := os.Rename(log.path, previousPath)
+	file, err := os.OpenFile(log.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	log.file = file
+	if renameErr != nil {
+		return renameErr
+	}
+	log.size = 0
+	return nil
+}
+
+func (log *writeAheadLog) close() error {
+	if log.policy != LogSyncNever {
+		_ = log.sync()
+	}
+	return log.file.Close()
+}
+
+func appendUint32(buffer []byte, value uint32) []byte {
+	var encoded [4]byte
+	binary.LittleEndian.PutUint32(encoded[:], value)
+	return append(buffer, encoded[:]...)
+}
+
+// flushes the directory holding path, so that a file just renamed into it survives a crash. windows
+// can't open a directory to sync it, and journals the rename itself...
+func syncDirectory(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	directory, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	err = directory.Sync()
+	if closeErr := directory.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+package kvstore_test
+
+import (
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createLoggedTestObject(path string, policy kvstore.LogSyncPolicy) *kvstore.KvStore {
+	return kvstore.NewKvStoreWithOptions(kvstore.KvStoreOptions{LogPath: path, LogSyncPolicy: policy})
+}
+
+func TestLogReplaysWritesOnOpen(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	path := filepath.Join(t.TempDir(), "store.wal")
+
+	for _, policy := range []kvstore.LogSyncPolicy{kvstore.LogSyncAlways, kvstore.LogSyncInterval, kvstore.LogSyncNever} {
+		store := createLoggedTestObject(path, policy)
+		if err := store.Open(); err != nil {
+			t.Fatalf("test setup failure (open): %s", err.Error())
+		}
+		store.Upsert("kept", "value1")
+		store.Upsert("kept", "value2")
+		store.Upsert("deleted", "value")
+		store.Delete("deleted")
+		store.Close()
+
+		store = createLoggedTestObject(path, policy)
+		assert.Error(nil, store.Open())
+		actualValue, err := store.Get("kept")
+		assert.String("value", "value2", actualValue)
+		assert.Error(nil, err)
+		_, err = store.Get("deleted")
+		assert.Error(kvstore.ErrKeyNotFound, err)
+		store.Close()
+	}
+}
+
+func TestLogTruncatesTornFinalRecord(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	path := filepath.Join(t.TempDir(), "store.wal")
+
+	store := createLoggedTestObject(path, kvstore.LogSyncAlways)
+	if err := store.Open(); err != nil {
+		t.Fatalf("test setup failure (open): %s", err.Error())
+	}
+	store.Upsert("key", "value")
+	store.Close()
+	info, _ := os.Stat(path)
+	intactSize := info.Size()
+
+	// simulate a crash part way through writing the next record...
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	_, _ = file.Write([]byte{0x20, 0x00, 0x00, 0x00, 0x01, 0x02})
+	_ = file.Close()
+
+	store = createLoggedTestObject(path, kvstore.LogSyncAlways)
+	assert.Error(nil, store.Open())
+	actualValue, err := store.Get("key")
+	assert.String("value", "value", actualValue)
+	assert.Error(nil, err)
+	store.Close()
+
+	info, _ = os.Stat(path)
+	if info.Size() != intactSize {
+		t.Errorf("param: size, expected: %d, actual: %d", intactSize, info.Size())
+	}
+}
+
+func TestLogRejectsCorruptionBeforeTheTail(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	path := filepath.Join(t.TempDir(), "store.wal")
+
+	store := createLoggedTestObject(path, kvstore.LogSyncAlways)
+	if err := store.Open(); err != nil {
+		t.Fatalf("test setup failure (open): %s", err.Error())
+	}
+	store.Upsert("key1", "value1")
+	store.Upsert("key2", "value2")
+	store.Close()
+
+	// flip a byte inside the first record's payload...
+	data, _ := os.ReadFile(path)
+	data[12] ^= 0xff
+	_ = os.WriteFile(path, data, 0o644)
+
+	store = createLoggedTestObject(path, kvstore.LogSyncAlways)
+	assert.Error(kvstore.ErrLogCorrupt, store.Open())
+}
+
+func TestLogRejectsDamagedLengthBeforeTheTail(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	path := filepath.Join(t.TempDir(), "store.wal")
+
+	store := createLoggedTestObject(path, kvstore.LogSyncAlways)
+	if err := store.Open(); err != nil {
+		t.Fatalf("test setup failure (open): %s", err.Error())
+	}
+	store.Upsert("key1", "value1")
+	info, _ := os.Stat(path)
+	middle := info.Size()
+	store.Upsert("key2", "value2")
+	store.Upsert("key3", "value3")
+	store.Close()
+	info, _ = os.Stat(path)
+	intactSize := info.Size()
+
+	// give the middle record a length running past the end of the log...
+	data, _ := os.ReadFile(path)
+	data[middle+3] = 0x7f
+	_ = os.WriteFile(path, data, 0o644)
+
+	store = createLoggedTestObject(path, kvstore.LogSyncAlways)
+	assert.Error(kvstore.ErrLogCorrupt, store.Open())
+	info, _ = os.Stat(path)
+	if info.Size() != intactSize {
+		t.Errorf("param: size, expected: %d, actual: %d", intactSize, info.Size())
+	}
+}
@@ -0,0 +1,123 @@
+package kvstore
+
+import (
+	"strings"
+	"sync"
+)
+
+// ChangeOp says how a key changed.
+type ChangeOp int
+
+const (
+	ChangeUpsert ChangeOp = iota // written
+	ChangeDelete                 // deleted
+	ChangeExpire                 // removed on reaching its expiry
+	ChangeEvict                  // removed to make room for another write
+)
+
+// how many events a watcher may fall behind by before it is dropped...
+const DefaultWatchBufferSize int = 256
+
+// ChangeEvent describes one change to a key; OldValue is empty if the key didn't exist, and
+// NewValue is empty unless Op is ChangeUpsert.
+type ChangeEvent struct {
+	Op       ChangeOp
+	Key      string
+	OldValue string
+	NewValue string
+	Version  uint64
+}
+
+type kvWatcher struct {
+	prefix string
+	events chan ChangeEvent
+	done   chan struct{} // closed once the store has let go of the watcher
+}
+
+// Watch returns a channel of changes to keys starting with prefix (an empty prefix matches every
+// key), sent from the store as they are made, and a function that stops the watch. A watcher that
+// falls DefaultWatchBufferSize events behind is dropped, closing the channel, rather than holding
+// up the store; closing the store ends every watch in the same way, and watching a store that is
+// closed, or was never opened, returns a channel that is already closed.
+func (store *KvStore) Watch(prefix string) (<-chan ChangeEvent, func()) {
+	watcher := &kvWatcher{
+		prefix: prefix,
+		events: make(chan ChangeEvent, DefaultWatchBufferSize),
+		done:   make(chan struct{}),
+	}
+	request := kvStoreRequest{
+		Command: kvCommandWatch,
+		Key:     "",
+		Value:   "",
+		Watcher: watcher,
+		Results: make(chan kvStoreResponse),
+	}
+	if store.stopped == nil {
+		// never opened, so there is nothing to ask, nor anything to say it has stopped...
+		watcher.close()
+	} else {
+		select {
+		case store.requests <- request:
+			<-request.Results
+			close(request.Results)
+		case <-store.stopped:
+			watcher.close()
+		}
+	}
+	var once sync.Once
+	return watcher.events, func() {
+		once.Do(func() { store.stopWatching(watcher) })
+	}
+}
+
+// stops go over their own channel, which is never closed, so that stopping a watch the store has
+// already let go of (including every watch, once the store is closed) has nothing to wait for...
+func (store *KvStore) stopWatching(watcher *kvWatcher) {
+	select {
+	case store.unwatches <- watcher:
+	case <-watcher.done:
+	}
+}
+
+func (store *KvStore) unwatch(watcher *kvWatcher) {
+	if _, exists := store.watchers[watcher]; exists {
+		delete(store.watchers, watcher)
+		watcher.close()
+	}
+}
+
+func (watcher *kvWatcher) close() {
+	close(watcher.events)
+	close(watcher.done)
+}
+
+func (store *KvStore) unwatchAll() {
+	for watcher := range store.watchers {
+		store.unwatch(watcher)
+	}
+}
+
+// passes a change to its watchers, or holds it until the batch it belongs to is committed...
+func (store *KvStore) publish(event ChangeEvent) {
+	if len(store.watchers) == 0 {
+		return
+	}
+	if store.batch != nil {
+		store.batch.events = append(store.batch.events, event)
+		return
+	}
+	store.notify(event)
+}
+
+func (store *KvStore) notify(event ChangeEvent) {
+	for watcher := range store.watchers {
+		if !strings.HasPrefix(event.Key, watcher.prefix) {
+			continue
+		}
+		select {
+		case watcher.events <- event:
+		default:
+			store.unwatch(watcher)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package kvstore_test
+
+import (
+	"fmt"
+	"kvsapp/assertions"
+	"kvsapp/kvstore"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func receiveEvent(t *testing.T, events <-chan kvstore.ChangeEvent) kvstore.ChangeEvent {
+	select {
+	case event, isOpen := <-events:
+		if !isOpen {
+			t.Fatalf("expected: event, actual: channel closed")
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatalf("expected: event, actual: timeout")
+	}
+	return kvstore.ChangeEvent{}
+}
+
+func assertNoEvent(t *testing.T, events <-chan kvstore.ChangeEvent) {
+	select {
+	case event := <-events:
+		t.Errorf("expected: no event, actual: %v", event)
+	default:
+	}
+}
+
+func TestWatchReceivesMatchingChanges(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+	defer store.Close()
+
+	events, stop := store.Watch("user:")
+	defer stop()
+	version1, _ := store.Upsert("user:1", "a")
+	store.Upsert("group:1", "b")
+	version2, _ := store.Upsert("user:1", "c")
+	deleted, _ := store.Delete("user:1")
+
+	event := receiveEvent(t, events)
+	assert.True("op", event.Op == kvstore.ChangeUpsert)
+	assert.String("key", "user:1", event.Key)
+	assert.String("old", "", event.OldValue)
+	assert.String("new", "a", event.NewValue)
+	assert.Uint64("version", version1, event.Version)
+	event = receiveEvent(t, events)
+	assert.String("old", "a", event.OldValue)
+	assert.String("new", "c", event.NewValue)
+	assert.Uint64("version", version2, event.Version)
+	event = receiveEvent(t, events)
+	assert.True("op", event.Op == kvstore.ChangeDelete)
+	assert.String("old", "c", event.OldValue)
+	assert.Uint64("version", deleted, event.Version)
+	assertNoEvent(t, events)
+}
+
+func TestWatchReportsExpiryAndEviction(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createLimitedTestObject(0, 1, kvstore.EvictionAllKeysLRU)
+	store.Open()
+	defer store.Close()
+
+	events, stop := store.Watch("")
+	defer stop()
+	store.UpsertWithTTL("a", "1", time.Millisecond)
+	receiveEvent(t, events)
+	time.Sleep(5 * time.Millisecond)
+	store.Get("a")
+	assert.True("expire", receiveEvent(t, events).Op == kvstore.ChangeExpire)
+
+	store.Upsert("b", "2")
+	receiveEvent(t, events)
+	store.Upsert("c", "3")
+	event := receiveEvent(t, events)
+	assert.True("evict", event.Op == kvstore.ChangeEvict)
+	assert.String("key", "b", event.Key)
+	assert.String("key", "c", receiveEvent(t, events).Key)
+}
+
+func TestWatchSeesTransactionChangesOnlyOnceCommitted(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createLoggedTestObject(filepath.Join(t.TempDir(), "store.wal"), kvstore.LogSyncNever)
+	store.Open()
+	defer store.Close()
+
+	events, stop := store.Watch("")
+	defer stop()
+	store.Upsert("watched", "1")
+	receiveEvent(t, events)
+
+	_, err := store.Transact([]kvstore.Operation{{Command: kvstore.OperationUpsert, Key: "a", Value: "x"}}, map[string]uint64{"watched": 0})
+	assert.Error(kvstore.ErrTransactionAborted, err)
+	assertNoEvent(t, events)
+
+	store.Transact([]kvstore.Operation{
+		{Command: kvstore.OperationUpsert, Key: "a", Value: "x"},
+		{Command: kvstore.OperationDelete, Key: "watched"},
+	}, nil)
+	assert.String("first", "a", receiveEvent(t, events).Key)
+	assert.String("second", "watched", receiveEvent(t, events).Key)
+}
+
+func TestWatchChannelClosesOnStopOverflowAndClose(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+	store.Open()
+
+	stopped, stop := store.Watch("")
+	stop()
+	stop()
+	_, isOpen := <-stopped
+	assert.False("open after stop", isOpen)
+
+	overflowed, stopOverflowed := store.Watch("")
+	defer stopOverflowed()
+	for i := 0; i <= kvstore.DefaultWatchBufferSize; i++ {
+		store.Upsert(fmt.Sprintf("key-%d", i), "value")
+	}
+	for range overflowed {
+	}
+
+	closed, stopClosed := store.Watch("")
+	store.Close()
+	_, isOpen = <-closed
+	assert.False("open after close", isOpen)
+	stopClosed()
+
+	afterClose, stopAfterClose := store.Watch("")
+	_, isOpen = <-afterClose
+	assert.False("open when watched after close", isOpen)
+	stopAfterClose()
+}
+
+func TestWatchOnStoreNeverOpenedReturnsClosedChannel(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	store := createTestObject()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		events, stop := store.Watch("")
+		_, isOpen := <-events
+		assert.False("open", isOpen)
+		stop()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("watch on a store never opened didn't return")
+	}
+}
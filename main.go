@@ -6,6 +6,7 @@ import (
 	"kvsapp/kvserver"
 	"kvsapp/kvstore"
 	"os"
+	"time"
 )
 
 const DefaultTcpPortNumber int = 8000
@@ -14,17 +15,101 @@ const DefaultUdpPortNumber int = 9000
 func main() {
 	var tcpport = DefaultTcpPortNumber
 	var udpport = DefaultUdpPortNumber
+	var logpath = ""
+	var logsync = "always"
+	var logsyncms = int(kvstore.DefaultLogSyncInterval / time.Millisecond)
+	var snapshotbytes int64 = 0
+	var snapshotsecs = 0
+	var backend = "memory"
+	var shards = kvstore.DefaultShardCount
+	var maxmemory int64 = 0
+	var maxkeys = 0
+	var eviction = "noeviction"
+	var maxkeylength = kvserver.DefaultMaxKeyLength
+	var maxvaluelength = kvserver.DefaultMaxValueLength
+	var maxframelength = kvserver.DefaultMaxFrameLength
+	var respport = 0
+	var textport = 0
+	var httpport = 0
 	flag.IntVar(&tcpport, "port", DefaultTcpPortNumber, "tcp port number to listen on")
 	flag.IntVar(&udpport, "udpport", DefaultUdpPortNumber, "udp port number to listen on")
+	flag.StringVar(&backend, "backend", "memory", "storage backend: memory, sharded or disk")
+	flag.IntVar(&shards, "shards", shards, "number of partitions for the sharded backend")
+	flag.StringVar(&logpath, "log", "", "write-ahead log file (empty for no persistence), or the data file for the disk backend")
+	flag.StringVar(&logsync, "logsync", "always", "log sync policy: always, interval or never")
+	flag.IntVar(&logsyncms, "logsyncms", logsyncms, "log sync interval in milliseconds")
+	flag.Int64Var(&snapshotbytes, "snapshotbytes", 0, "snapshot once the log reaches this size (0 to disable)")
+	flag.IntVar(&snapshotsecs, "snapshotsecs", 0, "snapshot at this interval in seconds (0 to disable)")
+	flag.Int64Var(&maxmemory, "maxmemory", 0, "bytes of keys plus values the store may hold (0 for no limit)")
+	flag.IntVar(&maxkeys, "maxkeys", 0, "number of keys the store may hold (0 for no limit)")
+	flag.StringVar(&eviction, "eviction", "noeviction", "eviction policy: noeviction, allkeys-lru, allkeys-lfu or volatile-ttl")
+	flag.IntVar(&maxkeylength, "maxkeylength", maxkeylength, "bytes in the largest key a client may send")
+	flag.IntVar(&maxvaluelength, "maxvaluelength", maxvaluelength, "bytes in the largest value a client may send")
+	flag.IntVar(&maxframelength, "maxframelength", maxframelength, "bytes in the largest message a client may send")
+	flag.IntVar(&respport, "respport", 0, "tcp port number to listen on for redis clients (0 for none)")
+	flag.IntVar(&textport, "textport", 0, "tcp port number to listen on for commands typed a line at a time (0 for none)")
+	flag.IntVar(&httpport, "httpport", 0, "tcp port number to listen on for http clients (0 for none)")
 	flag.Parse()
 
+	syncPolicy, err := kvstore.ParseLogSyncPolicy(logsync)
+	if err != nil {
+		fmt.Printf("store: error '%s'\n", err.Error())
+		os.Exit(-3)
+	}
+
+	evictionPolicy, err := kvstore.ParseEvictionPolicy(eviction)
+	if err != nil {
+		fmt.Printf("store: error '%s'\n", err.Error())
+		os.Exit(-3)
+	}
+
 	// create a new store...
-	store := kvstore.NewKvStore()
-	store.Open()
+	var store kvstore.Store
+	switch backend {
+	case "memory":
+		store = kvstore.NewKvStoreWithOptions(kvstore.KvStoreOptions{
+			LogPath:         logpath,
+			LogSyncPolicy:   syncPolicy,
+			LogSyncInterval: time.Duration(logsyncms) * time.Millisecond,
+
+			SnapshotLogSize:  snapshotbytes,
+			SnapshotInterval: time.Duration(snapshotsecs) * time.Second,
+
+			MaxMemory:      maxmemory,
+			MaxKeys:        maxkeys,
+			EvictionPolicy: evictionPolicy,
+		})
+	case "sharded":
+		store = kvstore.NewShardedStore(shards)
+	case "disk":
+		if logpath == "" {
+			fmt.Printf("store: error 'the disk backend needs a data file, given with -log'\n")
+			os.Exit(-3)
+		}
+		store = kvstore.NewDiskStore(kvstore.DiskStoreOptions{
+			Path:            logpath,
+			LogSyncPolicy:   syncPolicy,
+			LogSyncInterval: time.Duration(logsyncms) * time.Millisecond,
+		})
+	default:
+		fmt.Printf("store: error 'unknown backend %s'\n", backend)
+		os.Exit(-3)
+	}
+	if err := store.Open(); err != nil {
+		fmt.Printf("store: error '%s'\n", err.Error())
+		os.Exit(-3)
+	}
 	defer store.Close()
 
 	// create a new server...
-	server, err := kvserver.NewKvServer(tcpport, udpport, store)
+	server, err := kvserver.NewKvServerWithOptions(tcpport, udpport, store, kvserver.KvServerOptions{
+		MaxKeyLength:   maxkeylength,
+		MaxValueLength: maxvaluelength,
+		MaxFrameLength: maxframelength,
+		RespPort:       respport,
+		TextPort:       textport,
+		HttpPort:       httpport,
+	})
 	if err != nil {
 		fmt.Printf("server: error '%s'\n", err.Error())
 		os.Exit(-1)
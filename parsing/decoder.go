@@ -0,0 +1,126 @@
+package parsing
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+)
+
+// Decoder reads whole messages from a stream, in the same format and with the same grammar as
+// Parser, but reading each argument in one go rather than feeding the bytes through one at a time.
+type Decoder struct {
+	reader      *bufio.Reader
+	options     ParserOptions
+	frameLength int // bytes of the current message so far
+	commands    map[string]ParserGrammar
+}
+
+// the most arguments a variadic message has room made for up front, as its count is only a claim...
+const decoderPreallocatedArguments int = 64
+
+func NewDecoder(reader io.Reader, grammar map[string]ParserGrammar) (*Decoder, error) {
+	return NewDecoderWithOptions(reader, grammar, ParserOptions{})
+}
+
+func NewDecoderWithOptions(reader io.Reader, grammar map[string]ParserGrammar, options ParserOptions) (*Decoder, error) {
+	if reader == nil || grammar == nil || options.MaxKeyLength < 0 || options.MaxValueLength < 0 || options.MaxFrameLength < 0 {
+		return nil, ErrParserInvalidArgument
+	}
+	return &Decoder{reader: bufio.NewReader(reader), options: options, commands: grammar}, nil
+}
+
+// Decode reads the next message, blocking until it is complete. It returns io.EOF if the stream
+// ends between messages, and io.ErrUnexpectedEOF if it ends part way through one. As with Process,
+// ErrParserUnknownCommand and ErrParserBadFormat leave the stream just after the byte at fault, so
+// calling Decode again carries on from there. ErrParserTooLarge is returned before the argument at
+// fault is read, leaving it still to come, so the stream should be abandoned.
+func (d *Decoder) Decode() (command string, args []string, err error) {
+	d.frameLength = 3
+	commandBytes := make([]byte, 3)
+	if _, err := io.ReadFull(d.reader, commandBytes); err != nil {
+		return "", nil, err
+	}
+	command = string(commandBytes)
+	grammar, exists := d.commands[command]
+	if !exists {
+		return "", nil, ErrParserUnknownCommand
+	}
+
+	argsExpected := int(grammar.ExpectedArguments)
+	if grammar.Variadic {
+		digits, err := d.readLength()
+		if err != nil {
+			return "", nil, err
+		}
+		count, err := strconv.Atoi(digits)
+		if err != nil || count < 0 {
+			return "", nil, ErrParserBadFormat
+		}
+		if d.options.countTooLarge(count, d.frameLength) {
+			return "", nil, ErrParserTooLarge
+		}
+		argsExpected = count
+	}
+
+	preallocated := argsExpected
+	if preallocated > decoderPreallocatedArguments {
+		preallocated = decoderPreallocatedArguments
+	}
+	args = make([]string, 0, preallocated)
+	for len(args) < argsExpected {
+		digits, err := d.readLength()
+		if err != nil {
+			return "", nil, err
+		}
+		length, err := strconv.Atoi(digits)
+		switch {
+		case err != nil:
+			return "", nil, ErrParserBadFormat
+		case grammar.lengthIsValue(len(args)):
+			// the special extension format, where the length is the argument...
+			args = append(args, digits)
+		case length == 0 && (grammar.Variadic || grammar.AllowEmpty):
+			args = append(args, "")
+		case length > 0 && d.options.argumentTooLarge(len(args), length, d.frameLength):
+			return "", nil, ErrParserTooLarge
+		case length > 0:
+			arg := make([]byte, length)
+			if _, err := io.ReadFull(d.reader, arg); err != nil {
+				return "", nil, unexpectedEOF(err)
+			}
+			d.frameLength += length
+			args = append(args, string(arg))
+		default:
+			return "", nil, ErrParserBadFormat
+		}
+	}
+	return command, args, nil
+}
+
+// reads a length-of-length digit then that many digits, returning the digits...
+func (d *Decoder) readLength() (string, error) {
+	lengthLength, err := d.reader.ReadByte()
+	if err != nil {
+		return "", unexpectedEOF(err)
+	}
+	if lengthLength < '1' || lengthLength > '9' {
+		return "", ErrParserBadFormat
+	}
+	digits := make([]byte, lengthLength-'0')
+	if _, err := io.ReadFull(d.reader, digits); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	d.frameLength += 1 + len(digits)
+	if d.options.frameTooLarge(d.frameLength) {
+		return "", ErrParserTooLarge
+	}
+	return string(digits), nil
+}
+
+// the stream ending anywhere but between messages leaves one unfinished...
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
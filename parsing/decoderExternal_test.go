@@ -0,0 +1,211 @@
+package parsing_test
+
+import (
+	"bytes"
+	"io"
+	"kvsapp/assertions"
+	"kvsapp/parsing"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func createTestDecoder(data []byte) *parsing.Decoder {
+	result, _ := parsing.NewDecoder(bytes.NewReader(data), createTestGrammar())
+	return result
+}
+
+func TestDecoderSampleData(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	for testName, testData := range getSampleData() {
+		if !testData.enabled {
+			continue
+		}
+		// a reader handing over a byte at a time checks nothing relies on reading it all at once...
+		testObject, _ := parsing.NewDecoder(iotest.OneByteReader(bytes.NewReader(testData.bytes)), createTestGrammar())
+		command, args, err := testObject.Decode()
+
+		// where Process is left waiting for more, the decoder finds the stream has ended...
+		found := err == nil
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		assert.TestBoolean(testName, "found", testData.expectedFound, found)
+		assert.TestError(testName, testData.expectedProcessError, err)
+		if !found {
+			continue
+		}
+		assert.TestString(testName, "command", testData.expectedCommand, command)
+		assert.TestString(testName, "arg1", testData.expectedArg1, argument(args, 0))
+		assert.TestString(testName, "arg2", testData.expectedArg2, argument(args, 1))
+		assert.TestString(testName, "arg3", testData.expectedArg3, argument(args, 2))
+	}
+}
+
+func TestDecoderReadsConsecutiveMessages(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	value := strings.Repeat("v", 100000)
+	data, _ := parsing.CreateData("put", "key", value)
+	data = append(data, []byte("get13keybye")...)
+	testObject := createTestDecoder(data)
+
+	command, args, err := testObject.Decode()
+	assert.Error(nil, err)
+	assert.String("command", "put", command)
+	assert.String("arg1", "key", argument(args, 0))
+	assert.True("arg2", argument(args, 1) == value)
+
+	command, args, err = testObject.Decode()
+	assert.Error(nil, err)
+	assert.String("command", "get", command)
+	assert.Int("len(args)", 1, len(args))
+
+	command, _, err = testObject.Decode()
+	assert.Error(nil, err)
+	assert.String("command", "bye", command)
+
+	_, _, err = testObject.Decode()
+	assert.Error(io.EOF, err)
+}
+
+func TestDecoderCarriesOnAfterAnError(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestDecoder([]byte("xxxcm1xcm114arg1cm21"))
+
+	_, _, err := testObject.Decode()
+	assert.Error(parsing.ErrParserUnknownCommand, err)
+	_, _, err = testObject.Decode()
+	assert.Error(parsing.ErrParserBadFormat, err)
+	command, args, err := testObject.Decode()
+	assert.Error(nil, err)
+	assert.String("command", "cm1", command)
+	assert.String("arg1", "arg1", argument(args, 0))
+	_, _, err = testObject.Decode()
+	assert.Error(io.ErrUnexpectedEOF, err)
+}
+
+func TestDecoderReadsCreateMultiData(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	for _, values := range [][]string{{}, {"a"}, {"", "bc", strings.Repeat("d", 10)}} {
+		data, _ := parsing.CreateMultiData("var", values)
+		command, args, err := createTestDecoder(data).Decode()
+		assert.Error(nil, err)
+		assert.String("command", "var", command)
+		assert.String("args", strings.Join(values, ","), strings.Join(args, ","))
+		assert.Int("len(args)", len(values), len(args))
+	}
+}
+
+func TestNewDecoderReturnsErrorOnNilArgument(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	_, err := parsing.NewDecoder(nil, createTestGrammar())
+	assert.Error(parsing.ErrParserInvalidArgument, err)
+	_, err = parsing.NewDecoder(bytes.NewReader(nil), nil)
+	assert.Error(parsing.ErrParserInvalidArgument, err)
+}
+
+// hands over the same message forever, so a benchmark can decode as many as it needs...
+type repeatingReader struct {
+	data   []byte
+	offset int
+}
+
+func (r *repeatingReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		copied := copy(p[n:], r.data[r.offset:])
+		n += copied
+		r.offset = (r.offset + copied) % len(r.data)
+	}
+	return n, nil
+}
+
+func benchmarkProcess(b *testing.B, data []byte) {
+	testObject := createTestObject()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		for j := range data {
+			_, _ = testObject.Process(string(data[j : j+1]))
+		}
+		_, _, _ = testObject.GetMessage()
+	}
+}
+
+func benchmarkDecode(b *testing.B, data []byte) {
+	testObject, _ := parsing.NewDecoder(&repeatingReader{data: data}, createTestGrammar())
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		_, _, _ = testObject.Decode()
+	}
+}
+
+func BenchmarkProcessSmallMessage(b *testing.B) {
+	data, _ := parsing.CreateData("put", "key", "value")
+	benchmarkProcess(b, data)
+}
+
+func BenchmarkDecodeSmallMessage(b *testing.B) {
+	data, _ := parsing.CreateData("put", "key", "value")
+	benchmarkDecode(b, data)
+}
+
+func BenchmarkProcessLargeMessage(b *testing.B) {
+	data, _ := parsing.CreateData("put", "key", strings.Repeat("v", 64*1024))
+	benchmarkProcess(b, data)
+}
+
+func BenchmarkDecodeLargeMessage(b *testing.B) {
+	data, _ := parsing.CreateData("put", "key", strings.Repeat("v", 64*1024))
+	benchmarkDecode(b, data)
+}
+
+func FuzzCreateDataRoundTrip(f *testing.F) {
+	f.Add([]byte("key"), []byte("value"))
+	f.Add([]byte{0x00}, []byte{0x00, 0x7f, 0x80, 0xff})
+	f.Add([]byte("\xc3\x28"), []byte("\xe2\x82\x28"))
+
+	f.Fuzz(func(t *testing.T, key []byte, value []byte) {
+		if len(key) == 0 || len(value) == 0 {
+			t.Skip("CreateData omits empty arguments")
+		}
+		data, err := parsing.CreateData("put", string(key), string(value))
+		if err != nil {
+			t.Fatalf("create: %s", err.Error())
+		}
+
+		parser := createTestObject()
+		found := false
+		for i := range data {
+			if found, err = parser.Process(string(data[i : i+1])); err != nil {
+				t.Fatalf("process: %s", err.Error())
+			}
+		}
+		command, args, err := parser.GetMessage()
+		if !found || err != nil || command != "put" || argument(args, 0) != string(key) || argument(args, 1) != string(value) {
+			t.Errorf("param: process, expected: put %q %q, actual: %s %q (%v)", key, value, command, args, err)
+		}
+
+		command, args, err = createTestDecoder(data).Decode()
+		if err != nil || command != "put" || argument(args, 0) != string(key) || argument(args, 1) != string(value) {
+			t.Errorf("param: decode, expected: put %q %q, actual: %s %q (%v)", key, value, command, args, err)
+		}
+	})
+}
+
+func TestDecoderReadsReplies(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject, _ := parsing.NewDecoder(strings.NewReader("ackval10ver15value13123kys1211a11b"), parsing.ReplyGrammar())
+	for _, expected := range []string{"ack", "val|", "ver|value|123", "kys|a|b"} {
+		command, args, err := testObject.Decode()
+		assert.TestError(expected, nil, err)
+		assert.TestString(expected, "reply", expected, strings.Join(append([]string{command}, args...), "|"))
+	}
+	_, _, err := testObject.Decode()
+	assert.Error(io.EOF, err)
+}
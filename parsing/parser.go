@@ -7,188 +7,351 @@ import (
 )
 
 const stateBuildingCommand int = 0
-const stateBuildingArg1LengthLength int = 1
-const stateBuildingArg1Length int = 2
-const stateBuildingArg1 int = 3
-const stateBuildingArg2LengthLength int = 4
-const stateBuildingArg2Length int = 5
-const stateBuildingArg2 int = 6
-const stateWaitingForMessageDequeue int = 7
+const stateBuildingLengthLength int = 1
+const stateBuildingLength int = 2
+const stateBuildingArgument int = 3
+const stateWaitingForMessageDequeue int = 4
 const stateReset int = stateBuildingCommand
 
 var ErrParserInvalidArgument = errors.New("invalid argument")
 var ErrParserUnknownCommand = errors.New("unknown command")
 var ErrParserBadFormat = errors.New("bad format")
 var ErrParserNoMessage = errors.New("no message")
+var ErrParserTooLarge = errors.New("too large")
 
+// ParserGrammar describes the arguments a command takes. Each is sent as a length-of-length digit,
+// the length, then that many bytes; an argument whose LengthIsValue flag is set is sent as the
+// length-of-length digit then the digits of a number, which are the argument itself. A Variadic
+// command takes any number of arguments, sent as a count in the same form as a LengthIsValue
+// argument followed by the arguments themselves (the same layout CreateMultiData writes), and
+// unlike fixed arguments they may be empty; ExpectedArguments is then ignored. AllowEmpty lets a
+// command's fixed arguments be empty too, sent with a zero length.
 type ParserGrammar struct {
 	ExpectedArguments uint16
 	Arg1LengthIsValue bool
 	Arg2LengthIsValue bool
+	Variadic          bool
+	AllowEmpty        bool
 }
 
+// ParserOptions bounds what a parser will take in, so that a client can't make it hold an
+// arbitrarily large message; zero means no limit. A command's first argument is its key, and is
+// bounded by MaxKeyLength, and every other argument by MaxValueLength; MaxFrameLength bounds the
+// whole message, command and lengths included.
+type ParserOptions struct {
+	MaxKeyLength   int
+	MaxValueLength int
+	MaxFrameLength int
+}
+
+// the most bytes of an argument that room is made for before they arrive...
+const parserPreallocatedArgument int = 64 * 1024
+
 type Parser struct {
-	state             int
-	command           string
-	argsExpected      uint16
-	arg1LengthIsValue bool
-	arg1LengthLength  int
-	arg1LengthBuilder string
-	arg1Length        int
-	arg1              string
-	arg2LengthIsValue bool
-	arg2LengthLength  int
-	arg2LengthBuilder string
-	arg2Length        int
-	arg2              string
-	commands          map[string]ParserGrammar
+	options       ParserOptions
+	frameLength   int // bytes of the current message so far
+	state         int
+	command       string
+	grammar       ParserGrammar
+	argsExpected  int
+	counting      bool // building the count of a variadic command's arguments
+	lengthLength  int
+	lengthBuilder string
+	length        int
+	arg           []byte
+	args          []string
+	commands      map[string]ParserGrammar
 }
 
 func NewParser(grammar map[string]ParserGrammar) (*Parser, error) {
-	if grammar == nil {
+	return NewParserWithOptions(grammar, ParserOptions{})
+}
+
+func NewParserWithOptions(grammar map[string]ParserGrammar, options ParserOptions) (*Parser, error) {
+	if grammar == nil || options.MaxKeyLength < 0 || options.MaxValueLength < 0 || options.MaxFrameLength < 0 {
 		return nil, ErrParserInvalidArgument
 	}
-	result := &Parser{commands: grammar}
+	result := &Parser{options: options, commands: grammar}
 	result.reset()
 	return result, nil
 }
 
-func CreateData(command string, key string, value string) ([]byte, error) {
+// CreateData encodes a command and its arguments (typically a key then a value); empty trailing arguments are omitted.
+func CreateData(command string, args ...string) ([]byte, error) {
 	if len(command) != 3 {
 		return nil, errors.New("invalid argument: 'command' must have length of 3")
 	}
-	if len(value) > 0 && len(key) == 0 {
-		return nil, errors.New("invalid argument: cannot specify a 'value' with no 'key'")
-	}
 	result := command
-	if keyLength := len(key); keyLength > 0 {
-		keyLengthAsString := fmt.Sprintf("%d", keyLength)
-		result += fmt.Sprintf("%d%s%s", len(keyLengthAsString), keyLengthAsString, key)
-		if valueLength := len(value); valueLength > 0 {
-			valueLengthAsString := fmt.Sprintf("%d", valueLength)
-			result += fmt.Sprintf("%d%s%s", len(valueLengthAsString), valueLengthAsString, value)
+	for i, arg := range args {
+		argLength := len(arg)
+		if argLength == 0 {
+			for _, remaining := range args[i:] {
+				if len(remaining) > 0 {
+					return nil, errors.New("invalid argument: cannot specify an argument after an empty one")
+				}
+			}
+			break
 		}
+		argLengthAsString := fmt.Sprintf("%d", argLength)
+		result += fmt.Sprintf("%d%s%s", len(argLengthAsString), argLengthAsString, arg)
+	}
+	return []byte(result), nil
+}
+
+// CreateMultiData encodes a command followed by a count of values then each value in turn;
+// unlike CreateData, empty values are kept and encoded with a zero length.
+func CreateMultiData(command string, values []string) ([]byte, error) {
+	if len(command) != 3 {
+		return nil, errors.New("invalid argument: 'command' must have length of 3")
+	}
+	countAsString := fmt.Sprintf("%d", len(values))
+	result := fmt.Sprintf("%s%d%s", command, len(countAsString), countAsString)
+	for _, value := range values {
+		valueLengthAsString := fmt.Sprintf("%d", len(value))
+		result += fmt.Sprintf("%d%s%s", len(valueLengthAsString), valueLengthAsString, value)
 	}
 	return []byte(result), nil
 }
 
+// ParseMultiData decodes a whole frame made by CreateMultiData back into its command and values.
+func ParseMultiData(data []byte) (command string, values []string, err error) {
+	if len(data) < 3 {
+		return "", nil, ErrParserBadFormat
+	}
+	command, rest := string(data[:3]), string(data[3:])
+	readNumber := func() (int, bool) {
+		if len(rest) < 1 {
+			return 0, false
+		}
+		lengthLength, err := strconv.Atoi(rest[:1])
+		if err != nil || lengthLength <= 0 || len(rest) < 1+lengthLength {
+			return 0, false
+		}
+		number, err := strconv.Atoi(rest[1 : 1+lengthLength])
+		if err != nil || number < 0 {
+			return 0, false
+		}
+		rest = rest[1+lengthLength:]
+		return number, true
+	}
+	count, ok := readNumber()
+	if !ok {
+		return "", nil, ErrParserBadFormat
+	}
+	values = make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		length, ok := readNumber()
+		if !ok || len(rest) < length {
+			return "", nil, ErrParserBadFormat
+		}
+		values = append(values, rest[:length])
+		rest = rest[length:]
+	}
+	if len(rest) > 0 {
+		return "", nil, ErrParserBadFormat
+	}
+	return command, values, nil
+}
+
 func (p *Parser) reset() {
+	p.frameLength = 0
 	p.state = stateReset
 	p.command = ""
+	p.grammar = ParserGrammar{}
 	p.argsExpected = 0
-	p.arg1LengthIsValue = false
-	p.arg1LengthLength = 0
-	p.arg1LengthBuilder = ""
-	p.arg1Length = 0
-	p.arg1 = ""
-	p.arg2LengthIsValue = false
-	p.arg2LengthLength = 0
-	p.arg2LengthBuilder = ""
-	p.arg2Length = 0
-	p.arg2 = ""
-}
-
-func (p *Parser) GetMessage() (command string, arg1 string, arg2 string, err error) {
+	p.counting = false
+	p.resetArgument()
+	p.args = nil
+}
+
+func (p *Parser) resetArgument() {
+	p.lengthLength = 0
+	p.lengthBuilder = ""
+	p.length = 0
+	p.arg = nil
+}
+
+// whether the argument at index, of length bytes after the frameLength bytes so far, breaks a limit...
+func (options ParserOptions) argumentTooLarge(index int, length int, frameLength int) bool {
+	limit := options.MaxValueLength
+	if index == 0 {
+		limit = options.MaxKeyLength
+	}
+	if limit > 0 && length > limit {
+		return true
+	}
+	return options.frameTooLarge(frameLength + length)
+}
+
+// whether count arguments can't fit in what's left of the frame: each takes at least two bytes, a
+// length-of-length digit and a zero length, so without this a few bytes of count could claim far
+// more memory, in the arguments made for them, than the frame limit allows...
+func (options ParserOptions) countTooLarge(count int, frameLength int) bool {
+	return options.MaxFrameLength > 0 && count > (options.MaxFrameLength-frameLength)/2
+}
+
+func (options ParserOptions) frameTooLarge(frameLength int) bool {
+	return options.MaxFrameLength > 0 && frameLength > options.MaxFrameLength
+}
+
+// whether the argument at index is sent as the digits of its length alone...
+func (g ParserGrammar) lengthIsValue(index int) bool {
+	return (index == 0 && g.Arg1LengthIsValue) || (index == 1 && g.Arg2LengthIsValue)
+}
+
+// GetMessage returns the command and arguments of the message Process has found, readying the
+// parser for the next one.
+func (p *Parser) GetMessage() (command string, args []string, err error) {
 	if p.state == stateWaitingForMessageDequeue {
 		defer p.reset()
-		return p.command, p.arg1, p.arg2, nil
+		return p.command, p.args, nil
 	}
-	return "", "", "", ErrParserNoMessage
+	return "", nil, ErrParserNoMessage
 }
 
+// adds a finished argument, reporting whether it was the last...
+func (p *Parser) addArgument(arg string) bool {
+	p.args = append(p.args, arg)
+	p.resetArgument()
+	if len(p.args) == p.argsExpected {
+		p.state = stateWaitingForMessageDequeue
+		return true
+	}
+	p.state = stateBuildingLengthLength
+	return false
+}
+
+// Process takes the next byte of a message, reporting when the message is complete. Once it returns
+// ErrParserTooLarge the rest of the message is still to come, so the stream can't be trusted to
+// resync on the next command; the connection should be dropped.
 func (p *Parser) Process(datum string) (found bool, e error) {
+	if p.state != stateWaitingForMessageDequeue {
+		p.frameLength += len(datum)
+		if p.options.frameTooLarge(p.frameLength) {
+			p.reset()
+			return false, ErrParserTooLarge
+		}
+	}
 	switch p.state {
 	case stateBuildingCommand: // we're still waiting for a command...
 		p.command += datum
 		if len(p.command) == 3 {
 			// validate command...
-			if commandGrammar, exists := p.commands[p.command]; exists {
-				if commandGrammar.ExpectedArguments == 0 {
-					p.state = stateWaitingForMessageDequeue
-					return true, nil // we have a valid zero-arg message
-				}
-				p.argsExpected = commandGrammar.ExpectedArguments
-				p.arg1LengthIsValue = commandGrammar.Arg1LengthIsValue
-				p.arg2LengthIsValue = commandGrammar.Arg2LengthIsValue
-				p.state++
-			} else {
+			commandGrammar, exists := p.commands[p.command]
+			if !exists {
 				p.reset()
 				return false, ErrParserUnknownCommand
 			}
-		}
-	case stateBuildingArg1LengthLength: // we're waiting for the length of the arg1 length...
-		if v, err := strconv.Atoi(datum); err == nil && v > 0 {
-			p.arg1LengthLength = v
-			p.state++
-		} else {
-			p.reset()
-			return false, ErrParserBadFormat
-		}
-	case stateBuildingArg1Length: // we're waiting for the bytes of arg1 length...
-		p.arg1LengthBuilder += datum
-		if len(p.arg1LengthBuilder) == p.arg1LengthLength {
-			v, err := strconv.Atoi(p.arg1LengthBuilder)
-
-			if err == nil && !p.arg1LengthIsValue && v > 0 {
-				p.arg1Length = v
-				p.state++
-			} else if err == nil && p.arg1LengthIsValue {
-				p.arg1 = p.arg1LengthBuilder
-				if p.argsExpected == 1 {
-					p.state = stateWaitingForMessageDequeue
-					return true, nil // we have a valid one-arg message in the special extension format
-				}
-				p.state = stateBuildingArg2LengthLength // skip to building arg2
-			} else {
-				p.reset()
-				return false, ErrParserBadFormat
+			p.grammar = commandGrammar
+			if commandGrammar.Variadic {
+				p.counting = true
+				p.state = stateBuildingLengthLength
+				return false, nil
 			}
-		}
-	case stateBuildingArg1: // we're waiting for the bytes of arg1...
-		p.arg1 += datum
-		if len(p.arg1) == p.arg1Length {
-			if p.argsExpected == 1 {
+			if commandGrammar.ExpectedArguments == 0 {
 				p.state = stateWaitingForMessageDequeue
-				return true, nil // we have a valid one-arg message
+				return true, nil // we have a valid zero-arg message
 			}
-			p.state++
+			p.argsExpected = int(commandGrammar.ExpectedArguments)
+			p.args = make([]string, 0, p.argsExpected)
+			p.state = stateBuildingLengthLength
 		}
-	case stateBuildingArg2LengthLength: // we're waiting for the length of the arg2 length...
+	case stateBuildingLengthLength: // we're waiting for the length of the next argument's length...
 		if v, err := strconv.Atoi(datum); err == nil && v > 0 {
-			p.arg2LengthLength = v
-			p.state++
+			p.lengthLength = v
+			p.state = stateBuildingLength
 		} else {
 			p.reset()
 			return false, ErrParserBadFormat
 		}
-	case stateBuildingArg2Length: // we're waiting for the bytes of arg2 length...
-		p.arg2LengthBuilder += datum
-		if len(p.arg2LengthBuilder) == p.arg2LengthLength {
-			v, err := strconv.Atoi(p.arg2LengthBuilder)
-
-			if err == nil && !p.arg2LengthIsValue && v > 0 {
-				p.arg2Length = v
-				p.state++
-			} else if err == nil && p.arg2LengthIsValue {
-				p.arg2 = p.arg2LengthBuilder
-				p.state = stateWaitingForMessageDequeue
-				return true, nil // we have a valid two-arg message in the special extension format
-			} else {
+	case stateBuildingLength: // we're waiting for the bytes of the next argument's length...
+		p.lengthBuilder += datum
+		if len(p.lengthBuilder) < p.lengthLength {
+			break
+		}
+		v, err := strconv.Atoi(p.lengthBuilder)
+		switch {
+		case err != nil:
+			p.reset()
+			return false, ErrParserBadFormat
+		case p.counting:
+			if v < 0 {
 				p.reset()
 				return false, ErrParserBadFormat
 			}
-		}
-	case stateBuildingArg2: // we're waiting for the bytes of arg2...
-		p.arg2 += datum
-		if len(p.arg2) == p.arg2Length {
-			p.state++
-			if p.argsExpected == 2 {
+			if p.options.countTooLarge(v, p.frameLength) {
+				p.reset()
+				return false, ErrParserTooLarge
+			}
+			p.counting = false
+			p.argsExpected = v
+			p.resetArgument()
+			if v == 0 {
+				p.args = []string{}
 				p.state = stateWaitingForMessageDequeue
-				return true, nil // we have a valid two-arg message
+				return true, nil // we have a valid variadic message with no arguments
 			}
+			p.state = stateBuildingLengthLength
+		case p.grammar.lengthIsValue(len(p.args)):
+			// the special extension format, where the length is the argument...
+			return p.addArgument(p.lengthBuilder), nil
+		case v == 0 && (p.grammar.Variadic || p.grammar.AllowEmpty):
+			return p.addArgument(""), nil
+		case v > 0 && p.options.argumentTooLarge(len(p.args), v, p.frameLength):
+			p.reset()
+			return false, ErrParserTooLarge
+		case v > 0:
+			// the length is only a claim, so room is made as the bytes arrive beyond the first few...
+			p.length = v
+			p.arg = make([]byte, 0, minInt(v, parserPreallocatedArgument))
+			p.state = stateBuildingArgument
+		default:
+			p.reset()
+			return false, ErrParserBadFormat
+		}
+	case stateBuildingArgument: // we're waiting for the bytes of the argument...
+		p.arg = append(p.arg, datum...)
+		if len(p.arg) == p.length {
+			return p.addArgument(string(p.arg)), nil
 		}
 	case stateWaitingForMessageDequeue: // we're waiting for GetMessage() to be called...
 		// nop
 	}
 	return false, nil // we need more data
 }
+
+// ProcessBytes takes the next bytes of a stream, stopping after the first message it completes. It
+// returns how many bytes it consumed, so the rest can be passed again once the message has been
+// taken with GetMessage; until then it consumes nothing. The bytes of an argument are copied in
+// whole runs, rather than one at a time as with Process, and errors are as from Process.
+func (p *Parser) ProcessBytes(data []byte) (consumed int, found bool, e error) {
+	if p.state == stateWaitingForMessageDequeue {
+		return 0, true, nil
+	}
+	for consumed < len(data) {
+		if p.state == stateBuildingArgument {
+			run := minInt(p.length-len(p.arg), len(data)-consumed)
+			// the argument's length was checked against the frame when it was read...
+			p.frameLength += run
+			p.arg = append(p.arg, data[consumed:consumed+run]...)
+			consumed += run
+			if len(p.arg) == p.length && p.addArgument(string(p.arg)) {
+				return consumed, true, nil
+			}
+			continue
+		}
+		found, err := p.Process(string(data[consumed : consumed+1]))
+		consumed++
+		if found || err != nil {
+			return consumed, found, err
+		}
+	}
+	return consumed, false, nil
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -3,8 +3,10 @@ package parsing_test
 import (
 	"kvsapp/assertions"
 	"kvsapp/parsing"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 type sampleData struct {
@@ -13,23 +15,32 @@ type sampleData struct {
 	expectedCommand      string
 	expectedArg1         string
 	expectedArg2         string
+	expectedArg3         string
 	expectedFound        bool
 	expectedProcessError error
 }
 
 func createTestObject() *parsing.Parser {
-	result, _ := parsing.NewParser(map[string]parsing.ParserGrammar{
+	result, _ := parsing.NewParser(createTestGrammar())
+	return result
+}
+
+func createTestGrammar() map[string]parsing.ParserGrammar {
+	return map[string]parsing.ParserGrammar{
 		"cm0": {ExpectedArguments: 0},
 		"cm1": {ExpectedArguments: 1},
 		"cm2": {ExpectedArguments: 2},
+		"cm3": {ExpectedArguments: 3},
+		"a2v": {ExpectedArguments: 3, Arg2LengthIsValue: true},
 		"a1v": {ExpectedArguments: 1, Arg1LengthIsValue: true},
 		"hed": {ExpectedArguments: 2, Arg2LengthIsValue: true},
 		"put": {ExpectedArguments: 2},
 		"get": {ExpectedArguments: 1},
 		"del": {ExpectedArguments: 1},
 		"bye": {ExpectedArguments: 0},
-	})
-	return result
+		"var": {Variadic: true},
+		"emp": {ExpectedArguments: 2, AllowEmpty: true},
+	}
 }
 
 func getSampleData() map[string]sampleData {
@@ -115,6 +126,36 @@ func getSampleData() map[string]sampleData {
 			expectedFound:        true,
 			expectedProcessError: nil,
 		},
+		"3-args": {
+			enabled:              true,
+			bytes:                []byte("cm314arg114arg214arg3"),
+			expectedCommand:      "cm3",
+			expectedArg1:         "arg1",
+			expectedArg2:         "arg2",
+			expectedArg3:         "arg3",
+			expectedFound:        true,
+			expectedProcessError: nil,
+		},
+		"3-args-skips": {
+			enabled:              true,
+			bytes:                []byte("cm314arg114arg214arg3xxx"),
+			expectedCommand:      "cm3",
+			expectedArg1:         "arg1",
+			expectedArg2:         "arg2",
+			expectedArg3:         "arg3",
+			expectedFound:        true,
+			expectedProcessError: nil,
+		},
+		"arg2 length is value with arg3": {
+			enabled:              true,
+			bytes:                []byte("a2v14arg121514arg3"),
+			expectedCommand:      "a2v",
+			expectedArg1:         "arg1",
+			expectedArg2:         "15",
+			expectedArg3:         "arg3",
+			expectedFound:        true,
+			expectedProcessError: nil,
+		},
 		"bad format (1)": {
 			enabled:              true,
 			bytes:                []byte("cm10"),
@@ -187,6 +228,24 @@ func getSampleData() map[string]sampleData {
 			expectedFound:        false,
 			expectedProcessError: parsing.ErrParserBadFormat,
 		},
+		"bad format (9)": {
+			enabled:              true,
+			bytes:                []byte("cm311a11b0"),
+			expectedCommand:      "",
+			expectedArg1:         "",
+			expectedArg2:         "",
+			expectedFound:        false,
+			expectedProcessError: parsing.ErrParserBadFormat,
+		},
+		"bad format (10)": {
+			enabled:              true,
+			bytes:                []byte("cm311a11b10"),
+			expectedCommand:      "",
+			expectedArg1:         "",
+			expectedArg2:         "",
+			expectedFound:        false,
+			expectedProcessError: parsing.ErrParserBadFormat,
+		},
 		"unknown command": {
 			enabled:              true,
 			bytes:                []byte("xxx"),
@@ -241,6 +300,15 @@ func getSampleData() map[string]sampleData {
 			expectedFound:        true,
 			expectedProcessError: nil,
 		},
+		"empty arguments allowed": {
+			enabled:              true,
+			bytes:                []byte("emp1013abc"),
+			expectedCommand:      "emp",
+			expectedArg1:         "",
+			expectedArg2:         "abc",
+			expectedFound:        true,
+			expectedProcessError: nil,
+		},
 		"bye command": {
 			enabled:              true,
 			bytes:                []byte("bye"),
@@ -291,11 +359,12 @@ func TestSampleData(t *testing.T) {
 				return
 			}
 
-			getMessageCommand, getMessageArg1, getMessageArg2, getMessageError := testObject.GetMessage()
+			getMessageCommand, getMessageArgs, getMessageError := testObject.GetMessage()
 
 			assert.TestString(testName, "command", testData.expectedCommand, getMessageCommand)
-			assert.TestString(testName, "arg1", testData.expectedArg1, getMessageArg1)
-			assert.TestString(testName, "arg2", testData.expectedArg2, getMessageArg2)
+			assert.TestString(testName, "arg1", testData.expectedArg1, argument(getMessageArgs, 0))
+			assert.TestString(testName, "arg2", testData.expectedArg2, argument(getMessageArgs, 1))
+			assert.TestString(testName, "arg3", testData.expectedArg3, argument(getMessageArgs, 2))
 			assert.TestError(testName, nil, getMessageError)
 
 		}(t, testName, testData)
@@ -303,6 +372,14 @@ func TestSampleData(t *testing.T) {
 	wait.Wait()
 }
 
+// the argument at index, or empty if there are fewer arguments...
+func argument(args []string, index int) string {
+	if index < len(args) {
+		return args[index]
+	}
+	return ""
+}
+
 func compareSlices(a []byte, b []byte) bool {
 	if len(a) != len(b) {
 		return false
@@ -320,10 +397,9 @@ func TestGetMessageReturnsErrorWhenNoMessageReady(t *testing.T) {
 	assert := assertions.NewAssert(t)
 	testObject, _ := parsing.NewParser(map[string]parsing.ParserGrammar{"cmd": {ExpectedArguments: 0}})
 	testObject.Process("a")
-	getMessageCommand, getMessageArg1, getMessageArg2, getMessageError := testObject.GetMessage()
+	getMessageCommand, getMessageArgs, getMessageError := testObject.GetMessage()
 	assert.String("command", "", getMessageCommand)
-	assert.String("arg1", "", getMessageArg1)
-	assert.String("arg2", "", getMessageArg2)
+	assert.Int("len(args)", 0, len(getMessageArgs))
 	assert.Error(parsing.ErrParserNoMessage, getMessageError)
 }
 
@@ -405,6 +481,85 @@ func TestCreateDataWithKey(t *testing.T) {
 	}
 }
 
+func TestCreateDataWithThreeArguments(t *testing.T) {
+	t.Parallel()
+	expected := []byte("CMD17KEYNAME18EXPECTED13NEW")
+	actual, _ := parsing.CreateData("CMD", "KEYNAME", "EXPECTED", "NEW")
+	if !compareSlices(actual, expected) {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+}
+
+func TestCreateMultiDataKeepsEmptyValues(t *testing.T) {
+	t.Parallel()
+	expected := []byte("kvs131011A13one")
+	actual, _ := parsing.CreateMultiData("kvs", []string{"", "A", "one"})
+	if !compareSlices(actual, expected) {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+}
+
+func TestCreateMultiDataWithNoValues(t *testing.T) {
+	t.Parallel()
+	expected := []byte("kvs10")
+	actual, _ := parsing.CreateMultiData("kvs", nil)
+	if !compareSlices(actual, expected) {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+}
+
+func TestVariadicCommandReadsCreateMultiData(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	for _, values := range [][]string{{}, {"a"}, {"", "bc", strings.Repeat("d", 10)}} {
+		testObject := createTestObject()
+		data, _ := parsing.CreateMultiData("var", values)
+		found, err := false, error(nil)
+		for _, b := range data {
+			found, err = testObject.Process(string(b))
+		}
+		assert.True("found", found)
+		assert.Error(nil, err)
+		command, args, err := testObject.GetMessage()
+		assert.Error(nil, err)
+		assert.String("command", "var", command)
+		assert.String("args", strings.Join(values, ","), strings.Join(args, ","))
+		assert.Int("len(args)", len(values), len(args))
+	}
+	testObject := createTestObject()
+	found, err := false, error(nil)
+	for _, b := range []byte("var1x") {
+		if found, err = testObject.Process(string(b)); err != nil {
+			break
+		}
+	}
+	assert.False("found", found)
+	assert.Error(parsing.ErrParserBadFormat, err)
+}
+
+func TestParseMultiDataReversesCreateMultiData(t *testing.T) {
+	t.Parallel()
+	values := []string{"", "A", strings.Repeat("x", 12)}
+	data, _ := parsing.CreateMultiData("kvs", values)
+	command, actual, err := parsing.ParseMultiData(data)
+	if err != nil || command != "kvs" || strings.Join(actual, ",") != strings.Join(values, ",") {
+		t.Errorf("expected: kvs %v, actual: %s %v (%v)", values, command, actual, err)
+	}
+	for _, bad := range []string{"kv", "kvs", "kvs12", "kvs1113ab", "kvs1111ab", "kvs1x"} {
+		if _, _, err := parsing.ParseMultiData([]byte(bad)); err != parsing.ErrParserBadFormat {
+			t.Errorf("param: %s, expected: %v, actual: %v", bad, parsing.ErrParserBadFormat, err)
+		}
+	}
+}
+
+func TestCreateDataOnArgumentAfterEmptyArgumentErrorIsReturned(t *testing.T) {
+	t.Parallel()
+	_, err := parsing.CreateData("aaa", "key", "", "vvvvvvv")
+	if err == nil {
+		t.Error("expected: error, actual: nil")
+	}
+}
+
 func TestCreateDataWithKeyAndValue(t *testing.T) {
 	t.Parallel()
 	expected := []byte("CMD17KEYNAME220SOME ARBITRARY VALUE")
@@ -421,7 +576,7 @@ func BenchmarkProcessZeroArgumentMessage(b *testing.B) {
 		_, _ = testObject.Process("c")
 		_, _ = testObject.Process("m")
 		_, _ = testObject.Process("0")
-		_, _, _, _ = testObject.GetMessage()
+		_, _, _ = testObject.GetMessage()
 	}
 }
 
@@ -435,7 +590,7 @@ func BenchmarkProcessOneArgumentMessage(b *testing.B) {
 		_, _ = testObject.Process("1")
 		_, _ = testObject.Process("1")
 		_, _ = testObject.Process("a")
-		_, _, _, _ = testObject.GetMessage()
+		_, _, _ = testObject.GetMessage()
 	}
 }
 
@@ -452,6 +607,134 @@ func BenchmarkProcessTwoArgumentMessage(b *testing.B) {
 		_, _ = testObject.Process("1")
 		_, _ = testObject.Process("1")
 		_, _ = testObject.Process("b")
-		_, _, _, _ = testObject.GetMessage()
+		_, _, _ = testObject.GetMessage()
+	}
+}
+
+func TestSizeLimits(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	options := parsing.ParserOptions{MaxKeyLength: 3, MaxValueLength: 5, MaxFrameLength: 20}
+	for _, testCase := range []struct {
+		data     string
+		expected error
+	}{
+		{"put13key15value", nil},
+		{"put14keys", parsing.ErrParserTooLarge},
+		{"put13key16values", parsing.ErrParserTooLarge},
+		{"put9999999999", parsing.ErrParserTooLarge},
+		{"cm313abc15abcde15abcde", parsing.ErrParserTooLarge},
+		{"var1499991010101010101010", parsing.ErrParserTooLarge},
+		{"var2101010101010", parsing.ErrParserTooLarge},
+		{"var1710101010101010", nil},
+	} {
+		testObject, _ := parsing.NewParserWithOptions(createTestGrammar(), options)
+		found, err := false, error(nil)
+		for i := 0; i < len(testCase.data) && !found && err == nil; i++ {
+			found, err = testObject.Process(testCase.data[i : i+1])
+		}
+		assert.TestError(testCase.data, testCase.expected, err)
+		assert.TestBoolean(testCase.data, "found", testCase.expected == nil, found)
+
+		decoder, _ := parsing.NewDecoderWithOptions(strings.NewReader(testCase.data), createTestGrammar(), options)
+		_, _, err = decoder.Decode()
+		assert.TestError(testCase.data, testCase.expected, err)
+	}
+
+	// a limit of zero is no limit...
+	testObject, _ := parsing.NewParserWithOptions(createTestGrammar(), parsing.ParserOptions{})
+	data, _ := parsing.CreateData("put", strings.Repeat("k", 100), strings.Repeat("v", 1000))
+	found, err := false, error(nil)
+	for i := range data {
+		found, err = testObject.Process(string(data[i : i+1]))
+	}
+	assert.True("found", found)
+	assert.Error(nil, err)
+}
+
+func TestNewParserWithOptionsReturnsErrorOnNegativeLimit(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	_, err := parsing.NewParserWithOptions(createTestGrammar(), parsing.ParserOptions{MaxValueLength: -1})
+	assert.Error(parsing.ErrParserInvalidArgument, err)
+	_, err = parsing.NewDecoderWithOptions(strings.NewReader(""), createTestGrammar(), parsing.ParserOptions{MaxFrameLength: -1})
+	assert.Error(parsing.ErrParserInvalidArgument, err)
+}
+
+func TestProcessBytesStopsAfterEachMessage(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject := createTestObject()
+	data := []byte("put13key15valuecm0get13key")
+
+	consumed, found, err := testObject.ProcessBytes(data)
+	assert.Error(nil, err)
+	assert.True("found", found)
+	assert.Int("consumed", 15, consumed)
+	// nothing more is taken until the message has been...
+	consumed, found, _ = testObject.ProcessBytes(data[15:])
+	assert.Int("consumed while waiting", 0, consumed)
+	assert.True("still found", found)
+	command, args, _ := testObject.GetMessage()
+	assert.String("command", "put", command)
+	assert.String("arg2", "value", argument(args, 1))
+
+	consumed, found, _ = testObject.ProcessBytes(data[15:])
+	assert.Int("consumed", 3, consumed)
+	assert.True("found", found)
+	command, _, _ = testObject.GetMessage()
+	assert.String("command", "cm0", command)
+
+	// a message split across calls carries on where it left off...
+	consumed, found, _ = testObject.ProcessBytes(data[18:22])
+	assert.Int("consumed", 4, consumed)
+	assert.False("found", found)
+	consumed, found, _ = testObject.ProcessBytes(data[22:])
+	assert.Int("consumed", 4, consumed)
+	assert.True("found", found)
+	_, args, _ = testObject.GetMessage()
+	assert.String("arg1", "key", argument(args, 0))
+
+	_, _, err = testObject.ProcessBytes([]byte("xxx"))
+	assert.Error(parsing.ErrParserUnknownCommand, err)
+}
+
+func TestLargeValuesParseInLinearTime(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	value := strings.Repeat("v", 8*1024*1024)
+	data, _ := parsing.CreateData("put", "key", value)
+	started := time.Now()
+
+	// fed in reads the size a server makes, as a connection would be...
+	testObject := createTestObject()
+	found := false
+	for offset := 0; offset < len(data) && !found; {
+		consumed, result, err := testObject.ProcessBytes(data[offset:minInt(offset+4096, len(data))])
+		assert.Error(nil, err)
+		offset, found = offset+consumed, result
+	}
+	_, args, _ := testObject.GetMessage()
+	assert.True("found", found)
+	assert.True("value", argument(args, 1) == value)
+
+	// and a byte at a time, which once copied the argument on every byte...
+	testObject = createTestObject()
+	for i := range data {
+		found, _ = testObject.Process(string(data[i : i+1]))
+	}
+	_, args, _ = testObject.GetMessage()
+	assert.True("found", found)
+	assert.True("value", argument(args, 1) == value)
+
+	if elapsed := time.Since(started); elapsed > 20*time.Second {
+		t.Errorf("param: elapsed, expected: under 20s, actual: %s", elapsed)
+	}
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
 	}
+	return b
 }
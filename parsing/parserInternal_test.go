@@ -39,51 +39,37 @@ func assertGrammar(t *testing.T, testObject *Parser, testGrammar map[string]Pars
 	}
 }
 
-func assertState(t *testing.T, testObject *Parser, expectedState int, expectedCommand string, expectedArgsExpected uint16) {
+func assertState(t *testing.T, testObject *Parser, expectedState int, expectedCommand string, expectedArgsExpected int) {
 	assert := assertions.NewAssert(t)
 	if testObject.state != expectedState {
 		t.Errorf("param: %s, expected: %d, actual: %d", "state", expectedState, testObject.state)
 	}
 	assert.String("command", expectedCommand, testObject.command)
-	if testObject.argsExpected != expectedArgsExpected {
-		t.Errorf("param: %s, expected: %d, actual: %d", "argsExpected", expectedArgsExpected, testObject.argsExpected)
-	}
+	assert.Int("argsExpected", expectedArgsExpected, testObject.argsExpected)
 }
 
-func assertArg1(t *testing.T, testObject *Parser, expectedArg1LengthIsValue bool, expectedArg1LengthLength int, expectedArg1LengthBuilder string, expectedArg1Length int, expectedArg1 string) {
+func assertArgument(t *testing.T, testObject *Parser, expectedLengthLength int, expectedLengthBuilder string, expectedLength int, expectedArg string) {
 	assert := assertions.NewAssert(t)
-	assert.Boolean("arg1LengthIsValue", expectedArg1LengthIsValue, testObject.arg1LengthIsValue)
-	if testObject.arg1LengthLength != expectedArg1LengthLength {
-		t.Errorf("param: %s, expected: %d, actual: %d", "arg1LengthLength", expectedArg1LengthLength, testObject.arg1LengthLength)
-	}
-	if testObject.arg1LengthBuilder != expectedArg1LengthBuilder {
-		t.Errorf("param: %s, expected: %s, actual: %s", "arg1LengthBuilder", expectedArg1LengthBuilder, testObject.arg1LengthBuilder)
-	}
-	if testObject.arg1Length != expectedArg1Length {
-		t.Errorf("param: %s, expected: %d, actual: %d", "arg1Length", expectedArg1Length, testObject.arg1Length)
-	}
-	assert.String("arg1", expectedArg1, testObject.arg1)
+	assert.Int("lengthLength", expectedLengthLength, testObject.lengthLength)
+	assert.String("lengthBuilder", expectedLengthBuilder, testObject.lengthBuilder)
+	assert.Int("length", expectedLength, testObject.length)
+	assert.String("arg", expectedArg, string(testObject.arg))
 }
 
-func assertArg2(t *testing.T, testObject *Parser, expectedArg2LengthIsValue bool, expectedArg2LengthLength int, expectedArg2LengthBuilder string, expectedArg2Length int, expectedArg2 string) {
+func assertArgs(t *testing.T, testObject *Parser, expectedArgs ...string) {
 	assert := assertions.NewAssert(t)
-	assert.Boolean("arg2LengthIsValue", expectedArg2LengthIsValue, testObject.arg2LengthIsValue)
-	if testObject.arg2LengthLength != expectedArg2LengthLength {
-		t.Errorf("param: %s, expected: %d, actual: %d", "arg2LengthLength", expectedArg2LengthLength, testObject.arg2LengthLength)
+	assert.Int("len(args)", len(expectedArgs), len(testObject.args))
+	for i := 0; i < len(expectedArgs) && i < len(testObject.args); i++ {
+		assert.String(fmt.Sprintf("args[%d]", i), expectedArgs[i], testObject.args[i])
 	}
-	if testObject.arg2LengthBuilder != expectedArg2LengthBuilder {
-		t.Errorf("param: %s, expected: %s, actual: %s", "arg2LengthBuilder", expectedArg2LengthBuilder, testObject.arg2LengthBuilder)
-	}
-	if testObject.arg2Length != expectedArg2Length {
-		t.Errorf("param: %s, expected: %d, actual: %d", "arg2Length", expectedArg2Length, testObject.arg2Length)
-	}
-	assert.String("arg2", expectedArg2, testObject.arg2)
 }
 
 func assertResetState(t *testing.T, testObject *Parser, testGrammar map[string]ParserGrammar) {
 	assertState(t, testObject, stateReset, "", 0)
-	assertArg1(t, testObject, false, 0, "", 0, "")
-	assertArg2(t, testObject, false, 0, "", 0, "")
+	assertArgument(t, testObject, 0, "", 0, "")
+	assertArgs(t, testObject)
+	assertions.NewAssert(t).False("counting", testObject.counting)
+	assertions.NewAssert(t).Int("frameLength", 0, testObject.frameLength)
 	assertGrammar(t, testObject, testGrammar)
 }
 
@@ -109,17 +95,15 @@ func TestResetClearsState(t *testing.T) {
 	testObject, _ := NewParser(testGrammar)
 	testObject.state = 123
 	testObject.command = "abc"
+	testObject.grammar = ParserGrammar{ExpectedArguments: 3, Arg1LengthIsValue: true}
 	testObject.argsExpected = 234
-	testObject.arg1LengthIsValue = true
-	testObject.arg1LengthLength = 456
-	testObject.arg1LengthBuilder = "def"
-	testObject.arg1Length = 567
-	testObject.arg1 = "ghi"
-	testObject.arg2LengthIsValue = true
-	testObject.arg2LengthLength = 678
-	testObject.arg2LengthBuilder = "jkl"
-	testObject.arg2Length = 789
-	testObject.arg2 = "mno"
+	testObject.counting = true
+	testObject.lengthLength = 456
+	testObject.lengthBuilder = "def"
+	testObject.length = 567
+	testObject.arg = []byte("ghi")
+	testObject.args = []string{"jkl", "mno"}
+	testObject.frameLength = 678
 	testObject.reset()
 	assertResetState(t, testObject, testGrammar)
 }
@@ -205,17 +189,17 @@ func TestProcessKnownOneArgumentCommand(t *testing.T) {
 	found, err = testObject.Process(ExpectedCommand[2:3])
 	assert.False("found", found)
 	assert.Error(nil, err)
-	assertState(t, testObject, stateBuildingArg1LengthLength, ExpectedCommand, 1)
+	assertState(t, testObject, stateBuildingLengthLength, ExpectedCommand, 1)
 
 	found, err = testObject.Process("1")
 	assert.False("found", found)
 	assert.Error(nil, err)
-	assertState(t, testObject, stateBuildingArg1Length, ExpectedCommand, 1)
+	assertState(t, testObject, stateBuildingLength, ExpectedCommand, 1)
 
 	found, err = testObject.Process(fmt.Sprintf("%d", len(ExpectedArg1)))
 	assert.False("found", found)
 	assert.Error(nil, err)
-	assertState(t, testObject, stateBuildingArg1, ExpectedCommand, 1)
+	assertState(t, testObject, stateBuildingArgument, ExpectedCommand, 1)
 
 	for _, r := range ExpectedArg1 {
 		found, err = testObject.Process(string(r))
@@ -223,21 +207,22 @@ func TestProcessKnownOneArgumentCommand(t *testing.T) {
 	assert.True("found", found)
 	assert.Error(nil, err)
 	assertState(t, testObject, stateWaitingForMessageDequeue, ExpectedCommand, 1)
+	assertArgs(t, testObject, ExpectedArg1)
 }
 
 func TestGetMessageResetsState(t *testing.T) {
 	const ExpectedCommand string = "qwe"
 	const ExpectedArg1 string = "arg1value"
 	const expectedArg2 string = "arg2value"
+	const expectedArg3 string = "arg3value"
 	t.Parallel()
 
 	testGrammar := map[string]ParserGrammar{"ghj": {ExpectedArguments: 0}}
 	testObject, _ := NewParser(testGrammar)
 	testObject.state = stateWaitingForMessageDequeue
 	testObject.command = ExpectedCommand
-	testObject.arg1 = ExpectedArg1
-	testObject.arg2 = expectedArg2
-	_, _, _, _ = testObject.GetMessage()
+	testObject.args = []string{ExpectedArg1, expectedArg2, expectedArg3}
+	_, _, _ = testObject.GetMessage()
 
 	assertResetState(t, testObject, testGrammar)
 }
@@ -246,18 +231,50 @@ func TestGetMessageReturnsInternalState(t *testing.T) {
 	const ExpectedCommand string = "qwe"
 	const ExpectedArg1 string = "arg1value"
 	const expectedArg2 string = "arg2value"
+	const expectedArg3 string = "arg3value"
 	t.Parallel()
 	assert := assertions.NewAssert(t)
 
 	testObject, _ := NewParser(map[string]ParserGrammar{})
 	testObject.state = stateWaitingForMessageDequeue
 	testObject.command = ExpectedCommand
-	testObject.arg1 = ExpectedArg1
-	testObject.arg2 = expectedArg2
-	command, arg1, arg2, err := testObject.GetMessage()
+	testObject.args = []string{ExpectedArg1, expectedArg2, expectedArg3}
+	command, args, err := testObject.GetMessage()
 
 	assert.String("command", ExpectedCommand, command)
-	assert.String("arg1", ExpectedArg1, arg1)
-	assert.String("arg2", expectedArg2, arg2)
+	assert.Int("len(args)", 3, len(args))
+	assert.String("arg1", ExpectedArg1, args[0])
+	assert.String("arg2", expectedArg2, args[1])
+	assert.String("arg3", expectedArg3, args[2])
+	assert.Error(nil, err)
+}
+
+func TestProcessKnownVariadicCommand(t *testing.T) {
+	t.Parallel()
+	assert := assertions.NewAssert(t)
+	testObject, _ := NewParser(map[string]ParserGrammar{"var": {Variadic: true}})
+
+	found := false
+	err := error(nil)
+	for _, r := range "var13" {
+		found, err = testObject.Process(string(r))
+	}
+	assert.False("found", found)
+	assert.Error(nil, err)
+	assertState(t, testObject, stateBuildingLengthLength, "var", 3)
+	assert.False("counting", testObject.counting)
+
+	for _, r := range "11a10" {
+		found, err = testObject.Process(string(r))
+	}
+	assert.False("found", found)
+	assertArgs(t, testObject, "a", "")
+
+	for _, r := range "12bc" {
+		found, err = testObject.Process(string(r))
+	}
+	assert.True("found", found)
 	assert.Error(nil, err)
+	assertState(t, testObject, stateWaitingForMessageDequeue, "var", 3)
+	assertArgs(t, testObject, "a", "", "bc")
 }
@@ -0,0 +1,33 @@
+package parsing
+
+// ReplyGrammar returns the grammar of the replies a server sends, for reading them back: a kind
+// that carries nothing, a value, a version, or a count followed by the values.
+func ReplyGrammar() map[string]ParserGrammar {
+	return map[string]ParserGrammar{
+		"ack": {ExpectedArguments: 0},
+		"err": {ExpectedArguments: 0},
+		"nil": {ExpectedArguments: 0},
+		"mis": {ExpectedArguments: 0},
+		"nan": {ExpectedArguments: 0},
+		"oom": {ExpectedArguments: 0},
+		"stl": {ExpectedArguments: 0},
+		"wty": {ExpectedArguments: 0},
+		"qud": {ExpectedArguments: 0},
+		"abt": {ExpectedArguments: 0},
+		"exc": {ExpectedArguments: 0},
+		"val": {ExpectedArguments: 1, AllowEmpty: true},
+		"vsn": {ExpectedArguments: 1},
+		"lsx": {ExpectedArguments: 1},
+		"ver": {ExpectedArguments: 2},
+		"kvs": {Variadic: true},
+		"kys": {Variadic: true},
+		"vsm": {Variadic: true},
+		"mem": {Variadic: true},
+		"hsh": {Variadic: true},
+		"lst": {Variadic: true},
+		"set": {Variadic: true},
+		"chg": {Variadic: true},
+		"msg": {Variadic: true},
+		"pms": {Variadic: true},
+	}
+}